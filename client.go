@@ -2,26 +2,84 @@
 package datahub
 
 import (
+	"bytes"
 	"context"
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/google/uuid"
 	egdm "github.com/mimiro-io/entity-graph-data-model"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
+	"golang.org/x/time/rate"
 )
 
 type EntityIterator interface {
 	Context() *egdm.Context
 	Next() (*egdm.Entity, error)
 	Token() *egdm.Continuation
+	// Close releases the resources held by the iterator. Next must not be called after Close.
+	Close() error
+}
+
+// Collect reads all remaining entities from the iterator into a slice.
+// It is intended for small result sets, use the iterator directly to stream large ones.
+// returns the entities read so far and the error if Next fails.
+func Collect(it EntityIterator) ([]*egdm.Entity, error) {
+	return CollectN(it, 0)
+}
+
+// ForEach calls fn for each remaining entity of the iterator without holding them in memory.
+// Iteration stops at the first error returned by Next or fn, which is returned.
+func ForEach(it EntityIterator, fn func(*egdm.Entity) error) error {
+	for {
+		entity, err := it.Next()
+		if err != nil {
+			return err
+		}
+		if entity == nil {
+			return nil
+		}
+
+		err = fn(entity)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// CollectN reads at most limit entities from the iterator into a slice, a limit of 0 or less reads all of them.
+// The iterator is left positioned after the last entity read so that iteration can continue.
+// returns the entities read so far and the error if Next fails.
+func CollectN(it EntityIterator, limit int) ([]*egdm.Entity, error) {
+	entities := make([]*egdm.Entity, 0)
+	for limit <= 0 || len(entities) < limit {
+		entity, err := it.Next()
+		if err != nil {
+			return entities, err
+		}
+		if entity == nil {
+			break
+		}
+		entities = append(entities, entity)
+	}
+	return entities, nil
 }
 
 type AuthType int
@@ -37,6 +95,9 @@ const (
 	AuthTypePublicKey
 	// AuthTypeUser Used the OAuth User flow - Not yet supported
 	AuthTypeUser
+	// AuthTypeBasicHeader used for sending an HTTP Basic Authorization header on every request
+	// without exchanging the credentials for a token
+	AuthTypeBasicHeader
 )
 
 // authConfig contains the configuration for the different authentication types
@@ -47,6 +108,11 @@ type authConfig struct {
 	ClientSecret string
 	Audience     string
 	PrivateKey   *rsa.PrivateKey
+	ECPrivateKey *ecdsa.PrivateKey
+	// AssertionValidity is how long the signed public key token request is valid, 0 uses the default of 1 minute
+	AssertionValidity time.Duration
+	// AssertionClockSkew is how far in the past the signed token request becomes valid, 0 uses the default of 30 seconds
+	AssertionClockSkew time.Duration
 }
 
 // Client is the main entry point for the data hub client sdk
@@ -54,28 +120,221 @@ type Client struct {
 	AuthConfig *authConfig
 	AuthToken  *oauth2.Token
 	Server     string
+	tlsConfig  *tls.Config
+	transport  http.RoundTripper
+	// maxTransactionEntities is the maximum number of entities sent in a single transaction request, 0 is no limit
+	maxTransactionEntities int
+	// batchSize is the maximum number of entities sent in a single StoreEntities request, 0 is no limit
+	batchSize int
+	// uploadConcurrency is the number of batches StoreEntities uploads in parallel, 0 or 1 uploads sequentially
+	uploadConcurrency int
+	// streamRetries is the number of times an entity stream retries a batch after a network failure
+	streamRetries    int
+	streamRetryDelay time.Duration
+	logger           Logger
+	observer         RequestObserver
+	// headers are set on every request to the data hub
+	headers map[string]string
+	// requestIDFunc generates the id sent in the requestIDHeader of each request, nil sends no id
+	requestIDFunc   func() string
+	requestIDHeader string
+	// rateLimiter is shared by all requests of the client, nil is no limit
+	rateLimiter *rate.Limiter
+	// maxResponseSize is the maximum size in bytes of a response read into memory, 0 is no limit
+	maxResponseSize int64
+	// validateEntities makes StoreEntities validate collections before sending them
+	validateEntities bool
+	// dryRun captures requests instead of sending them, nil sends requests
+	dryRun func(method string, path string, body []byte)
+	// requestLogger is called after each request with up to requestLogBodySize bytes of the bodies
+	requestLogger      func(entry LogEntry)
+	requestLogBodySize int
+	// authTimeout is the timeout of each token request, 0 is no timeout
+	authTimeout time.Duration
+	// authFailure is the last permanent authentication failure, returned by checkToken without authenticating
+	// again as long as the auth config is still authFailureConfig
+	authFailure       error
+	authFailureConfig authConfig
+}
+
+// RequestObserver is notified about every request the client makes to the data hub, for example to record metrics.
+// ObserveRequest is called once per request, after the response body has been read or closed, and may be called
+// from several goroutines at once.
+type RequestObserver interface {
+	ObserveRequest(event RequestEvent)
+}
+
+// RequestObserverFunc is a function that is a RequestObserver, for example to update metrics counters and
+// latency histograms per path.
+type RequestObserverFunc func(event RequestEvent)
+
+// ObserveRequest calls f(event)
+func (f RequestObserverFunc) ObserveRequest(event RequestEvent) {
+	f(event)
+}
+
+// RequestEvent describes a completed request.
+// StatusCode is 0 and Err is set if no response was received. Err is also set if reading the response body failed.
+// BytesSent and BytesReceived are the sizes of the request and response bodies.
+// Duration is the time from sending the request until the response body was read or closed.
+// RequestID is the id sent with the request if the client is configured with WithRequestIDFunc.
+type RequestEvent struct {
+	Method        string
+	Path          string
+	StatusCode    int
+	BytesSent     int64
+	BytesReceived int64
+	Duration      time.Duration
+	RequestID     string
+	Err           error
+}
+
+// Logger receives diagnostic messages from the client, such as each request made and authentication refreshes.
+// It is satisfied by most logging libraries, for example a *zap.SugaredLogger.
+type Logger interface {
+	Debugf(format string, args ...any)
+	Errorf(format string, args ...any)
+}
+
+// noopLogger discards all messages, it is used when no logger is configured
+type noopLogger struct{}
+
+func (noopLogger) Debugf(string, ...any) {}
+func (noopLogger) Errorf(string, ...any) {}
+
+// log returns the configured logger, or a logger that discards messages
+func (c *Client) log() Logger {
+	if c.logger == nil {
+		return noopLogger{}
+	}
+	return c.logger
 }
 
+// DefaultBatchSize is the maximum number of entities StoreEntities sends in a single request unless
+// configured with WithBatchSize.
+const DefaultBatchSize = 10000
+
+// DefaultAuthTimeout is the timeout of each token request unless configured with WithAuthTimeout.
+const DefaultAuthTimeout = 30 * time.Second
+
 // NewClient creates a new client instance.
-// Specify the data hub server url as the parameter.
+// Specify the data hub server url as the parameter, including the scheme, for example "https://datahub.example.com".
+// Trailing slashes are removed from the url.
 // Use the withXXX functions to configure options
-// returns a ParameterError if the server url is empty or invalid URL
+// returns a ParameterError if the server url is empty, an invalid URL, not an http or https URL with a host, or has
+// a query or fragment
 func NewClient(server string) (*Client, error) {
 	if server == "" {
 		return nil, &ParameterError{Err: nil, Msg: "server url is required"}
 	}
-	_, err := url.Parse(server)
+	serverURL, err := url.Parse(server)
 	if err != nil {
 		return nil, &ParameterError{Err: err, Msg: "server url is not valid"}
 	}
+	if (serverURL.Scheme != "http" && serverURL.Scheme != "https") || serverURL.Host == "" {
+		return nil, &ParameterError{Msg: "server url '" + server + "' must be an http or https url with a host, for example https://" + server}
+	}
+	if serverURL.RawQuery != "" || serverURL.Fragment != "" {
+		return nil, &ParameterError{Msg: "server url '" + server + "' cannot have a query or fragment"}
+	}
 	client := &Client{}
-	client.Server = server
+	client.Server = strings.TrimRight(server, "/")
 	client.AuthConfig = &authConfig{
 		AuthType: AuthTypeNone,
 	}
+	client.batchSize = DefaultBatchSize
+	client.authTimeout = DefaultAuthTimeout
 	return client, nil
 }
 
+// Clone returns a new client for the same server with a copy of the authentication configuration and all
+// options, such as TLS settings, headers, logger and batch sizes. Apply a different WithXXXAuth to the clone to
+// use other credentials, for example to check the access of a registered client. The clone does not copy the
+// authentication token, it authenticates on its first request. The connection pool and rate limiter are shared.
+func (c *Client) Clone() *Client {
+	clone := *c
+	clone.AuthToken = nil
+
+	if c.AuthConfig != nil {
+		authConfig := *c.AuthConfig
+		clone.AuthConfig = &authConfig
+	}
+
+	if c.headers != nil {
+		clone.headers = make(map[string]string, len(c.headers))
+		for key, value := range c.headers {
+			clone.headers[key] = value
+		}
+	}
+
+	return &clone
+}
+
+// Do sends a request to an endpoint of the data hub the client has no function for. This is an advanced escape
+// hatch, prefer the dedicated functions as they validate parameters and know the shape of the responses. The
+// request is authenticated and uses the client options, such as headers, rate limit and logging, like all requests.
+// method is one of GET, POST, PUT and DELETE.
+// path is the path of the endpoint relative to the server url, for example "/jobs/myjob/history".
+// body is sent as is if it is a []byte, marshalled to JSON otherwise, or no body is sent if it is nil.
+// queryParams is an optional map of query parameters.
+// out receives the response: the raw body if it is a *[]byte, the body unmarshalled from JSON otherwise. The
+// response is discarded if out is nil, and out is left unchanged if the response is empty.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the method is not supported, the path does not start with / or the body cannot be
+// marshalled.
+// returns a RequestError if the request fails or the server responds with a status other than 200 or 201.
+// returns a ClientProcessingError if the response cannot be unmarshalled into out.
+func (c *Client) Do(method string, path string, body any, queryParams map[string]string, out any) error {
+	verb := httpVerb(strings.ToUpper(method))
+	if verb != httpGet && verb != httpPost && verb != httpPut && verb != httpDelete {
+		return &ParameterError{Msg: "unsupported method " + method}
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		return &ParameterError{Msg: "path must start with /"}
+	}
+
+	var content []byte
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		content = b
+	default:
+		var err error
+		content, err = json.Marshal(body)
+		if err != nil {
+			return &ParameterError{Msg: "unable to marshal request body", Err: err}
+		}
+	}
+
+	err := c.checkToken()
+	if err != nil {
+		return &AuthenticationError{Msg: "unable to authenticate", Err: err}
+	}
+
+	client := c.makeHttpClient()
+	data, err := client.makeRequest(verb, path, content, nil, queryParams)
+	if err != nil {
+		return &RequestError{Msg: fmt.Sprintf("unable to %s %s", verb, path), Err: err}
+	}
+
+	switch o := out.(type) {
+	case nil:
+	case *[]byte:
+		*o = data
+	default:
+		if len(bytes.TrimSpace(data)) == 0 {
+			return nil
+		}
+		err = json.Unmarshal(data, out)
+		if err != nil {
+			return &ClientProcessingError{Msg: fmt.Sprintf("unable to unmarshal response of %s %s", verb, path), Err: err}
+		}
+	}
+
+	return nil
+}
+
 // makeHttpClient creates a new http client with the specified access token
 // and server configured
 func (c *Client) makeHttpClient() *httpClient {
@@ -85,6 +344,18 @@ func (c *Client) makeHttpClient() *httpClient {
 	}
 
 	client := newHttpClient(c.Server, accessToken)
+	client.withTransport(c.httpTransport())
+	client.withLogger(c.log())
+	client.withObserver(c.observer)
+	client.withHeaders(c.headers)
+	client.withRequestIDFunc(c.requestIDFunc, c.requestIDHeader)
+	client.withRateLimiter(c.rateLimiter)
+	client.withMaxResponseSize(c.maxResponseSize)
+	client.withDryRun(c.dryRun)
+	client.withRequestLogger(c.requestLogger, c.requestLogBodySize)
+	if c.AuthConfig.AuthType == AuthTypeBasicHeader {
+		client.withBasicAuth(c.AuthConfig.ClientID, c.AuthConfig.ClientSecret)
+	}
 	return client
 }
 
@@ -95,6 +366,350 @@ func (c *Client) WithExistingToken(token *oauth2.Token) *Client {
 	return c
 }
 
+// SaveToken writes the current authentication token, including its expiry, to a file readable only by the owner.
+// Use LoadToken in a later process run to avoid authenticating again while the token is valid.
+// returns a ParameterError if the path is empty.
+// returns a ClientProcessingError if the client has no token or the file cannot be written.
+func (c *Client) SaveToken(path string) error {
+	if path == "" {
+		return &ParameterError{Msg: "token file path is required"}
+	}
+
+	if c.AuthToken == nil {
+		return &ClientProcessingError{Msg: "client has no token to save"}
+	}
+
+	data, err := json.Marshal(c.AuthToken)
+	if err != nil {
+		return &ClientProcessingError{Msg: "unable to marshal token", Err: err}
+	}
+
+	// write to a temporary file first so that a reader never sees a partial token, CreateTemp uses mode 0600
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return &ClientProcessingError{Msg: "unable to create token file", Err: err}
+	}
+	defer func() {
+		_ = os.Remove(file.Name())
+	}()
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return &ClientProcessingError{Msg: "unable to write token file", Err: err}
+	}
+
+	err = os.Rename(file.Name(), path)
+	if err != nil {
+		return &ClientProcessingError{Msg: "unable to write token file", Err: err}
+	}
+	return nil
+}
+
+// LoadToken reads a token written by SaveToken and sets it as the authentication token of the client.
+// If the file does not exist the client is left unchanged and no error is returned, so the client
+// authenticates as normal. An expired token is loaded and replaced on the next request.
+// returns a ParameterError if the path is empty.
+// returns a ClientProcessingError if the file cannot be read or does not contain a token.
+func (c *Client) LoadToken(path string) error {
+	if path == "" {
+		return &ParameterError{Msg: "token file path is required"}
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return &ClientProcessingError{Msg: "unable to read token file", Err: err}
+	}
+
+	token := &oauth2.Token{}
+	err = json.Unmarshal(data, token)
+	if err != nil {
+		return &ClientProcessingError{Msg: "unable to unmarshal token", Err: err}
+	}
+
+	if token.AccessToken == "" {
+		return &ClientProcessingError{Msg: "token file does not contain an access token"}
+	}
+
+	c.AuthToken = token
+	return nil
+}
+
+// WithMaxTransactionEntities sets the maximum number of entities sent to the server in a single transaction.
+// Larger transactions are split into several server transactions by ProcessTransaction, which means
+// atomicity is only guaranteed within each chunk. The default of 0 sends every transaction as a single request.
+func (c *Client) WithMaxTransactionEntities(maxEntities int) *Client {
+	c.maxTransactionEntities = maxEntities
+	return c
+}
+
+// WithBatchSize sets the maximum number of entities sent in a single request by StoreEntities.
+// Larger collections are uploaded sequentially in batches, batches already stored are not rolled back
+// if a later batch fails. A value of 0 sends every collection as a single request.
+func (c *Client) WithBatchSize(batchSize int) *Client {
+	c.batchSize = batchSize
+	return c
+}
+
+// WithUploadConcurrency sets the number of batches StoreEntities uploads in parallel when a collection is
+// split into batches, see WithBatchSize. The first failing batch stops any batches not yet started and aborts
+// the uploads in flight.
+// Parallel upload may store entities in a different order than the collection, which is fine as long as
+// the collection does not contain several versions of the same entity.
+func (c *Client) WithUploadConcurrency(concurrency int) *Client {
+	c.uploadConcurrency = concurrency
+	return c
+}
+
+// WithStreamRetries configures entity streams, such as GetChangesStream, to retry fetching a batch after a
+// network failure. The batch is requested again from the last successful continuation token after waiting delay.
+// Error responses from the server and responses that cannot be parsed are not retried. The default is no retries.
+func (c *Client) WithStreamRetries(retries int, delay time.Duration) *Client {
+	c.streamRetries = retries
+	c.streamRetryDelay = delay
+	return c
+}
+
+// WithLogger sets the logger used to report the requests the client makes, with their status and duration,
+// at debug level, as well as authentication refreshes and retries. Failures are reported at error level.
+// No messages are logged by default.
+func (c *Client) WithLogger(logger Logger) *Client {
+	c.logger = logger
+	return c
+}
+
+// LogEntry describes a completed request for the request logger, see WithRequestLogger.
+// URL is the full request URL. StatusCode is 0 and Err is set if no response was received.
+// RequestHeader holds the headers sent, with credentials such as the Authorization header replaced by REDACTED.
+// RequestBody and ResponseBody hold the start of the bodies if body logging is enabled, with ... appended if they
+// were truncated. Values of JSON fields named like an access token, secret or password are replaced by REDACTED.
+type LogEntry struct {
+	Method        string
+	URL           string
+	StatusCode    int
+	Duration      time.Duration
+	RequestID     string
+	RequestHeader http.Header
+	RequestBody   string
+	ResponseBody  string
+	Err           error
+}
+
+// WithRequestLogger sets a function that is called with the details of every request to the data hub, to diagnose
+// failing calls without a network capture. It is called once per request, after the response body has been read
+// or closed, and may be called from several goroutines at once.
+// maxBodySize is the number of bytes of the request and response bodies included in the entry, 0 logs no bodies.
+// Credentials are redacted on a best effort basis, entity bodies are logged as they are.
+// Requests to the authorizer for a token are not logged.
+func (c *Client) WithRequestLogger(requestLogger func(entry LogEntry), maxBodySize int) *Client {
+	c.requestLogger = requestLogger
+	c.requestLogBodySize = max(maxBodySize, 0)
+	return c
+}
+
+// WithObserver sets an observer that is notified with the method, path, status, bytes transferred and latency
+// of every request. Streaming requests are reported once the stream has been read to the end or closed.
+// Each attempt of a retried request is reported as a separate request. Requests to the authorizer for a token
+// are not reported.
+func (c *Client) WithObserver(observer RequestObserver) *Client {
+	c.observer = observer
+	return c
+}
+
+// WithHeader sets a header that is sent with every request to the data hub, for example an API key or tenant id
+// required by a gateway. Headers the client sets for a specific request, such as the query content type, take precedence.
+func (c *Client) WithHeader(key string, value string) *Client {
+	if c.headers == nil {
+		c.headers = make(map[string]string)
+	}
+	c.headers[key] = value
+	return c
+}
+
+// WithHeaders sets several headers that are sent with every request to the data hub, see WithHeader.
+// The headers are added to any headers already set. The Authorization and Content-Type headers the client sends
+// are only replaced if they are included.
+func (c *Client) WithHeaders(headers map[string]string) *Client {
+	for key, value := range headers {
+		c.WithHeader(key, value)
+	}
+	return c
+}
+
+// WithBaseHeaders merges the headers into the headers sent with every request to the data hub, for example a
+// tenant id or API key required by a gateway in addition to the bearer token. It is the same as WithHeaders,
+// the Authorization and Content-Type headers the client sends are only replaced if they are included.
+func (c *Client) WithBaseHeaders(headers map[string]string) *Client {
+	return c.WithHeaders(headers)
+}
+
+// DefaultRequestIDHeader is the header the request id is sent in, see WithRequestIDFunc.
+const DefaultRequestIDHeader = "X-Request-Id"
+
+// WithRequestIDFunc sends an id generated by requestIDFunc with every request to the data hub, so that a request
+// can be correlated with the server logs. The id is included in the log messages, the RequestEvent passed to the
+// observer and is returned by RequestError.RequestID. A nil requestIDFunc generates a random UUID per request.
+// The id is sent in the X-Request-Id header, use WithRequestIDHeader to change it.
+func (c *Client) WithRequestIDFunc(requestIDFunc func() string) *Client {
+	if requestIDFunc == nil {
+		requestIDFunc = func() string { return uuid.New().String() }
+	}
+	c.requestIDFunc = requestIDFunc
+	if c.requestIDHeader == "" {
+		c.requestIDHeader = DefaultRequestIDHeader
+	}
+	return c
+}
+
+// WithRequestIDHeader sets the name of the header the request id is sent in, see WithRequestIDFunc.
+// An empty header restores the default X-Request-Id header.
+func (c *Client) WithRequestIDHeader(header string) *Client {
+	if header == "" {
+		header = DefaultRequestIDHeader
+	}
+	c.requestIDHeader = header
+	return c
+}
+
+// WithRateLimit limits the rate of requests the client sends to the data hub to rps requests per second, allowing
+// bursts of up to burst requests, to avoid overwhelming a shared instance. A request waits for the limit before it
+// is sent. Every attempt counts, so the retries of WithStreamRetries also wait for the limit, in addition to the
+// retry delay. Requests to the authorizer for a token are not limited. An rps of 0 or less removes the limit.
+func (c *Client) WithRateLimit(rps float64, burst int) *Client {
+	if rps <= 0 {
+		c.rateLimiter = nil
+		return c
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	c.rateLimiter = rate.NewLimiter(rate.Limit(rps), burst)
+	return c
+}
+
+// WithMaxResponseSize sets the maximum size in bytes of a response the client reads into memory, to protect
+// against a server sending an unbounded response. Requests with a larger response fail with a RequestError
+// wrapping a ClientProcessingError. Responses that are parsed as they are read, such as changes, entities and
+// query results, are not limited. A value of 0 or less removes the limit.
+func (c *Client) WithMaxResponseSize(bytes int64) *Client {
+	if bytes < 0 {
+		bytes = 0
+	}
+	c.maxResponseSize = bytes
+	return c
+}
+
+// WithValidation makes StoreEntities and StoreEntitiesWithResult check collections with ValidateEntities before
+// sending them, so that malformed entities fail locally instead of being rejected by the server.
+func (c *Client) WithValidation() *Client {
+	c.validateEntities = true
+	return c
+}
+
+// WithDryRun makes the client pass requests to capture instead of sending them, to preview or unit test what
+// the client would do without a data hub. capture is called with the method, the path relative to the server
+// and the request body, nil if there is none. Each request then succeeds with an empty response, so functions
+// that parse a response, such as GetJobs, return a ClientProcessingError. The client does not authenticate in
+// dry run mode. A nil capture turns dry run mode off.
+func (c *Client) WithDryRun(capture func(method string, path string, body []byte)) *Client {
+	c.dryRun = capture
+	return c
+}
+
+// WithClientCertificate configures the client to present a TLS client certificate on every connection.
+// cert is the client certificate and private key.
+// caPool is an optional pool of certificate authorities used to verify the server, when nil the system pool is used.
+// This applies at the transport layer and can be combined with any of the authentication types.
+func (c *Client) WithClientCertificate(cert tls.Certificate, caPool *x509.CertPool) *Client {
+	tlsConfig := c.cloneTLSConfig()
+	tlsConfig.Certificates = []tls.Certificate{cert}
+	if caPool != nil {
+		tlsConfig.RootCAs = caPool
+	}
+	c.setTLSConfig(tlsConfig)
+	return c
+}
+
+// WithTLSConfig sets the TLS configuration used for connections to the server and authorizer.
+// Use this to trust a custom certificate authority, for example by setting RootCAs for a server using
+// a self-signed certificate. The configuration replaces any previous TLS options set on the client.
+func (c *Client) WithTLSConfig(tlsConfig *tls.Config) *Client {
+	if tlsConfig == nil {
+		c.setTLSConfig(nil)
+		return c
+	}
+	c.setTLSConfig(tlsConfig.Clone())
+	return c
+}
+
+// WithInsecureSkipVerify disables verification of the server certificate chain and host name.
+// WARNING: this makes the connection vulnerable to man-in-the-middle attacks and must only be used
+// against local or test instances. Prefer WithTLSConfig with RootCAs set to trust a self-signed certificate.
+// Verification is enabled by default.
+func (c *Client) WithInsecureSkipVerify() *Client {
+	tlsConfig := c.cloneTLSConfig()
+	tlsConfig.InsecureSkipVerify = true
+	c.setTLSConfig(tlsConfig)
+	return c
+}
+
+// cloneTLSConfig returns a copy of the current TLS configuration, or a new one if none is set.
+func (c *Client) cloneTLSConfig() *tls.Config {
+	if c.tlsConfig == nil {
+		return &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return c.tlsConfig.Clone()
+}
+
+// setTLSConfig sets the TLS configuration and discards any transport built from a previous configuration.
+func (c *Client) setTLSConfig(tlsConfig *tls.Config) {
+	c.tlsConfig = tlsConfig
+	c.transport = nil
+}
+
+// httpTransport returns the transport to use for requests to the server and authorizer.
+// returns nil to use the default transport when no TLS configuration has been set.
+func (c *Client) httpTransport() http.RoundTripper {
+	if c.transport != nil {
+		return c.transport
+	}
+
+	if c.tlsConfig == nil {
+		return nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = c.tlsConfig
+	c.transport = transport
+	return c.transport
+}
+
+// authContext returns a context for the oauth2 token requests that uses the configured transport and auth timeout.
+func (c *Client) authContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, c.authHttpClient())
+}
+
+// authHttpClient returns the http client for token requests, a nil transport uses the default transport.
+func (c *Client) authHttpClient() *http.Client {
+	return &http.Client{Transport: c.httpTransport(), Timeout: c.authTimeout}
+}
+
+// WithAuthTimeout sets the timeout of each request to the authorizer for a token, so that an unresponsive
+// authorizer does not block the first request of the client. The default is DefaultAuthTimeout.
+// A value of 0 or less removes the timeout.
+func (c *Client) WithAuthTimeout(timeout time.Duration) *Client {
+	if timeout < 0 {
+		timeout = 0
+	}
+	c.authTimeout = timeout
+	return c
+}
+
 // WithAdminAuth sets the authentication type to basic authentication.
 // username and password are the credentials of the admin user
 func (c *Client) WithAdminAuth(username string, password string) *Client {
@@ -107,6 +722,18 @@ func (c *Client) WithAdminAuth(username string, password string) *Client {
 	return c
 }
 
+// WithBasicAuthHeader sets the authentication type to HTTP Basic authentication.
+// username and password are sent in an Authorization header on every request instead of
+// being exchanged for a token. Use this for data hub instances fronted by a proxy doing Basic auth.
+func (c *Client) WithBasicAuthHeader(username string, password string) *Client {
+	c.AuthConfig = &authConfig{
+		AuthType:     AuthTypeBasicHeader,
+		ClientID:     username,
+		ClientSecret: password,
+	}
+	return c
+}
+
 // WithClientKeyAndSecretAuth sets the authentication type to client key and secret OAuth2 authentication flow
 // authorizer is the url of the authorizer service
 // audience is the audience identifier
@@ -123,19 +750,53 @@ func (c *Client) WithClientKeyAndSecretAuth(authorizer string, audience string,
 	return c
 }
 
+// defaultPublicKeyAudience is the audience used in the signed token request for public key authentication
+const defaultPublicKeyAudience = "datahub-client-sdk"
+
 // WithPublicKeyAuth sets the authentication type to public key authentication.
-// Sets the client id and private key
+// Sets the client id and private key. The audience defaults to "datahub-client-sdk", use WithAudience to override it
 func (c *Client) WithPublicKeyAuth(clientID string, privateKey *rsa.PrivateKey) *Client {
 	c.AuthConfig = &authConfig{
 		AuthType:   AuthTypePublicKey,
 		ClientID:   clientID,
-		Audience:   "datahub-client-sdk",
+		Audience:   defaultPublicKeyAudience,
 		PrivateKey: privateKey,
 		Authorizer: c.Server,
 	}
 	return c
 }
 
+// WithECPublicKeyAuth sets the authentication type to public key authentication with an ECDSA key.
+// The token request is signed with ES256, ES384 or ES512 depending on the curve of the key.
+// Sets the client id and private key. The audience defaults to "datahub-client-sdk", use WithAudience to override it
+func (c *Client) WithECPublicKeyAuth(clientID string, privateKey *ecdsa.PrivateKey) *Client {
+	c.AuthConfig = &authConfig{
+		AuthType:     AuthTypePublicKey,
+		ClientID:     clientID,
+		Audience:     defaultPublicKeyAudience,
+		ECPrivateKey: privateKey,
+		Authorizer:   c.Server,
+	}
+	return c
+}
+
+// WithAudience overrides the audience of the configured authentication type.
+// Use this after WithPublicKeyAuth for servers that expect an audience other than the default.
+// Calling one of the WithXXXAuth functions afterwards resets the audience.
+func (c *Client) WithAudience(audience string) *Client {
+	c.AuthConfig.Audience = audience
+	return c
+}
+
+// WithAssertionValidity sets how long the signed token request used for public key authentication is valid, and how
+// far in the past it becomes valid to tolerate an authorizer whose clock is behind. The request always carries the
+// time it was issued. Use this after WithPublicKeyAuth or WithECPublicKeyAuth.
+func (c *Client) WithAssertionValidity(validity time.Duration, clockSkew time.Duration) *Client {
+	c.AuthConfig.AssertionValidity = validity
+	c.AuthConfig.AssertionClockSkew = clockSkew
+	return c
+}
+
 // WithUserAuth sets the authentication type to user authentication
 // and sets the authorizer url and audience
 // NOT SUPPORTED YET
@@ -150,9 +811,27 @@ func (c *Client) WithUserAuth(authorizer string, audience string) *Client {
 
 // checkToken checks if the current token is valid and if not, attempts to authenticate
 func (c *Client) checkToken() error {
+	if c.AuthConfig.AuthType == AuthTypeBasicHeader {
+		// credentials are sent with each request
+		return nil
+	}
+
+	if c.dryRun != nil {
+		// requests are not sent, so no token is needed
+		return nil
+	}
+
 	if c.AuthToken == nil || !c.AuthToken.Valid() {
+		if c.authFailure != nil && *c.AuthConfig == c.authFailureConfig {
+			// the credentials were rejected and have not changed since, authenticating again would fail too
+			return c.authFailure
+		}
+		if c.AuthConfig.AuthType != AuthTypeNone {
+			c.log().Debugf("authenticating, no valid token")
+		}
 		err := c.Authenticate()
 		if err != nil {
+			c.log().Errorf("authentication failed: %v", err)
 			return err
 		}
 		return nil
@@ -161,13 +840,78 @@ func (c *Client) checkToken() error {
 	return nil
 }
 
+// ResetAuthentication clears the token and a cached authentication failure, so that the next request
+// authenticates again. Requests are not retried against the authorizer once it has rejected the credentials, use
+// this after the credentials were fixed on the authorizer. Changing the credentials of the client, for example with
+// one of the WithXXXAuth functions, also clears the failure.
+func (c *Client) ResetAuthentication() {
+	c.AuthToken = nil
+	c.authFailure = nil
+}
+
 // Authenticate attempts to authenticate the client with the configured authentication type
+// If the authorizer rejects the credentials the failure is cached and returned by later requests without
+// authenticating again until the credentials change or ResetAuthentication is called. Authenticate itself always
+// tries again. Failures that may be transient, such as network errors or server errors, are not cached.
 // returns an AuthenticationError if authentication fails
 func (c *Client) Authenticate() error {
 	if c.isTokenValid() {
 		return nil
 	}
 
+	err := c.authenticate()
+	if err != nil && isPermanentAuthFailure(err) {
+		c.authFailure = err
+		c.authFailureConfig = *c.AuthConfig
+	} else {
+		c.authFailure = nil
+	}
+	return err
+}
+
+// permanentTokenErrorCodes are the OAuth2 token error codes that mean the credentials are wrong or not permitted,
+// so that requesting a token again with the same credentials fails too.
+var permanentTokenErrorCodes = []string{"invalid_client", "invalid_grant", "unauthorized_client", "access_denied"}
+
+// isPermanentAuthFailure returns true if the authorizer rejected the credentials, as opposed to a failure that may
+// be transient such as a network error, a server error or too many requests.
+func isPermanentAuthFailure(err error) bool {
+	statusCode := 0
+	errorCode := ""
+	var retrieveErr *oauth2.RetrieveError
+	var tokenErr *tokenStatusError
+	if errors.As(err, &retrieveErr) {
+		errorCode = retrieveErr.ErrorCode
+		if retrieveErr.Response != nil {
+			statusCode = retrieveErr.Response.StatusCode
+		}
+	} else if errors.As(err, &tokenErr) {
+		statusCode = tokenErr.statusCode
+		errorCode = tokenErr.errorCode
+	} else {
+		return false
+	}
+
+	if slices.Contains(permanentTokenErrorCodes, errorCode) {
+		return true
+	}
+	return statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden
+}
+
+// tokenStatusError is returned when the authorizer responds to a token request with a status other than 200.
+// errorCode is the OAuth2 error code of the response, if any.
+type tokenStatusError struct {
+	statusCode  int
+	status      string
+	errorCode   string
+	description string
+}
+
+func (e *tokenStatusError) Error() string {
+	return fmt.Sprintf("token request failed with http status %s%s", e.status, e.description)
+}
+
+func (c *Client) authenticate() error {
 	if c.AuthConfig.AuthType == AuthTypeClientKeyAndSecret {
 		token, err := c.authenticateWithClientCredentials()
 		if err != nil {
@@ -198,13 +942,18 @@ func (c *Client) Authenticate() error {
 }
 
 func (c *Client) authenticateWithBasicAuth() (*oauth2.Token, error) {
+	tokenURL, err := joinURL(c.AuthConfig.Authorizer, "/security/token")
+	if err != nil {
+		return nil, fmt.Errorf("invalid authorizer url: %w", err)
+	}
+
 	clientCredentialsConfig := &clientcredentials.Config{
 		ClientID:     c.AuthConfig.ClientID,
 		ClientSecret: c.AuthConfig.ClientSecret,
-		TokenURL:     c.AuthConfig.Authorizer + "/security/token",
+		TokenURL:     tokenURL.String(),
 	}
 
-	return clientCredentialsConfig.Token(context.Background())
+	return clientCredentialsConfig.Token(c.authContext(context.Background()))
 }
 
 func (c *Client) authenticateWithUserFlow() (*oauth2.Token, error) {
@@ -220,23 +969,60 @@ func (c *Client) GenerateKeypair() (*rsa.PrivateKey, *rsa.PublicKey, error) {
 	return private, public, nil
 }
 
+// GenerateECKeypair generates a new ECDSA keypair on the P-256 curve, for use with WithECPublicKeyAuth
+func (c *Client) GenerateECKeypair() (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
+	return generateECKeyPair()
+}
+
 // LoadKeypair loads an RSA keypair from the specified location. Names of the key files are node_key and node_key.pub
 func (c *Client) LoadKeypair(location string) (*rsa.PrivateKey, *rsa.PublicKey, error) {
 	if location == "" {
 		return nil, nil, &ParameterError{Err: nil, Msg: fmt.Sprintf("location %s is not valid", location)}
 	}
+	return c.LoadKeypairFiles(location+string(os.PathSeparator)+"node_key", location+string(os.PathSeparator)+"node_key.pub")
+}
+
+// LoadEncryptedKeypair loads an RSA keypair with a passphrase protected private key from the specified location.
+// Names of the key files are node_key and node_key.pub
+// returns a ParameterError if the location is empty, a key file does not exist or the passphrase is not correct.
+func (c *Client) LoadEncryptedKeypair(location string, passphrase []byte) (*rsa.PrivateKey, *rsa.PublicKey, error) {
+	if location == "" {
+		return nil, nil, &ParameterError{Err: nil, Msg: fmt.Sprintf("location %s is not valid", location)}
+	}
+	return c.loadKeypairFiles(location+string(os.PathSeparator)+"node_key", location+string(os.PathSeparator)+"node_key.pub",
+		func(pemValue []byte) (*rsa.PrivateKey, error) {
+			privateKey, err := parseRsaPrivateKeyFromPemWithPassword(pemValue, passphrase)
+			if err != nil {
+				return nil, &ParameterError{Err: err, Msg: "unable to load encrypted private key"}
+			}
+			return privateKey, nil
+		})
+}
+
+// LoadKeypairFiles loads an RSA keypair from the specified private and public key files.
+// Use this instead of LoadKeypair when several keypairs are kept in the same directory.
+// returns a ParameterError if either path is empty or the file does not exist.
+func (c *Client) LoadKeypairFiles(privateKeyPath string, publicKeyPath string) (*rsa.PrivateKey, *rsa.PublicKey, error) {
+	return c.loadKeypairFiles(privateKeyPath, publicKeyPath, parseRsaPrivateKeyFromPem)
+}
+
+// loadKeypairFiles loads the keypair, parsing the private key with parsePrivateKey
+func (c *Client) loadKeypairFiles(privateKeyPath string, publicKeyPath string, parsePrivateKey func([]byte) (*rsa.PrivateKey, error)) (*rsa.PrivateKey, *rsa.PublicKey, error) {
+	if privateKeyPath == "" || publicKeyPath == "" {
+		return nil, nil, &ParameterError{Err: nil, Msg: "private and public key paths are required"}
+	}
+
 	var privateKey *rsa.PrivateKey
-	privateKeyFilename := location + string(os.PathSeparator) + "node_key"
-	_, err := os.Stat(privateKeyFilename)
+	_, err := os.Stat(privateKeyPath)
 	if err != nil {
-		return nil, nil, &ParameterError{Err: nil, Msg: fmt.Sprintf("node_key at location %s is not valid", location)}
+		return nil, nil, &ParameterError{Err: nil, Msg: fmt.Sprintf("private key file %s is not valid", privateKeyPath)}
 	} else {
 		// load it
-		privateKeyBytes, err := readFileContents(privateKeyFilename)
+		privateKeyBytes, err := readFileContents(privateKeyPath)
 		if err != nil {
 			return nil, nil, err
 		}
-		privateKey, err = parseRsaPrivateKeyFromPem(privateKeyBytes)
+		privateKey, err = parsePrivateKey(privateKeyBytes)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -244,13 +1030,12 @@ func (c *Client) LoadKeypair(location string) (*rsa.PrivateKey, *rsa.PublicKey,
 	}
 
 	var publicKey *rsa.PublicKey
-	publicKeyFilename := location + string(os.PathSeparator) + "node_key.pub"
-	_, err = os.Stat(publicKeyFilename)
+	_, err = os.Stat(publicKeyPath)
 	if err != nil {
-		return nil, nil, &ParameterError{Err: nil, Msg: fmt.Sprintf("node_key.pub at location %s is not valid", location)}
+		return nil, nil, &ParameterError{Err: nil, Msg: fmt.Sprintf("public key file %s is not valid", publicKeyPath)}
 	} else {
 		// load it
-		publicKeyBytes, err := readFileContents(publicKeyFilename)
+		publicKeyBytes, err := readFileContents(publicKeyPath)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -263,6 +1048,34 @@ func (c *Client) LoadKeypair(location string) (*rsa.PrivateKey, *rsa.PublicKey,
 	return privateKey, publicKey, nil
 }
 
+// LoadECKeypairFiles loads an ECDSA keypair from the specified private and public key files.
+// returns a ParameterError if either path is empty or the file does not exist.
+func (c *Client) LoadECKeypairFiles(privateKeyPath string, publicKeyPath string) (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
+	if privateKeyPath == "" || publicKeyPath == "" {
+		return nil, nil, &ParameterError{Err: nil, Msg: "private and public key paths are required"}
+	}
+
+	privateKeyBytes, err := readFileContents(privateKeyPath)
+	if err != nil {
+		return nil, nil, &ParameterError{Err: err, Msg: fmt.Sprintf("private key file %s is not valid", privateKeyPath)}
+	}
+	privateKey, err := parseECPrivateKeyFromPem(privateKeyBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	publicKeyBytes, err := readFileContents(publicKeyPath)
+	if err != nil {
+		return nil, nil, &ParameterError{Err: err, Msg: fmt.Sprintf("public key file %s is not valid", publicKeyPath)}
+	}
+	publicKey, err := parseECPublicKeyFromPem(publicKeyBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return privateKey, publicKey, nil
+}
+
 // Utility function to read file contents and return bytes
 func readFileContents(filename string) ([]byte, error) {
 	file, err := os.Open(filename)
@@ -281,6 +1094,16 @@ func readFileContents(filename string) ([]byte, error) {
 
 // SaveKeypair saves the specified RSA keypair to the specified location. Names of the key files are node_key and node_key.pub
 func (c *Client) SaveKeypair(location string, privateKey *rsa.PrivateKey, publicKey *rsa.PublicKey) error {
+	return c.SaveKeypairFiles(location+string(os.PathSeparator)+"node_key", location+string(os.PathSeparator)+"node_key.pub", privateKey, publicKey)
+}
+
+// SaveKeypairFiles saves the specified RSA keypair to the specified private and public key files.
+// returns a ParameterError if either path is empty.
+func (c *Client) SaveKeypairFiles(privateKeyPath string, publicKeyPath string, privateKey *rsa.PrivateKey, publicKey *rsa.PublicKey) error {
+	if privateKeyPath == "" || publicKeyPath == "" {
+		return &ParameterError{Err: nil, Msg: "private and public key paths are required"}
+	}
+
 	privateKeyPem, err := exportRsaPrivateKeyAsPem(privateKey)
 	if err != nil {
 		return err
@@ -292,11 +1115,11 @@ func (c *Client) SaveKeypair(location string, privateKey *rsa.PrivateKey, public
 	}
 
 	// write keys to files
-	err = os.WriteFile(location+string(os.PathSeparator)+"node_key", privateKeyPem, 0600)
+	err = os.WriteFile(privateKeyPath, privateKeyPem, 0600)
 	if err != nil {
 		return err
 	}
-	err = os.WriteFile(location+string(os.PathSeparator)+"node_key.pub", publicKeyPem, 0600)
+	err = os.WriteFile(publicKeyPath, publicKeyPem, 0600)
 	if err != nil {
 		return err
 	}
@@ -304,6 +1127,29 @@ func (c *Client) SaveKeypair(location string, privateKey *rsa.PrivateKey, public
 	return nil
 }
 
+// SaveECKeypairFiles saves the specified ECDSA keypair to the specified private and public key files.
+// returns a ParameterError if either path is empty.
+func (c *Client) SaveECKeypairFiles(privateKeyPath string, publicKeyPath string, privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey) error {
+	if privateKeyPath == "" || publicKeyPath == "" {
+		return &ParameterError{Err: nil, Msg: "private and public key paths are required"}
+	}
+
+	privateKeyPem, err := exportECPrivateKeyAsPem(privateKey)
+	if err != nil {
+		return err
+	}
+	publicKeyPem, err := exportPublicKeyAsPem(publicKey)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(privateKeyPath, privateKeyPem, 0600)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(publicKeyPath, publicKeyPem, 0600)
+}
+
 // authenticateWithCertificate used to authenticate using a signed JWT and the client assertion
 // type urn:ietf:params:oauth:grant-type:jwt-bearer.
 func (c *Client) authenticateWithCertificate() (*oauth2.Token, error) {
@@ -311,26 +1157,75 @@ func (c *Client) authenticateWithCertificate() (*oauth2.Token, error) {
 	data.Set("grant_type", "client_credentials")
 	data.Set("client_assertion_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
 
-	pem, err := createJWTForTokenRequest(c.AuthConfig.ClientID, c.AuthConfig.Audience, c.AuthConfig.PrivateKey)
+	var privateKey crypto.PrivateKey
+	if c.AuthConfig.PrivateKey != nil {
+		privateKey = c.AuthConfig.PrivateKey
+	} else if c.AuthConfig.ECPrivateKey != nil {
+		privateKey = c.AuthConfig.ECPrivateKey
+	} else {
+		return nil, errors.New("missing private key")
+	}
+
+	pem, err := createJWTForTokenRequest(c.AuthConfig.ClientID, c.AuthConfig.Audience, privateKey, c.AuthConfig.AssertionValidity, c.AuthConfig.AssertionClockSkew)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create signed token request: %w", err)
+	}
 	data.Set("client_assertion", pem)
 
-	reqUrl := c.AuthConfig.Authorizer + "/security/token"
-	res, err := http.PostForm(reqUrl, data)
+	reqUrl, err := joinURL(c.AuthConfig.Authorizer, "/security/token")
+	if err != nil {
+		return nil, fmt.Errorf("invalid authorizer url: %w", err)
+	}
+	res, err := c.authHttpClient().PostForm(reqUrl.String(), data)
 	if err != nil {
 		return nil, err
 	}
+	defer res.Body.Close()
 
 	decoder := json.NewDecoder(res.Body)
 	response := make(map[string]interface{})
-	err = decoder.Decode(&response)
-	if err != nil {
-		return nil, err
+	decodeErr := decoder.Decode(&response)
+
+	if res.StatusCode != http.StatusOK {
+		errorCode, _ := response["error"].(string)
+		return nil, &tokenStatusError{statusCode: res.StatusCode, status: res.Status, errorCode: errorCode,
+			description: tokenErrorDescription(response)}
 	}
-	accessToken := response["access_token"].(string)
 
-	return &oauth2.Token{
+	if decodeErr != nil {
+		return nil, fmt.Errorf("unable to decode token response: %w", decodeErr)
+	}
+
+	accessToken, ok := response["access_token"].(string)
+	if !ok || accessToken == "" {
+		return nil, fmt.Errorf("token response does not contain an access token%s", tokenErrorDescription(response))
+	}
+
+	token := &oauth2.Token{
 		AccessToken: accessToken,
-	}, nil
+	}
+
+	// set the expiry so that checkToken re-authenticates before the token expires
+	if expiresIn, ok := response["expires_in"].(float64); ok && expiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	} else if expiry, ok := accessTokenExpiry(accessToken); ok {
+		token.Expiry = expiry
+	}
+
+	return token, nil
+}
+
+// tokenErrorDescription formats the OAuth error and error_description fields of a token response for use in an error message.
+// returns the empty string if the response contains neither.
+func tokenErrorDescription(response map[string]interface{}) string {
+	description := ""
+	if errorCode, ok := response["error"].(string); ok && errorCode != "" {
+		description += ": " + errorCode
+	}
+	if errorDescription, ok := response["error_description"].(string); ok && errorDescription != "" {
+		description += ": " + errorDescription
+	}
+	return description
 }
 
 func (c *Client) authenticateWithClientCredentials() (*oauth2.Token, error) {
@@ -351,7 +1246,7 @@ func (c *Client) authenticateWithClientCredentials() (*oauth2.Token, error) {
 		return nil, errors.New("missing audience identifer")
 	}
 
-	ctx := oidc.InsecureIssuerURLContext(context.Background(), c.AuthConfig.Authorizer)
+	ctx := oidc.InsecureIssuerURLContext(c.authContext(context.Background()), c.AuthConfig.Authorizer)
 	provider, err := oidc.NewProvider(ctx, c.AuthConfig.Authorizer)
 	if err != nil {
 		return nil, err
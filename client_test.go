@@ -1,9 +1,35 @@
 package datahub
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	egdm "github.com/mimiro-io/entity-graph-data-model"
+	"golang.org/x/oauth2"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 type TestConfig struct {
@@ -30,6 +56,31 @@ func getTestConfig() *TestConfig {
 	return testConfig
 }
 
+func TestNewClientValidatesServer(t *testing.T) {
+	for _, server := range []string{"localhost:8080", "datahub.example.com", "ftp://datahub.example.com", "http://", "http://[::1", "https://example.com/datahub?x=1"} {
+		_, err := NewClient(server)
+		var paramErr *ParameterError
+		if !errors.As(err, &paramErr) {
+			t.Errorf("expected a ParameterError for server '%s', got %v", server, err)
+		}
+	}
+
+	for server, expected := range map[string]string{
+		"http://localhost:8080":         "http://localhost:8080",
+		"https://datahub.example.com/":  "https://datahub.example.com",
+		"https://example.com/datahub//": "https://example.com/datahub",
+	} {
+		client, err := NewClient(server)
+		if err != nil {
+			t.Errorf("expected server '%s' to be valid, got %v", server, err)
+			continue
+		}
+		if client.Server != expected {
+			t.Errorf("expected server '%s', got '%s'", expected, client.Server)
+		}
+	}
+}
+
 func TestClientCredentialsAuthenticate(t *testing.T) {
 	testConfig := getTestConfig()
 
@@ -106,3 +157,1551 @@ func TestClientCertificateAuthenticate(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestBasicAuthHeader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.WithBasicAuthHeader("admin", "secret")
+
+	_, err = client.GetDatasets()
+	if err != nil {
+		t.Error(err)
+	}
+
+	client.WithBasicAuthHeader("admin", "wrong")
+	_, err = client.GetDatasets()
+	if err == nil {
+		t.Error("expected request with wrong credentials to fail")
+	}
+}
+
+// makeTestCertificate creates a self-signed certificate for use as a TLS client certificate in tests
+func makeTestCertificate(t *testing.T) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "datahub-client-sdk-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, cert
+}
+
+func TestClientCertificate(t *testing.T) {
+	clientCert, clientX509 := makeTestCertificate(t)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientX509)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	serverCAs := x509.NewCertPool()
+	serverCAs.AddCert(server.Certificate())
+
+	// without a client certificate the handshake is rejected
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.WithClientCertificate(tls.Certificate{}, serverCAs)
+	_, err = client.GetDatasets()
+	if err == nil {
+		t.Error("expected request without client certificate to fail")
+	}
+
+	client.WithClientCertificate(clientCert, serverCAs)
+	_, err = client.GetDatasets()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSelfSignedServer(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	// verification is on by default
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.GetDatasets()
+	if err == nil {
+		t.Error("expected request to self-signed server to fail by default")
+	}
+
+	// trust the server certificate explicitly
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(server.Certificate())
+	client.WithTLSConfig(&tls.Config{RootCAs: rootCAs})
+	_, err = client.GetDatasets()
+	if err != nil {
+		t.Error(err)
+	}
+
+	// skip verification
+	client, err = NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.WithInsecureSkipVerify()
+	_, err = client.GetDatasets()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPublicKeyAuthTokenExpiry(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// access token carrying an exp claim but no expires_in in the response
+	jwtExpiry := time.Now().Add(30 * time.Minute).Truncate(time.Second)
+	jwtToken, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(jwtExpiry),
+	}).SignedString(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenResponse := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(tokenResponse))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.WithPublicKeyAuth("test-client", privateKey)
+
+	tokenResponse = `{"access_token":"opaque","token_type":"Bearer","expires_in":3600}`
+	err = client.Authenticate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if client.AuthToken.Expiry.Before(time.Now().Add(59*time.Minute)) || client.AuthToken.Expiry.After(time.Now().Add(61*time.Minute)) {
+		t.Errorf("expected expiry in about an hour from expires_in, got %v", client.AuthToken.Expiry)
+	}
+
+	client.AuthToken = nil
+	tokenResponse = `{"access_token":"` + jwtToken + `","token_type":"Bearer"}`
+	err = client.Authenticate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !client.AuthToken.Expiry.Equal(jwtExpiry) {
+		t.Errorf("expected expiry from exp claim to be %v, got %v", jwtExpiry, client.AuthToken.Expiry)
+	}
+}
+
+func TestPublicKeyAuthErrorResponse(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := http.StatusUnauthorized
+	tokenResponse := `{"error":"invalid_client","error_description":"unknown client"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(tokenResponse))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.WithPublicKeyAuth("test-client", privateKey)
+
+	err = client.Authenticate()
+	var authErr *AuthenticationError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("expected AuthenticationError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "unknown client") {
+		t.Errorf("expected error to include the error description, got '%s'", err.Error())
+	}
+
+	// a non-JSON error page from a gateway must still report the status
+	status = http.StatusBadGateway
+	tokenResponse = `<html>bad gateway</html>`
+	err = client.Authenticate()
+	if !errors.As(err, &authErr) {
+		t.Fatalf("expected AuthenticationError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "502") {
+		t.Errorf("expected error to include the http status, got '%s'", err.Error())
+	}
+
+	// a successful status without an access token must not panic
+	status = http.StatusOK
+	tokenResponse = `{"access_token":42}`
+	err = client.Authenticate()
+	if !errors.As(err, &authErr) {
+		t.Errorf("expected AuthenticationError, got %v", err)
+	}
+}
+
+func TestPublicKeyAuthInvalidKey(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte(`{"access_token":"token"}`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nil key
+	client.WithPublicKeyAuth("test-client", nil)
+	err = client.Authenticate()
+	var authErr *AuthenticationError
+	if !errors.As(err, &authErr) {
+		t.Errorf("expected AuthenticationError for nil key, got %v", err)
+	}
+
+	// key that cannot be used for signing
+	client.WithPublicKeyAuth("test-client", &rsa.PrivateKey{})
+	err = client.Authenticate()
+	if !errors.As(err, &authErr) {
+		t.Errorf("expected AuthenticationError for invalid key, got %v", err)
+	} else if !strings.Contains(err.Error(), "unable to create signed token request") {
+		t.Errorf("expected the signing failure to be reported, got '%s'", err.Error())
+	}
+
+	// ecdsa key without a curve
+	client.WithECPublicKeyAuth("test-client", &ecdsa.PrivateKey{})
+	err = client.Authenticate()
+	if !errors.As(err, &authErr) {
+		t.Errorf("expected AuthenticationError for invalid ecdsa key, got %v", err)
+	}
+
+	if requests != 0 {
+		t.Errorf("expected no token request to be sent, got %d", requests)
+	}
+}
+
+func TestPublicKeyAuthAudience(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	audience := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := jwt.RegisteredClaims{}
+		_, err := jwt.ParseWithClaims(r.FormValue("client_assertion"), &claims, func(token *jwt.Token) (interface{}, error) {
+			return &privateKey.PublicKey, nil
+		})
+		if err != nil || len(claims.Audience) != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		audience = claims.Audience[0]
+		_, _ = w.Write([]byte(`{"access_token":"token","expires_in":60}`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.WithPublicKeyAuth("test-client", privateKey)
+	err = client.Authenticate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if audience != "datahub-client-sdk" {
+		t.Errorf("expected default audience 'datahub-client-sdk', got '%s'", audience)
+	}
+
+	client.AuthToken = nil
+	client.WithPublicKeyAuth("test-client", privateKey).WithAudience("my-datahub")
+	err = client.Authenticate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if audience != "my-datahub" {
+		t.Errorf("expected audience 'my-datahub', got '%s'", audience)
+	}
+}
+
+func TestSaveAndLoadToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.json")
+
+	client, err := NewClient("http://localhost:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a missing file leaves the client unchanged
+	err = client.LoadToken(path)
+	if err != nil {
+		t.Errorf("expected no error loading a missing token file, got %v", err)
+	}
+	if client.AuthToken != nil {
+		t.Error("expected no token to be loaded")
+	}
+
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	client.WithExistingToken(&oauth2.Token{AccessToken: "access", TokenType: "Bearer", RefreshToken: "refresh", Expiry: expiry})
+	err = client.SaveToken(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected token file mode 0600, got %v", info.Mode().Perm())
+	}
+
+	restored, err := NewClient("http://localhost:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = restored.LoadToken(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if restored.AuthToken == nil || restored.AuthToken.AccessToken != "access" || restored.AuthToken.RefreshToken != "refresh" ||
+		!restored.AuthToken.Expiry.Equal(expiry) {
+		t.Errorf("unexpected restored token %+v", restored.AuthToken)
+	}
+
+	var paramErr *ParameterError
+	if !errors.As(client.SaveToken(""), &paramErr) || !errors.As(client.LoadToken(""), &paramErr) {
+		t.Error("expected ParameterError for an empty path")
+	}
+}
+
+func TestKeypairFiles(t *testing.T) {
+	dir := t.TempDir()
+	client, err := NewClient("http://localhost:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// two identities in the same directory
+	err = client.SaveKeypairFiles(filepath.Join(dir, "client-a.pem"), filepath.Join(dir, "client-a.pub.pem"), privateKey, &privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.SaveKeypair(dir, privateKey, &privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loadedPrivate, loadedPublic, err := client.LoadKeypairFiles(filepath.Join(dir, "client-a.pem"), filepath.Join(dir, "client-a.pub.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loadedPrivate.Equal(privateKey) || !loadedPublic.Equal(&privateKey.PublicKey) {
+		t.Error("expected loaded keypair to match the saved keypair")
+	}
+
+	_, _, err = client.LoadKeypair(dir)
+	if err != nil {
+		t.Errorf("expected default keypair to load, got %v", err)
+	}
+
+	var paramErr *ParameterError
+	_, _, err = client.LoadKeypairFiles(filepath.Join(dir, "missing.pem"), filepath.Join(dir, "client-a.pub.pem"))
+	if !errors.As(err, &paramErr) {
+		t.Errorf("expected ParameterError for a missing key file, got %v", err)
+	}
+}
+
+func TestLoadEncryptedKeypair(t *testing.T) {
+	dir := t.TempDir()
+	client, err := NewClient("http://localhost:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// write a passphrase protected key as produced by openssl rsa -aes256
+	block, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(privateKey), []byte("secret"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "node_key"), pem.EncodeToMemory(block), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKeyPem, err := exportRsaPublicKeyAsPem(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "node_key.pub"), publicKeyPem, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loadedPrivate, _, err := client.LoadEncryptedKeypair(dir, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loadedPrivate.Equal(privateKey) {
+		t.Error("expected decrypted key to match")
+	}
+
+	_, _, err = client.LoadEncryptedKeypair(dir, []byte("wrong"))
+	var paramErr *ParameterError
+	if !errors.As(err, &paramErr) || !strings.Contains(err.Error(), "passphrase") {
+		t.Errorf("expected passphrase error, got %v", err)
+	}
+
+	// an unencrypted key is reported as such
+	_, _, err = client.LoadKeypair(dir)
+	if err == nil {
+		t.Error("expected error loading an encrypted key without a passphrase")
+	}
+}
+
+type recordingLogger struct {
+	debug []string
+	error []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...any) {
+	l.debug = append(l.debug, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Errorf(format string, args ...any) {
+	l.error = append(l.error, fmt.Sprintf(format, args...))
+}
+
+func TestWithLogger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[]`))
+	}))
+
+	logger := &recordingLogger{}
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.WithLogger(logger)
+
+	_, err = client.GetDatasets()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(logger.debug) != 1 || !strings.HasPrefix(logger.debug[0], "GET /datasets 200 ") {
+		t.Errorf("expected request to be logged, got %v", logger.debug)
+	}
+
+	// failed requests are logged as errors
+	server.Close()
+	_, err = client.GetDatasets()
+	if err == nil {
+		t.Fatal("expected error from a closed server")
+	}
+	if len(logger.error) != 1 || !strings.HasPrefix(logger.error[0], "GET /datasets failed after ") {
+		t.Errorf("expected failure to be logged, got %v", logger.error)
+	}
+}
+
+func TestECPublicKeyAuth(t *testing.T) {
+	client, err := NewClient("http://localhost:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateKey, publicKey, err := client.GenerateECKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// round trip the keypair through PEM files
+	dir := t.TempDir()
+	err = client.SaveECKeypairFiles(filepath.Join(dir, "ec_key"), filepath.Join(dir, "ec_key.pub"), privateKey, publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, publicKey, err = client.LoadECKeypairFiles(filepath.Join(dir, "ec_key"), filepath.Join(dir, "ec_key.pub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// stub token endpoint that verifies the client assertion with the public key
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		token, err := jwt.Parse(r.PostForm.Get("client_assertion"), func(token *jwt.Token) (any, error) {
+			return publicKey, nil
+		}, jwt.WithValidMethods([]string{"ES256"}), jwt.WithAudience(defaultPublicKeyAudience), jwt.WithSubject("ec-client"))
+		if err != nil || !token.Valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token":"ec-token","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	ecClient, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecClient.WithECPublicKeyAuth("ec-client", privateKey)
+
+	err = ecClient.Authenticate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ecClient.AuthToken.AccessToken != "ec-token" {
+		t.Errorf("expected ec-token, got %s", ecClient.AuthToken.AccessToken)
+	}
+
+	// a key that does not match the registered public key is rejected
+	otherKey, _, err := client.GenerateECKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecClient.WithECPublicKeyAuth("ec-client", otherKey)
+	ecClient.AuthToken = nil
+	err = ecClient.Authenticate()
+	var authErr *AuthenticationError
+	if !errors.As(err, &authErr) {
+		t.Errorf("expected AuthenticationError, got %v", err)
+	}
+}
+
+type recordingObserver struct {
+	lock   sync.Mutex
+	events []RequestEvent
+}
+
+func (o *recordingObserver) ObserveRequest(event RequestEvent) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.events = append(o.events, event)
+}
+
+func (o *recordingObserver) Events() []RequestEvent {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return append([]RequestEvent(nil), o.events...)
+}
+
+func TestWithObserver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if r.URL.Path == "/datasets/people/changes" {
+			_, _ = w.Write([]byte(`[{"id":"@context","namespaces":{}}]`))
+			return
+		}
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	observer := &recordingObserver{}
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.WithObserver(observer)
+
+	_, err = client.GetDatasets()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ec := egdm.NewEntityCollection(nil)
+	_ = ec.AddEntity(egdm.NewEntity().SetID("http://data.example.com/things/entity1"))
+	err = client.StoreEntities("people", ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// streaming requests are reported once the body is closed
+	reader, err := client.GetChangesReader("people", ChangesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(observer.Events()) != 2 {
+		t.Errorf("expected the open stream not to be reported yet, got %d events", len(observer.Events()))
+	}
+	_ = reader.Close()
+
+	events := observer.Events()
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+
+	if events[0].Method != "GET" || events[0].Path != "/datasets" || events[0].StatusCode != 200 || events[0].BytesReceived != 2 {
+		t.Errorf("unexpected event for get datasets %+v", events[0])
+	}
+	if events[1].Method != "POST" || events[1].Path != "/datasets/people/entities" || events[1].BytesSent == 0 {
+		t.Errorf("unexpected event for store entities %+v", events[1])
+	}
+	if events[2].Path != "/datasets/people/changes" || events[2].Duration <= 0 {
+		t.Errorf("unexpected event for changes reader %+v", events[2])
+	}
+}
+
+func TestPublicKeyAuthAssertionClaims(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims jwt.RegisteredClaims
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims = jwt.RegisteredClaims{}
+		_, err := jwt.ParseWithClaims(r.FormValue("client_assertion"), &claims, func(token *jwt.Token) (interface{}, error) {
+			return &privateKey.PublicKey, nil
+		}, jwt.WithIssuedAt())
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token":"token","expires_in":60}`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		validity  time.Duration
+		clockSkew time.Duration
+		expected  time.Duration
+		notBefore time.Duration
+	}{
+		{expected: time.Minute, notBefore: 30 * time.Second},
+		{validity: 5 * time.Minute, clockSkew: 2 * time.Minute, expected: 5 * time.Minute, notBefore: 2 * time.Minute},
+	}
+	for _, tc := range testCases {
+		client.AuthToken = nil
+		client.WithPublicKeyAuth("test-client", privateKey).WithAssertionValidity(tc.validity, tc.clockSkew)
+		err = client.Authenticate()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if claims.IssuedAt == nil || claims.NotBefore == nil || claims.ExpiresAt == nil {
+			t.Fatalf("expected iat, nbf and exp claims, got %+v", claims)
+		}
+		if claims.ExpiresAt.Sub(claims.IssuedAt.Time) != tc.expected {
+			t.Errorf("expected validity of %s, got %s", tc.expected, claims.ExpiresAt.Sub(claims.IssuedAt.Time))
+		}
+		if claims.IssuedAt.Sub(claims.NotBefore.Time) != tc.notBefore {
+			t.Errorf("expected not before %s before issue, got %s", tc.notBefore, claims.IssuedAt.Sub(claims.NotBefore.Time))
+		}
+		if time.Since(claims.IssuedAt.Time) > time.Minute {
+			t.Errorf("expected issued at to be now, got %v", claims.IssuedAt)
+		}
+	}
+}
+
+func TestRequestObserverFuncSeesRetriesAndErrors(t *testing.T) {
+	var dropped atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/datasets":
+			_, _ = w.Write([]byte(`[]`))
+		case "/datasets/people/changes":
+			if !dropped.Swap(true) {
+				// drop the connection part way through the first response
+				w.Header().Set("Content-Length", "1000")
+				_, _ = w.Write([]byte(`[{"id":"@context"`))
+				w.(http.Flusher).Flush()
+				panic(http.ErrAbortHandler)
+			}
+			_, _ = w.Write([]byte(`[{"id":"@context","namespaces":{}},{"id":"@continuation","token":"t1"}]`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	var lock sync.Mutex
+	counts := map[string]int{}
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.WithStreamRetries(1, time.Millisecond).WithObserver(RequestObserverFunc(func(event RequestEvent) {
+		lock.Lock()
+		defer lock.Unlock()
+		counts[fmt.Sprintf("%s %s %d %t", event.Method, event.Path, event.StatusCode, event.Err != nil)]++
+	}))
+
+	_, err = client.GetDatasets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.GetDataset("places")
+	if err == nil {
+		t.Fatal("expected an error for the failing request")
+	}
+	stream, err := client.GetChangesStream("people", "", false, 0, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity, err := stream.Next()
+	if err != nil || entity != nil {
+		t.Fatalf("expected the retried stream to end without entities, got %v, %v", entity, err)
+	}
+
+	// the dropped attempt is reported with its error, then the retry and the request for the next page
+	expected := map[string]int{
+		"GET /datasets 200 false":                1,
+		"GET /datasets/places 500 false":         1,
+		"GET /datasets/people/changes 200 true":  1,
+		"GET /datasets/people/changes 200 false": 2,
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("expected %v, got %v", expected, counts)
+	}
+}
+
+func TestWithHeader(t *testing.T) {
+	received := make(chan http.Header, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+		_, _ = io.Copy(io.Discard, r.Body)
+		if r.Method == http.MethodGet || r.URL.Path == "/query" {
+			_, _ = w.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.WithHeader("X-Api-Key", "secret").
+		WithHeaders(map[string]string{"X-Tenant": "tenant1", "Content-Type": "text/plain"})
+
+	_, err = client.GetJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := <-received
+	if headers.Get("X-Api-Key") != "secret" || headers.Get("X-Tenant") != "tenant1" {
+		t.Errorf("expected the client headers to be sent, got %v", headers)
+	}
+
+	// streaming writer requests send the headers too
+	err = client.StoreEntityStream("people", strings.NewReader(`[{"id":"@context","namespaces":{}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers = <-received
+	if headers.Get("X-Api-Key") != "secret" {
+		t.Errorf("expected the client headers to be sent with a streaming upload, got %v", headers)
+	}
+
+	// request specific headers take precedence
+	_, err = client.RunJavascriptQuery("function do_query() {}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers = <-received
+	if headers.Get("Content-Type") != "application/x-javascript-query" || headers.Get("X-Tenant") != "tenant1" {
+		t.Errorf("expected the query content type to take precedence, got %v", headers)
+	}
+}
+
+func TestWithBaseHeaders(t *testing.T) {
+	received := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.WithExistingToken(&oauth2.Token{AccessToken: "token", Expiry: time.Now().Add(time.Hour)}).
+		WithBaseHeaders(map[string]string{"X-Tenant-Id": "tenant1", "X-Api-Key": "key"})
+
+	_, err = client.GetDatasets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := <-received
+	if headers.Get("X-Tenant-Id") != "tenant1" || headers.Get("X-Api-Key") != "key" || headers.Get("Authorization") != "Bearer token" {
+		t.Errorf("expected the client headers next to the bearer token, got %v", headers)
+	}
+
+	// an explicitly set Authorization header replaces the bearer token, for example for a gateway
+	client.WithHeader("Authorization", "ApiKey gateway")
+	_, err = client.GetDatasets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers = <-received
+	if headers.Get("Authorization") != "ApiKey gateway" {
+		t.Errorf("expected the explicit Authorization header, got %v", headers)
+	}
+}
+
+func TestWithRequestIDFunc(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("X-Correlation-Id"))
+		if r.URL.Path == "/jobs/missing" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	ids := 0
+	logger := &recordingLogger{}
+	observer := &recordingObserver{}
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.WithLogger(logger).WithObserver(observer).
+		WithRequestIDFunc(func() string {
+			ids++
+			return fmt.Sprintf("id-%d", ids)
+		}).
+		WithRequestIDHeader("X-Correlation-Id")
+
+	_, err = client.GetJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.GetJob("missing")
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) {
+		t.Fatalf("expected a RequestError, got %v", err)
+	}
+	if reqErr.RequestID() != "id-2" || !strings.Contains(err.Error(), "id-2") {
+		t.Errorf("expected the error to carry request id id-2, got '%s' from %v", reqErr.RequestID(), err)
+	}
+
+	if len(received) != 2 || received[0] != "id-1" || received[1] != "id-2" {
+		t.Errorf("expected a new request id per request, got %v", received)
+	}
+	events := observer.Events()
+	if len(events) != 2 || events[0].RequestID != "id-1" || events[1].RequestID != "id-2" {
+		t.Errorf("expected the observer to receive the request ids, got %v", events)
+	}
+	if len(logger.debug) == 0 || !strings.Contains(logger.debug[0], "request id id-1") {
+		t.Errorf("expected the request id to be logged, got %v", logger.debug)
+	}
+
+	// the default generator sends a uuid in the X-Request-Id header
+	var header string
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get(DefaultRequestIDHeader)
+		_, _ = w.Write([]byte(`[]`))
+	})
+	client.WithRequestIDFunc(nil).WithRequestIDHeader("")
+	_, err = client.GetJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uuid.Parse(header); err != nil {
+		t.Errorf("expected a uuid request id, got '%s'", header)
+	}
+}
+
+func TestWithRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.WithRateLimit(20, 2)
+
+	// the burst is sent straight away and the remaining 2 requests wait 50ms each
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		_, err = client.GetJobs()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("expected the requests to be rate limited, took %s", elapsed)
+	}
+
+	client.WithRateLimit(0, 0)
+	if client.rateLimiter != nil {
+		t.Error("expected a rate of 0 to remove the limit")
+	}
+}
+
+func TestServerErrorPayload(t *testing.T) {
+	testCases := []struct {
+		name      string
+		body      string
+		serverErr *ServerError
+	}{
+		{name: "code and message", body: `{"code":"dataset_missing","message":"dataset people not found"}`,
+			serverErr: &ServerError{StatusCode: http.StatusNotFound, Code: "dataset_missing", Message: "dataset people not found"}},
+		{name: "numeric code", body: `{"code":404,"message":"not found"}`,
+			serverErr: &ServerError{StatusCode: http.StatusNotFound, Code: "404", Message: "not found"}},
+		{name: "message only", body: `{"message":"not found"}`,
+			serverErr: &ServerError{StatusCode: http.StatusNotFound, Message: "not found"}},
+		{name: "plain text", body: `dataset people not found`},
+		{name: "unrelated json", body: `{"id":"people"}`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+
+			client, err := NewClient(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = client.GetDatasetEntity("people")
+			var reqErr *RequestError
+			if !errors.As(err, &reqErr) {
+				t.Fatalf("expected a RequestError, got %v", err)
+			}
+			if !strings.Contains(err.Error(), tc.body) {
+				t.Errorf("expected the error to include the response body, got '%s'", err.Error())
+			}
+
+			var serverErr *ServerError
+			found := errors.As(err, &serverErr)
+			if tc.serverErr == nil {
+				if found {
+					t.Errorf("expected no ServerError, got %v", serverErr)
+				}
+				return
+			}
+			if !found || *serverErr != *tc.serverErr {
+				t.Errorf("expected ServerError %v, got %v", tc.serverErr, serverErr)
+			}
+		})
+	}
+}
+
+func TestWithMaxResponseSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"id":"job1","title":"job one"}]`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.WithMaxResponseSize(10)
+	_, err = client.GetJobs()
+	var processingErr *ClientProcessingError
+	if !errors.As(err, &processingErr) {
+		t.Errorf("expected a ClientProcessingError for a response over the limit, got %v", err)
+	}
+
+	client.WithMaxResponseSize(1024)
+	jobs, err := client.GetJobs()
+	if err != nil || len(jobs) != 1 {
+		t.Errorf("expected a response under the limit to be read, got %v, %v", jobs, err)
+	}
+}
+
+func TestClone(t *testing.T) {
+	var authorizations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization")+" "+r.Header.Get("X-Tenant"))
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.WithAdminAuth("admin", "admin-secret").WithHeader("X-Tenant", "tenant1").WithBatchSize(5)
+	client.AuthToken = &oauth2.Token{AccessToken: "admin-token", Expiry: time.Now().Add(time.Hour)}
+
+	clone := client.Clone()
+	if clone.AuthToken != nil {
+		t.Error("expected the clone not to copy the token")
+	}
+	if clone.Server != client.Server || clone.batchSize != 5 || clone.AuthConfig.ClientID != "admin" {
+		t.Errorf("expected the clone to copy the server, options and auth config, got %v", clone)
+	}
+
+	clone.WithBasicAuthHeader("registered-client", "client-secret").WithHeader("X-Tenant", "tenant2")
+	if client.AuthConfig.AuthType != AuthTypeBasic || client.AuthConfig.ClientID != "admin" {
+		t.Errorf("expected the original auth config to be unchanged, got %v", client.AuthConfig)
+	}
+
+	_, err = client.GetJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = clone.GetJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("registered-client:client-secret"))
+	expected := []string{"Bearer admin-token tenant1", basic + " tenant2"}
+	if len(authorizations) != 2 || authorizations[0] != expected[0] || authorizations[1] != expected[1] {
+		t.Errorf("expected %v, got %v", expected, authorizations)
+	}
+}
+
+func TestWithDryRun(t *testing.T) {
+	type capturedRequest struct {
+		method string
+		path   string
+		body   string
+	}
+	var captured []capturedRequest
+
+	// neither the server nor the authorizer exist, dry run mode must not contact them
+	client, err := NewClient("http://localhost:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.WithClientKeyAndSecretAuth("http://localhost:1", "audience", "key", "secret").
+		WithDryRun(func(method string, path string, body []byte) {
+			captured = append(captured, capturedRequest{method: method, path: path, body: string(body)})
+		})
+
+	job := NewJobBuilder("people-sync", "job-1").WithDatasetSource("people", true).WithDatasetSink("people-copy").Build()
+	err = client.AddJob(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.DeleteJob("job-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(captured) != 2 {
+		t.Fatalf("expected 2 captured requests, got %v", captured)
+	}
+	if captured[0].method != "POST" || captured[0].path != "/jobs" {
+		t.Errorf("expected POST /jobs, got %s %s", captured[0].method, captured[0].path)
+	}
+	expected := `{"title":"people-sync","id":"job-1","description":"",` +
+		`"source":{"LatestOnly":true,"Name":"people","Type":"DatasetSource"},` +
+		`"sink":{"Name":"people-copy","Type":"DatasetSink"},"paused":false,"batchSize":0}`
+	if captured[0].body != expected {
+		t.Errorf("expected body %s, got %s", expected, captured[0].body)
+	}
+	if captured[1].method != "DELETE" || captured[1].path != "/jobs/job-1" || captured[1].body != "" {
+		t.Errorf("expected DELETE /jobs/job-1 without a body, got %v", captured[1])
+	}
+	if client.AuthToken != nil {
+		t.Errorf("expected the client not to authenticate in dry run mode")
+	}
+}
+
+func TestWithRequestLogger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		if r.URL.Path == "/jobs" {
+			_, _ = w.Write([]byte(`[{"id":"job-1","title":"people-sync"},{"id":"job-2","title":"places-sync"}]`))
+		}
+	}))
+	defer server.Close()
+
+	var entries []LogEntry
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.WithBasicAuthHeader("user", "very-secret-password").
+		WithHeader("X-Api-Key", "very-secret-key").
+		WithRequestLogger(func(entry LogEntry) {
+			entries = append(entries, entry)
+		}, 60)
+
+	_, err = client.GetJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.AddTokenProvider(&ProviderConfig{Name: "provider", Type: "bearer",
+		ClientSecret: &ValueReader{Type: "text", Value: "very-secret-value"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %v", entries)
+	}
+	if entries[0].Method != "GET" || entries[0].URL != server.URL+"/jobs" || entries[0].StatusCode != 200 || entries[0].Duration <= 0 {
+		t.Errorf("unexpected entry %v", entries[0])
+	}
+	if entries[0].ResponseBody != `[{"id":"job-1","title":"people-sync"},{"id":"job-2","title":...` {
+		t.Errorf("expected the truncated response body, got %s", entries[0].ResponseBody)
+	}
+	for _, entry := range entries {
+		if entry.RequestHeader.Get("Authorization") != "REDACTED" || entry.RequestHeader.Get("X-Api-Key") != "REDACTED" {
+			t.Errorf("expected the credentials to be redacted, got %v", entry.RequestHeader)
+		}
+		if strings.Contains(fmt.Sprint(entry), "very-secret") {
+			t.Errorf("expected no credentials in the entry, got %v", entry)
+		}
+	}
+	// the body is truncated in the secret, the part that was kept is redacted
+	if entries[1].RequestBody != `{"name":"provider","type":"bearer","secret":"REDACTED"...` {
+		t.Errorf("expected the provider secret to be redacted, got %s", entries[1].RequestBody)
+	}
+}
+
+func TestDo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/admin/echo":
+			_, _ = fmt.Fprintf(w, `{"received":%s,"mode":"%s","auth":"%s"}`, body, r.URL.Query().Get("mode"), r.Header.Get("Authorization"))
+		case r.Method == http.MethodDelete && r.URL.Path == "/admin/cache":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.WithExistingToken(&oauth2.Token{AccessToken: "token", Expiry: time.Now().Add(time.Hour)})
+
+	var out struct {
+		Received map[string]int `json:"received"`
+		Mode     string         `json:"mode"`
+		Auth     string         `json:"auth"`
+	}
+	err = client.Do("post", "/admin/echo", map[string]int{"count": 2}, map[string]string{"mode": "fast"}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Received["count"] != 2 || out.Mode != "fast" || out.Auth != "Bearer token" {
+		t.Errorf("unexpected response %+v", out)
+	}
+
+	var raw []byte
+	err = client.Do("POST", "/admin/echo", []byte(`"as is"`), nil, &raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(raw), `{"received":"as is"`) {
+		t.Errorf("expected the raw body to be sent and returned, got %s", raw)
+	}
+
+	// an empty response leaves out unchanged
+	err = client.Do("DELETE", "/admin/cache", nil, nil, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.Do("GET", "/admin/missing", nil, nil, nil)
+	var requestErr *RequestError
+	var statusErr *httpStatusError
+	if !errors.As(err, &requestErr) || !errors.As(err, &statusErr) || statusErr.statusCode != http.StatusNotFound {
+		t.Errorf("expected a RequestError for the missing endpoint, got %v", err)
+	}
+
+	var paramErr *ParameterError
+	if err := client.Do("PATCH", "/admin/echo", nil, nil, nil); !errors.As(err, &paramErr) {
+		t.Errorf("expected a ParameterError for an unsupported method, got %v", err)
+	}
+	if err := client.Do("GET", "admin/echo", nil, nil, nil); !errors.As(err, &paramErr) {
+		t.Errorf("expected a ParameterError for a relative path, got %v", err)
+	}
+}
+
+func TestServerWithPathPrefix(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		paths = append(paths, r.Method+" "+r.URL.EscapedPath()+" "+r.URL.RawQuery)
+		if r.URL.Path == "/datahub/security/token" {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"access_token":"token","token_type":"Bearer","expires_in":3600}`))
+			return
+		}
+		if r.URL.Path == "/datahub/datasets/people/changes" {
+			_, _ = w.Write([]byte(`[{"id":"@context","namespaces":{}}]`))
+		} else if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+
+	for _, serverURL := range []string{server.URL + "/datahub", server.URL + "/datahub/"} {
+		paths = nil
+		client, err := NewClient(serverURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.WithAdminAuth("admin", "secret")
+
+		_, err = client.GetDatasets()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = client.StoreEntityStream("people", strings.NewReader(`[{"id":"@context","namespaces":{}}]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.GetChanges("people", "abc", 0, false, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.GetTokenProvider("my provider")
+		if err == nil {
+			t.Error("expected the empty response not to parse as a provider")
+		}
+
+		expected := []string{
+			"POST /datahub/security/token ",
+			"GET /datahub/datasets ",
+			"POST /datahub/datasets/people/entities ",
+			"GET /datahub/datasets/people/changes since=abc",
+			"GET /datahub/provider/login/my+provider ",
+		}
+		if !reflect.DeepEqual(paths, expected) {
+			t.Errorf("server %s: expected %v, got %v", serverURL, expected, paths)
+		}
+	}
+}
+
+func TestStreamingWriterRequestUnblocksWriterOnFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// reject the upload without reading the body
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writerDone := make(chan error, 1)
+	writeBody := func(writer io.Writer) error {
+		chunk := bytes.Repeat([]byte("x"), 1<<20)
+		for i := 0; i < 256; i++ {
+			if _, err := writer.Write(chunk); err != nil {
+				writerDone <- err
+				return err
+			}
+		}
+		writerDone <- nil
+		return nil
+	}
+
+	_, err = client.makeHttpClient().makeStreamingWriterRequest(httpPost, "/datasets/people/entities", writeBody, nil, nil)
+	if err == nil {
+		t.Fatal("expected the rejected upload to fail")
+	}
+
+	select {
+	case err := <-writerDone:
+		if err == nil {
+			t.Error("expected the write to fail once the request failed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("writer is still blocked after the request failed")
+	}
+}
+
+func TestStreamingWriterRequestReturnsWriteBodyError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serializeErr := errors.New("unable to serialise entity")
+	writeBody := func(writer io.Writer) error {
+		if _, err := writer.Write([]byte(`[{"id":"@context","namespaces":{}},`)); err != nil {
+			return err
+		}
+		return serializeErr
+	}
+
+	_, err = client.makeHttpClient().makeStreamingWriterRequest(httpPost, "/datasets/people/entities", writeBody, nil, nil)
+	if !errors.Is(err, serializeErr) {
+		t.Errorf("expected the write body error, got %v", err)
+	}
+
+	// the error is also returned through the public functions that stream the body
+	err = client.StoreEntityStream("people", io.MultiReader(strings.NewReader(`[{"id":"@context","namespaces":{}},{"id":"a"`), iotest.ErrReader(serializeErr)))
+	var requestErr *RequestError
+	if !errors.As(err, &requestErr) || !strings.Contains(err.Error(), "writing request body") {
+		t.Errorf("expected a RequestError for the failed body, got %v", err)
+	}
+}
+
+func TestConflictStatusIsConflictError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		switch {
+		case r.Method == http.MethodPost:
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"message":"already exists"}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.AddJob(NewJobBuilder("people import", "job1").WithDatasetSource("people", false).Build())
+	var requestErr *RequestError
+	var conflictErr *ConflictError
+	var serverErr *ServerError
+	if !errors.As(err, &requestErr) || !errors.As(err, &conflictErr) {
+		t.Errorf("expected a RequestError with a ConflictError, got %v", err)
+	}
+	if !errors.As(err, &serverErr) || serverErr.Message != "already exists" {
+		t.Errorf("expected the server error in the conflict, got %v", err)
+	}
+
+	// streaming writer requests map the status too
+	err = client.StoreEntityStream("people", strings.NewReader(`[{"id":"@context","namespaces":{}}]`))
+	if !errors.As(err, &conflictErr) {
+		t.Errorf("expected a ConflictError, got %v", err)
+	}
+
+	_, err = client.GetJobs()
+	if errors.As(err, &conflictErr) {
+		t.Errorf("expected no ConflictError for status 400, got %v", err)
+	}
+}
+
+func TestForbiddenStatusIsForbiddenError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/datasets/restricted/changes" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.GetChanges("restricted", "", NoLimit, false, false, false)
+	var forbiddenErr *ForbiddenError
+	var authErr *AuthenticationError
+	if !errors.As(err, &forbiddenErr) || errors.As(err, &authErr) {
+		t.Errorf("expected a ForbiddenError and no AuthenticationError, got %v", err)
+	}
+
+	_, err = client.GetChanges("people", "", NoLimit, false, false, false)
+	if errors.As(err, &forbiddenErr) {
+		t.Errorf("expected no ForbiddenError for status 401, got %v", err)
+	}
+}
+
+func TestPermanentAuthFailureIsCached(t *testing.T) {
+	var tokenRequests atomic.Int32
+	var tokenStatus atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/security/token" {
+			_, _ = w.Write([]byte(`[]`))
+			return
+		}
+		// the oauth2 package tries the credentials in a header first and then in the form, count the first only
+		_, password, ok := r.BasicAuth()
+		if ok {
+			tokenRequests.Add(1)
+		} else {
+			_ = r.ParseForm()
+			password = r.PostForm.Get("client_secret")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case tokenStatus.Load() != 0:
+			w.WriteHeader(int(tokenStatus.Load()))
+		case password != "right":
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+		default:
+			_, _ = w.Write([]byte(`{"access_token":"token","token_type":"Bearer","expires_in":3600}`))
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.WithAdminAuth("admin", "wrong")
+
+	// the rejected credentials are only sent once
+	for i := 0; i < 3; i++ {
+		_, err = client.GetJobs()
+		var authErr *AuthenticationError
+		if !errors.As(err, &authErr) {
+			t.Fatalf("expected an AuthenticationError, got %v", err)
+		}
+	}
+	if tokenRequests.Load() != 1 {
+		t.Errorf("expected 1 token request, got %d", tokenRequests.Load())
+	}
+
+	// resetting or calling Authenticate tries again
+	client.ResetAuthentication()
+	_, _ = client.GetJobs()
+	_ = client.Authenticate()
+	if tokenRequests.Load() != 3 {
+		t.Errorf("expected 3 token requests, got %d", tokenRequests.Load())
+	}
+
+	// new credentials are used without a reset
+	client.WithAdminAuth("admin", "right")
+	if _, err = client.GetJobs(); err != nil {
+		t.Fatal(err)
+	}
+	if tokenRequests.Load() != 4 {
+		t.Errorf("expected 4 token requests, got %d", tokenRequests.Load())
+	}
+
+	// transient failures are not cached
+	tokenStatus.Store(http.StatusServiceUnavailable)
+	client.ResetAuthentication()
+	_, _ = client.GetJobs()
+	_, _ = client.GetJobs()
+	if tokenRequests.Load() != 6 {
+		t.Errorf("expected 6 token requests, got %d", tokenRequests.Load())
+	}
+}
+
+func TestWithAuthTimeout(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// an unresponsive authorizer
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.authTimeout != DefaultAuthTimeout {
+		t.Errorf("expected the default auth timeout, got %v", client.authTimeout)
+	}
+	client.WithAuthTimeout(100 * time.Millisecond)
+
+	for name, configure := range map[string]func(){
+		"public key": func() { client.WithPublicKeyAuth("test-client", privateKey) },
+		"admin":      func() { client.WithAdminAuth("admin", "secret") },
+	} {
+		configure()
+		start := time.Now()
+		err = client.Authenticate()
+		var authErr *AuthenticationError
+		if !errors.As(err, &authErr) {
+			t.Errorf("%s: expected AuthenticationError, got %v", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%s: expected the token request to time out, took %v", name, elapsed)
+		}
+	}
+}
@@ -1,19 +1,32 @@
 package datahub
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"fmt"
 	egdm "github.com/mimiro-io/entity-graph-data-model"
 	"io"
+	"net"
+	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 // Dataset represents a dataset in the data hub.
 // Name is a unique identifier for the dataset for a given data hub instance.
 // Metadata is a map of metadata properties for the dataset.
+// PublicNamespaces are the namespaces the dataset exposes when it is consumed externally. GetDataset always
+// populates them, GetDatasets only if the server includes them in the list of datasets.
 type Dataset struct {
-	Name     string
-	Metadata map[string]any
+	Name             string
+	Metadata         map[string]any
+	PublicNamespaces []string
 }
 
 // proxyDatasetConfig represents the configuration for a proxy dataset.
@@ -59,8 +72,21 @@ func (c *Client) GetDataset(name string) (*Dataset, error) {
 
 	dataset := &Dataset{}
 
-	// fixme: this is a fragile way to get the name of the dataset
-	dataset.Name = datasetEntity.Properties["ns0:name"].(string)
+	// the name is matched on its local name as the prefix is assigned by the server
+	for key, value := range datasetEntity.Properties {
+		if localName(key) == "name" {
+			dataset.Name, _ = value.(string)
+			break
+		}
+	}
+	if dataset.Name == "" {
+		return nil, &ClientProcessingError{Msg: "dataset entity has no name"}
+	}
+
+	dataset.PublicNamespaces, err = datasetPublicNamespaces(datasetEntity)
+	if err != nil {
+		return nil, &ClientProcessingError{Msg: "unable to read public namespaces", Err: err}
+	}
 
 	return dataset, nil
 }
@@ -128,6 +154,78 @@ func (c *Client) UpdateDatasetEntity(dataset string, datasetEntity *egdm.Entity)
 	return err
 }
 
+// GetDatasetEntityWithVersion gets a dataset entity by name together with its version, to update it with
+// UpdateDatasetEntityIfMatch without overwriting a concurrent update.
+// returns the entity and the version, which is the ETag sent by the server and empty if the server did not send one.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the dataset name is empty.
+// returns a RequestError if the request fails.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) GetDatasetEntityWithVersion(name string) (*egdm.Entity, string, error) {
+	if name == "" {
+		return nil, "", &ParameterError{Msg: "dataset name is required"}
+	}
+
+	err := c.checkToken()
+	if err != nil {
+		return nil, "", &AuthenticationError{Msg: "invalid token or unable to authenticate", Err: err}
+	}
+
+	client := c.makeHttpClient()
+	data, header, err := client.makeRequestWithHeader(httpGet, "/datasets/"+name, nil, nil, nil)
+	if err != nil {
+		return nil, "", &RequestError{Msg: "unable to get dataset entity", Err: err}
+	}
+
+	datasetEntity := &egdm.Entity{}
+	if err := json.Unmarshal(data, datasetEntity); err != nil {
+		return nil, "", &ClientProcessingError{Msg: "unable to unmarshall dataset entity", Err: err}
+	}
+
+	return datasetEntity, header.Get("ETag"), nil
+}
+
+// UpdateDatasetEntityIfMatch updates the dataset entity for a named dataset only if it is still at the version
+// returned by GetDatasetEntityWithVersion, by sending the version in the If-Match header.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the dataset name or version is empty or the dataset entity is nil.
+// returns a ConflictError if the server rejects the request with status 409 or 412 as the entity has changed.
+// returns a RequestError if the request fails.
+func (c *Client) UpdateDatasetEntityIfMatch(dataset string, version string, datasetEntity *egdm.Entity) error {
+	if dataset == "" {
+		return &ParameterError{Msg: "dataset name is required"}
+	}
+
+	if version == "" {
+		return &ParameterError{Msg: "version is required"}
+	}
+
+	if datasetEntity == nil {
+		return &ParameterError{Msg: "dataset entity cannot be nil"}
+	}
+
+	data, err := json.Marshal(datasetEntity)
+	if err != nil {
+		return &ParameterError{Msg: "unable to serialise dataset entity", Err: err}
+	}
+
+	err = c.checkToken()
+	if err != nil {
+		return &AuthenticationError{Msg: "invalid token or unable to authenticate", Err: err}
+	}
+
+	client := c.makeHttpClient()
+	_, err = client.makeRequest(httpPut, "/datasets/"+dataset, data, map[string]string{"If-Match": version}, nil)
+	if err != nil {
+		if isConflict(err) {
+			return &ConflictError{Msg: "dataset entity " + dataset + " has changed since version " + version, Err: err}
+		}
+		return &RequestError{Msg: "unable to update dataset entity", Err: err}
+	}
+
+	return nil
+}
+
 // AddDataset creates a dataset if it does not exist.
 // returns an AuthenticationError if the client is unable to authenticate.
 // returns a ParameterError if the dataset name is empty.
@@ -169,6 +267,152 @@ func (c *Client) AddDataset(name string, namespaces []string) error {
 	return nil
 }
 
+// DatasetSpec describes a dataset to create with AddDatasets.
+// Name is the name of the dataset and PublicNamespaces are its optional public namespaces.
+type DatasetSpec struct {
+	Name             string
+	PublicNamespaces []string
+}
+
+// AddDatasets creates each dataset that does not exist, continuing after a dataset fails to be created.
+// A dataset that already exists counts as created, so the same specs can be applied repeatedly.
+// returns a slice with the error of each spec in the same order as specs, nil where the dataset was created.
+// returns a BulkError if any dataset failed, the per dataset errors are the errors of AddDataset.
+func (c *Client) AddDatasets(specs []DatasetSpec) ([]error, error) {
+	errs := make([]error, len(specs))
+	for i, spec := range specs {
+		err := c.AddDataset(spec.Name, spec.PublicNamespaces)
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) && statusErr.statusCode == http.StatusConflict {
+			err = nil
+		}
+		errs[i] = err
+	}
+	return errs, bulkError("unable to add datasets", errs)
+}
+
+// GetDatasetNamespaces gets the public namespaces of a named dataset.
+// These are the namespaces exposed when the dataset is consumed externally.
+// returns an empty slice if the dataset has no public namespaces configured.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the dataset name is empty.
+// returns a RequestError if the request fails.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) GetDatasetNamespaces(name string) ([]string, error) {
+	datasetEntity, err := c.GetDatasetEntity(name)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces, err := datasetPublicNamespaces(datasetEntity)
+	if err != nil {
+		return nil, &ClientProcessingError{Msg: "unable to read public namespaces", Err: err}
+	}
+	return namespaces, nil
+}
+
+// datasetPublicNamespaces returns the public namespaces of a dataset entity, or an empty slice if none are set.
+func datasetPublicNamespaces(datasetEntity *egdm.Entity) ([]string, error) {
+	namespaces := make([]string, 0)
+	key := publicNamespacesProperty(datasetEntity)
+	if key == "" {
+		return namespaces, nil
+	}
+
+	switch values := datasetEntity.Properties[key].(type) {
+	case nil:
+	case string:
+		namespaces = append(namespaces, values)
+	case []string:
+		namespaces = append(namespaces, values...)
+	case []any:
+		for _, v := range values {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("unexpected namespace value %v", v)
+			}
+			namespaces = append(namespaces, s)
+		}
+	default:
+		return nil, fmt.Errorf("unexpected namespaces value %v", values)
+	}
+
+	return namespaces, nil
+}
+
+// SetDatasetNamespaces replaces the public namespaces of a named dataset, it is the same as
+// SetDatasetPublicNamespaces. A nil or empty namespaces slice clears the public namespaces.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the dataset name is empty.
+// returns a RequestError if the dataset does not exist or the request fails.
+func (c *Client) SetDatasetNamespaces(name string, namespaces []string) error {
+	return c.SetDatasetPublicNamespaces(name, namespaces)
+}
+
+// SetDatasetPublicNamespaces replaces the public namespaces of a named dataset by sending the dataset configuration,
+// the same configuration AddDataset sends when it creates a dataset, so that the server applies them as it does
+// for a new dataset. The namespaces are replaced, not merged with the existing namespaces, and a nil or empty
+// namespaces slice clears them. The dataset must exist, it is not created. For a proxy dataset use
+// AddProxyDataset, which also sends the proxy configuration.
+// The existence check and the update are separate requests and not atomic: a dataset deleted between them is
+// created again with the namespaces.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the dataset name is empty.
+// returns a RequestError if the dataset does not exist or the request fails.
+func (c *Client) SetDatasetPublicNamespaces(name string, namespaces []string) error {
+	if name == "" {
+		return &ParameterError{Msg: "dataset name is required"}
+	}
+
+	if namespaces == nil {
+		namespaces = make([]string, 0)
+	}
+
+	conf := &createDatasetConfig{PublicNamespaces: namespaces}
+	b, err := json.Marshal(conf)
+	if err != nil {
+		return &ParameterError{Msg: "unable to serialise create dataset config", Err: err}
+	}
+
+	// the configuration is posted to the endpoint that creates datasets, check first so that a wrong name is
+	// not created as a new dataset
+	_, err = c.GetDatasetEntity(name)
+	if err != nil {
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) && statusErr.statusCode == http.StatusNotFound {
+			return &RequestError{Msg: "dataset " + name + " not found", Err: err}
+		}
+		return err
+	}
+
+	client := c.makeHttpClient()
+	_, err = client.makeRequest(httpPost, "/datasets/"+name, b, nil, nil)
+	if err != nil {
+		return &RequestError{Msg: "unable to update dataset public namespaces", Err: err}
+	}
+
+	return nil
+}
+
+// publicNamespacesProperty returns the property key holding the public namespaces of a dataset entity,
+// or an empty string if it is not set. The key is matched on its local name as the prefix is assigned by the server.
+func publicNamespacesProperty(datasetEntity *egdm.Entity) string {
+	for key := range datasetEntity.Properties {
+		if localName(key) == "publicNamespaces" {
+			return key
+		}
+	}
+	return ""
+}
+
+// localName returns the part of a property key after its prefix or namespace.
+func localName(key string) string {
+	if i := strings.LastIndexAny(key, ":/#"); i >= 0 {
+		return key[i+1:]
+	}
+	return key
+}
+
 // AddProxyDataset creates a proxy dataset if it does not exist, or updates the namespaces, remoteDatasetURL and
 // authProviderName if it does. returns an error if the dataset could not be created or updated.
 // returns an AuthenticationError if the client is unable to authenticate.
@@ -214,6 +458,57 @@ func (c *Client) AddProxyDataset(name string, namespaces []string, remoteDataset
 	return nil
 }
 
+// TestProxyDataset checks that a proxy dataset can read from its remote dataset by requesting a single change
+// through the data hub. Use it after AddProxyDataset to find a misconfigured remote URL or auth provider at setup
+// time rather than when the dataset is first consumed.
+// The dataset entity is read first so that a missing dataset is told apart from a missing remote.
+// returns nil if the remote returned entity graph data.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the dataset name is empty.
+// returns a RequestError describing whether the dataset or its remote was not found, the request was not
+// authorised or the remote could not be reached.
+// returns a ClientProcessingError if the remote does not return entity graph data.
+func (c *Client) TestProxyDataset(name string) error {
+	if name == "" {
+		return &ParameterError{Msg: "dataset name is required"}
+	}
+
+	_, err := c.GetDatasetEntity(name)
+	if err != nil {
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) && statusErr.statusCode == http.StatusNotFound {
+			return &RequestError{Msg: "proxy dataset not found", Err: err}
+		}
+		return err
+	}
+
+	client := c.makeHttpClient()
+	data, err := client.makeStreamingRequest(httpGet, "/datasets/"+name+"/changes", nil, nil, ChangesOptions{Take: 1}.queryParams())
+	if err != nil {
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) {
+			switch {
+			case statusErr.statusCode == http.StatusNotFound:
+				return &RequestError{Msg: "proxy dataset remote not found, check the remote URL", Err: err}
+			case statusErr.statusCode == http.StatusUnauthorized || statusErr.statusCode == http.StatusForbidden:
+				return &RequestError{Msg: "proxy dataset request not authorised, check the auth provider", Err: err}
+			case statusErr.statusCode >= http.StatusInternalServerError:
+				return &RequestError{Msg: "proxy dataset remote could not be reached or failed, check the remote URL and auth provider", Err: err}
+			}
+		}
+		return &RequestError{Msg: "unable to read from proxy dataset", Err: err}
+	}
+	defer data.Close()
+
+	parser := egdm.NewEntityParser(egdm.NewNamespaceContext()).WithLenientNamespaceChecks()
+	err = parser.Parse(data, func(entity *egdm.Entity) error { return nil }, nil)
+	if err != nil {
+		return &ClientProcessingError{Msg: "proxy dataset remote did not return entity graph data", Err: err}
+	}
+
+	return nil
+}
+
 // DeleteDataset deletes a named dataset.
 // returns an AuthenticationError if the client is unable to authenticate.
 // returns a ParameterError if the dataset name is empty.
@@ -239,158 +534,456 @@ func (c *Client) DeleteDataset(dataset string) error {
 	return nil
 }
 
-// GetChanges gets changes for a dataset.
-// returns an EntityCollection for the named dataset.
-// since parameter is an optional token to get changes since.
-// take parameter is an optional limit on the number of changes to return.
-// latestOnly parameter is an optional flag to only return the latest version of each entity.
-// reverse parameter is an optional flag to reverse the order of the changes.
-// expandURIs parameter is an optional flag to expand Entity URIs in the response.
+// TruncateDataset deletes all entities of a named dataset while keeping the dataset, its configuration and access
+// control. Unlike deleting and recreating the dataset, jobs and consumers keep working with the same dataset.
 // returns an AuthenticationError if the client is unable to authenticate.
 // returns a ParameterError if the dataset name is empty.
 // returns a RequestError if the request fails.
-// returns a ClientProcessingError if the response cannot be processed.
-func (c *Client) GetChanges(dataset string, since string, take int, latestOnly bool, reverse bool, expandURIs bool) (*egdm.EntityCollection, error) {
-	if dataset == "" {
-		return nil, &ParameterError{Msg: "dataset name is required"}
+func (c *Client) TruncateDataset(name string) error {
+	if name == "" {
+		return &ParameterError{Msg: "dataset name is required"}
 	}
 
 	err := c.checkToken()
 	if err != nil {
-		return nil, &AuthenticationError{Msg: "unable to authenticate", Err: err}
+		return &AuthenticationError{Msg: "unable to authenticate", Err: err}
 	}
 
-	params := map[string]string{}
-	if since != "" {
-		params["since"] = since
+	client := c.makeHttpClient()
+	_, err = client.makeRequest(httpDelete, "/datasets/"+name+"/entities", nil, nil, nil)
+	if err != nil {
+		return &RequestError{Msg: "unable to truncate dataset", Err: err}
 	}
 
-	if take > 0 {
-		params["limit"] = strconv.Itoa(take)
-	}
+	return nil
+}
 
-	if latestOnly {
-		params["latestOnly"] = "true"
+// DatasetStats are the statistics the data hub maintains for a dataset.
+// Entities is the number of entities, counting the latest version of each.
+// Deleted is the number of those entities whose latest version is a delete.
+// Changes is the number of change versions stored, including superseded versions and deletes.
+// LastModified is the time of the most recent change, the zero time if the dataset has no changes.
+type DatasetStats struct {
+	Name         string    `json:"name"`
+	Entities     int64     `json:"entities"`
+	Deleted      int64     `json:"deleted"`
+	Changes      int64     `json:"changes"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// GetDatasetStats gets the statistics of a named dataset. The statistics are maintained by the server, so
+// reading them is cheap compared to counting the changes with GetDatasetChangesCount.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the dataset name is empty.
+// returns a RequestError if the request fails.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) GetDatasetStats(name string) (*DatasetStats, error) {
+	if name == "" {
+		return nil, &ParameterError{Msg: "dataset name is required"}
 	}
 
-	if reverse {
-		params["reverse"] = "true"
+	err := c.checkToken()
+	if err != nil {
+		return nil, &AuthenticationError{Msg: "invalid token or unable to authenticate", Err: err}
 	}
 
 	client := c.makeHttpClient()
-	data, err := client.makeStreamingRequest(httpGet, "/datasets/"+dataset+"/changes", nil, nil, params)
+	data, err := client.makeRequest(httpGet, "/datasets/"+name+"/stats", nil, nil, nil)
 	if err != nil {
-		return nil, &RequestError{Msg: "unable to get changes", Err: err}
+		return nil, &RequestError{Msg: "unable to get dataset stats", Err: err}
 	}
-	defer data.Close()
-
-	nsManager := egdm.NewNamespaceContext()
-	parser := egdm.NewEntityParser(nsManager)
-	parser.WithLenientNamespaceChecks()
 
-	if expandURIs {
-		parser = parser.WithExpandURIs()
-	}
-	entityCollection, err := parser.LoadEntityCollection(data)
+	stats := &DatasetStats{}
+	err = json.Unmarshal(data, stats)
 	if err != nil {
-		return nil, &ClientProcessingError{Msg: "unable to parse changes", Err: err}
+		return nil, &ClientProcessingError{Msg: "unable to unmarshal dataset stats", Err: err}
+	}
+	if stats.Name == "" {
+		stats.Name = name
 	}
 
-	return entityCollection, nil
+	return stats, nil
 }
 
-// GetChangesStream gets entities for a dataset as a stream from the since position defined.
-// returns an EntityIterator over the changes for the named dataset.
+// GetChanges gets changes for a dataset.
+// returns an EntityCollection for the named dataset. Deleted entities are included with IsDeleted set,
+// a replica must apply them as deletes.
 // since parameter is an optional token to get changes since.
-// take parameter is an optional limit on the number of changes to return in each batch.
-// reverse parameter is an optional flag to reverse the order of the changes.
+// take parameter is an optional limit on the number of changes to return, 0 or NoLimit sends no limit and the server default applies.
 // latestOnly parameter is an optional flag to only return the latest version of each entity.
+// reverse parameter is an optional flag to reverse the order of the changes.
 // expandURIs parameter is an optional flag to expand Entity URIs in the response.
 // returns an AuthenticationError if the client is unable to authenticate.
 // returns a ParameterError if the dataset name is empty.
 // returns a RequestError if the request fails.
 // returns a ClientProcessingError if the response cannot be processed.
-func (c *Client) GetChangesStream(dataset string, since string, latestOnly bool, take int, reverse bool, expandURIs bool) (EntityIterator, error) {
-	err := c.checkToken()
-	if err != nil {
-		return nil, &AuthenticationError{Msg: "unable to authenticate", Err: err}
+func (c *Client) GetChanges(dataset string, since string, take int, latestOnly bool, reverse bool, expandURIs bool) (*egdm.EntityCollection, error) {
+	if dataset == "" {
+		return nil, &ParameterError{Msg: "dataset name is required"}
 	}
 
-	stream, err := c.newChangesStream(dataset, since, latestOnly, take, reverse, expandURIs)
-	return stream, err
-}
-
-// GetEntities gets entities for a dataset.
-// returns an EntityCollection for the named dataset.
-// from parameter is an optional token to get changes since.
-// take parameter is an optional limit on the number of changes to return.
-// reverse parameter is an optional flag to reverse the order of the changes.
-// expandURIs parameter is an optional flag to expand Entity URIs in the response.
-// returns an AuthenticationError if the client is unable to authenticate.
-// returns a ParameterError if the dataset name is empty.
-// returns a RequestError if the request fails.
-// returns a ClientProcessingError if the response cannot be processed.
-func (c *Client) GetEntities(dataset string, from string, take int, reverse bool, expandURIs bool) (*egdm.EntityCollection, error) {
 	err := c.checkToken()
 	if err != nil {
 		return nil, &AuthenticationError{Msg: "unable to authenticate", Err: err}
 	}
 
-	params := map[string]string{}
-	if from != "" {
-		params["from"] = from
-	}
-
-	if take > 0 {
-		params["limit"] = strconv.Itoa(take)
-	}
-
-	if reverse {
-		params["reverse"] = "true"
-	}
+	params := ChangesOptions{Since: since, Take: take, LatestOnly: latestOnly, Reverse: reverse}.queryParams()
 
 	client := c.makeHttpClient()
-	data, err := client.makeStreamingRequest(httpGet, "/datasets/"+dataset+"/entities", nil, nil, params)
+	data, err := client.makeStreamingRequest(httpGet, "/datasets/"+dataset+"/changes", nil, nil, params)
 	if err != nil {
-		return nil, &RequestError{Msg: "unable to get entities", Err: err}
+		return nil, &RequestError{Msg: "unable to get changes", Err: err}
 	}
 	defer data.Close()
 
 	nsManager := egdm.NewNamespaceContext()
 	parser := egdm.NewEntityParser(nsManager)
 	parser.WithLenientNamespaceChecks()
+
 	if expandURIs {
 		parser = parser.WithExpandURIs()
 	}
 	entityCollection, err := parser.LoadEntityCollection(data)
 	if err != nil {
-		return nil, &ClientProcessingError{Msg: "unable to parse entities", Err: err}
+		return nil, &ClientProcessingError{Msg: "unable to parse changes", Err: err}
 	}
 
 	return entityCollection, nil
 }
 
-// GetEntitiesStream gets entities for a dataset as a stream from the start position defined.
-// returns an EntityIterator over the entities in the named dataset.
-// from parameter is an optional token to get changes since.
-// take parameter is an optional limit on the number of changes to return.
-// reverse parameter is an optional flag to reverse the order of the changes.
-// expandURIs parameter is an optional flag to expand Entity URIs in the response.
+// NoLimit is the take to pass to the functions that read changes or entities to send no limit to the server,
+// the server then applies its default. A take of 0 does the same, no limit parameter is sent for any take below 1.
+const NoLimit = -1
+
+// ChangesOptions are the options for reading the changes of a dataset.
+// Since is an optional token to get changes since.
+// Take is an optional limit on the number of changes to return, 0 or NoLimit sends no limit and the server default applies.
+// LatestOnly only returns the latest version of each entity.
+// Reverse returns the changes with the most recent first.
+type ChangesOptions struct {
+	Since      string
+	Take       int
+	LatestOnly bool
+	Reverse    bool
+}
+
+func (o ChangesOptions) queryParams() map[string]string {
+	params := map[string]string{}
+	if o.Since != "" {
+		params["since"] = o.Since
+	}
+
+	if o.Take > 0 {
+		params["limit"] = strconv.Itoa(o.Take)
+	}
+
+	if o.LatestOnly {
+		params["latestOnly"] = "true"
+	}
+
+	if o.Reverse {
+		params["reverse"] = "true"
+	}
+	return params
+}
+
+// GetChangesReader gets the changes for a dataset as the raw entity graph JSON returned by the server.
+// The body is streamed and not parsed, which is useful to copy changes to a file or another process.
+// The caller must Close the returned reader.
 // returns an AuthenticationError if the client is unable to authenticate.
 // returns a ParameterError if the dataset name is empty.
 // returns a RequestError if the request fails.
-// returns a ClientProcessingError if the response cannot be processed.
-func (c *Client) GetEntitiesStream(dataset string, from string, take int, reverse bool, expandURIs bool) (EntityIterator, error) {
+func (c *Client) GetChangesReader(dataset string, opts ChangesOptions) (io.ReadCloser, error) {
+	if dataset == "" {
+		return nil, &ParameterError{Msg: "dataset name is required"}
+	}
+
 	err := c.checkToken()
 	if err != nil {
 		return nil, &AuthenticationError{Msg: "unable to authenticate", Err: err}
 	}
 
-	stream, err := c.newEntitiesStream(dataset, from, take, reverse, expandURIs)
-	return stream, err
-}
-
-type EntitiesStream struct {
+	client := c.makeHttpClient()
+	data, err := client.makeStreamingRequest(httpGet, "/datasets/"+dataset+"/changes", nil, nil, opts.queryParams())
+	if err != nil {
+		return nil, &RequestError{Msg: "unable to get changes", Err: err}
+	}
+
+	return data, nil
+}
+
+// GetDatasetChangesCount counts the changes in a dataset after the since token, for example to see how far
+// a consumer is behind. The changes are read page by page and counted without keeping them in memory.
+// since parameter is an optional token, the empty string counts all changes.
+// returns the number of changes and the head token of the dataset, which is since if there are no changes.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the dataset name is empty.
+// returns a RequestError if the request fails.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) GetDatasetChangesCount(dataset string, since string) (int, string, error) {
+	if dataset == "" {
+		return 0, "", &ParameterError{Msg: "dataset name is required"}
+	}
+
+	count := 0
+	token := since
+	for {
+		reader, err := c.GetChangesReader(dataset, ChangesOptions{Since: token})
+		if err != nil {
+			return 0, "", err
+		}
+
+		pageCount := 0
+		nextToken := token
+		parser := egdm.NewEntityParser(egdm.NewNamespaceContext()).WithLenientNamespaceChecks()
+		err = parser.Parse(reader, func(entity *egdm.Entity) error {
+			pageCount++
+			return nil
+		}, func(continuation *egdm.Continuation) {
+			nextToken = continuation.Token
+		})
+		_ = reader.Close()
+		if err != nil {
+			return 0, "", &ClientProcessingError{Msg: "unable to parse changes", Err: err}
+		}
+
+		count += pageCount
+		if pageCount == 0 || nextToken == token {
+			return count, nextToken, nil
+		}
+		token = nextToken
+	}
+}
+
+// GetChangesStream gets entities for a dataset as a stream from the since position defined.
+// returns an EntityIterator over the changes for the named dataset. No request is made until the first call
+// to Next or Context, errors fetching a batch are returned from Next. Deleted entities are returned with IsDeleted set.
+// since parameter is an optional token to get changes since.
+// take parameter is an optional limit on the number of changes to return in each batch, 0 or NoLimit sends no limit and the server default applies.
+// reverse parameter is an optional flag to reverse the order of the changes.
+// latestOnly parameter is an optional flag to only return the latest version of each entity.
+// expandURIs parameter is an optional flag to expand Entity URIs in the response.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the dataset name is empty.
+// returns a RequestError if the request fails.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) GetChangesStream(dataset string, since string, latestOnly bool, take int, reverse bool, expandURIs bool) (EntityIterator, error) {
+	if dataset == "" {
+		return nil, &ParameterError{Msg: "dataset name is required"}
+	}
+
+	err := c.checkToken()
+	if err != nil {
+		return nil, &AuthenticationError{Msg: "unable to authenticate", Err: err}
+	}
+
+	stream, err := c.newChangesStream(dataset, since, latestOnly, take, reverse, expandURIs)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// GetChangesStreamFollow gets changes for a dataset as a stream that keeps following the dataset.
+// returns an EntityIterator over the changes for the named dataset. When no new changes are available
+// Next blocks and polls the server again after pollInterval, reusing the last continuation token.
+// Next returns the context error once ctx is cancelled.
+// since parameter is an optional token to get changes since.
+// latestOnly parameter is an optional flag to only return the latest version of each entity.
+// take parameter is an optional limit on the number of changes to return in each batch, 0 or NoLimit sends no limit and the server default applies.
+// expandURIs parameter is an optional flag to expand Entity URIs in the response.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the context is nil, the dataset name is empty or the poll interval is not positive.
+// returns a RequestError if the request fails.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) GetChangesStreamFollow(ctx context.Context, dataset string, since string, latestOnly bool, take int, expandURIs bool, pollInterval time.Duration) (EntityIterator, error) {
+	if ctx == nil {
+		return nil, &ParameterError{Msg: "context cannot be nil"}
+	}
+
+	if dataset == "" {
+		return nil, &ParameterError{Msg: "dataset name is required"}
+	}
+
+	if pollInterval <= 0 {
+		return nil, &ParameterError{Msg: "poll interval must be greater than zero"}
+	}
+
+	err := c.checkToken()
+	if err != nil {
+		return nil, &AuthenticationError{Msg: "unable to authenticate", Err: err}
+	}
+
+	stream, err := c.newChangesStream(dataset, since, latestOnly, take, false, expandURIs)
+	if err != nil {
+		return nil, err
+	}
+
+	stream.follow = true
+	stream.ctx = ctx
+	stream.pollInterval = pollInterval
+	return stream, nil
+}
+
+// GetEntities gets entities for a dataset.
+// returns an EntityCollection for the named dataset.
+// from parameter is an optional token to get changes since.
+// take parameter is an optional limit on the number of changes to return, 0 or NoLimit sends no limit and the server default applies.
+// reverse parameter is an optional flag to reverse the order of the changes.
+// expandURIs parameter is an optional flag to expand Entity URIs in the response.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the dataset name is empty.
+// returns a RequestError if the request fails.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) GetEntities(dataset string, from string, take int, reverse bool, expandURIs bool) (*egdm.EntityCollection, error) {
+	err := c.checkToken()
+	if err != nil {
+		return nil, &AuthenticationError{Msg: "unable to authenticate", Err: err}
+	}
+
+	params := EntitiesOptions{From: from, Take: take, Reverse: reverse}.queryParams()
+
+	client := c.makeHttpClient()
+	data, err := client.makeStreamingRequest(httpGet, "/datasets/"+dataset+"/entities", nil, nil, params)
+	if err != nil {
+		return nil, &RequestError{Msg: "unable to get entities", Err: err}
+	}
+	defer data.Close()
+
+	nsManager := egdm.NewNamespaceContext()
+	parser := egdm.NewEntityParser(nsManager)
+	parser.WithLenientNamespaceChecks()
+	if expandURIs {
+		parser = parser.WithExpandURIs()
+	}
+	entityCollection, err := parser.LoadEntityCollection(data)
+	if err != nil {
+		return nil, &ClientProcessingError{Msg: "unable to parse entities", Err: err}
+	}
+
+	return entityCollection, nil
+}
+
+// EntitiesOptions are the options for reading the entities of a dataset.
+// From is an optional token to get entities from.
+// Take is an optional limit on the number of entities to return, 0 or NoLimit sends no limit and the server default applies.
+// Reverse returns the entities in reverse order.
+type EntitiesOptions struct {
+	From    string
+	Take    int
+	Reverse bool
+}
+
+func (o EntitiesOptions) queryParams() map[string]string {
+	params := map[string]string{}
+	if o.From != "" {
+		params["from"] = o.From
+	}
+
+	if o.Take > 0 {
+		params["limit"] = strconv.Itoa(o.Take)
+	}
+
+	if o.Reverse {
+		params["reverse"] = "true"
+	}
+	return params
+}
+
+// GetEntitiesReader gets the entities for a dataset as the raw entity graph JSON returned by the server.
+// The body is streamed and not parsed, which is useful to copy entities to a file or another process.
+// The caller must Close the returned reader.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the dataset name is empty.
+// returns a RequestError if the request fails.
+func (c *Client) GetEntitiesReader(dataset string, opts EntitiesOptions) (io.ReadCloser, error) {
+	if dataset == "" {
+		return nil, &ParameterError{Msg: "dataset name is required"}
+	}
+
+	err := c.checkToken()
+	if err != nil {
+		return nil, &AuthenticationError{Msg: "unable to authenticate", Err: err}
+	}
+
+	client := c.makeHttpClient()
+	data, err := client.makeStreamingRequest(httpGet, "/datasets/"+dataset+"/entities", nil, nil, opts.queryParams())
+	if err != nil {
+		return nil, &RequestError{Msg: "unable to get entities", Err: err}
+	}
+
+	return data, nil
+}
+
+// GetAllEntities gets all entities for a dataset.
+// returns an EntityCollection containing every entity in the named dataset. Continuation tokens are followed
+// until the server returns an empty page.
+// All entities are held in memory, for large datasets use GetEntitiesStream instead.
+// reverse parameter is an optional flag to reverse the order of the entities.
+// expandURIs parameter is an optional flag to expand Entity URIs in the response.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the dataset name is empty.
+// returns a RequestError if the request fails.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) GetAllEntities(dataset string, reverse bool, expandURIs bool) (*egdm.EntityCollection, error) {
+	if dataset == "" {
+		return nil, &ParameterError{Msg: "dataset name is required"}
+	}
+
+	result, err := c.GetEntities(dataset, "", 0, reverse, expandURIs)
+	if err != nil {
+		return nil, err
+	}
+
+	page := result
+	for len(page.Entities) > 0 && page.Continuation != nil {
+		token := page.Continuation.Token
+		page, err = c.GetEntities(dataset, token, 0, reverse, expandURIs)
+		if err != nil {
+			return nil, err
+		}
+
+		for prefix, expansion := range page.NamespaceManager.GetNamespaceMappings() {
+			result.NamespaceManager.StorePrefixExpansionMapping(prefix, expansion)
+		}
+		result.Entities = append(result.Entities, page.Entities...)
+		result.Continuation = page.Continuation
+
+		// guard against a server that keeps handing back the same token
+		if page.Continuation != nil && page.Continuation.Token == token {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// GetEntitiesStream gets entities for a dataset as a stream from the start position defined.
+// returns an EntityIterator over the entities in the named dataset. No request is made until the first call
+// to Next or Context, errors fetching a batch are returned from Next.
+// from parameter is an optional token to get changes since.
+// take parameter is an optional limit on the number of changes to return, 0 or NoLimit sends no limit and the server default applies.
+// reverse parameter is an optional flag to reverse the order of the changes.
+// expandURIs parameter is an optional flag to expand Entity URIs in the response.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the dataset name is empty.
+// returns a RequestError if the request fails.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) GetEntitiesStream(dataset string, from string, take int, reverse bool, expandURIs bool) (EntityIterator, error) {
+	if dataset == "" {
+		return nil, &ParameterError{Msg: "dataset name is required"}
+	}
+
+	err := c.checkToken()
+	if err != nil {
+		return nil, &AuthenticationError{Msg: "unable to authenticate", Err: err}
+	}
+
+	stream, err := c.newEntitiesStream(dataset, from, take, reverse, expandURIs)
+	return stream, err
+}
+
+type EntitiesStream struct {
 	client            *Client
 	currentCollection *egdm.EntityCollection
 	startFrom         string
@@ -399,10 +992,18 @@ type EntitiesStream struct {
 	expandURIs        bool
 	dataset           string
 	currentPos        int
+	firstBatch        func() (*egdm.EntityCollection, error)
 	nextBatch         func() (*egdm.EntityCollection, error)
+	follow            bool
+	ctx               context.Context
+	pollInterval      time.Duration
+	closed            bool
+	done              bool
+	retries           int
+	retryDelay        time.Duration
 }
 
-func (c *Client) newChangesStream(dataset string, since string, latestOnly bool, take int, reverse bool, expandURIs bool) (EntityIterator, error) {
+func (c *Client) newChangesStream(dataset string, since string, latestOnly bool, take int, reverse bool, expandURIs bool) (*EntitiesStream, error) {
 	es := &EntitiesStream{
 		client:     c,
 		startFrom:  since,
@@ -410,15 +1011,13 @@ func (c *Client) newChangesStream(dataset string, since string, latestOnly bool,
 		reverse:    reverse,
 		expandURIs: expandURIs,
 		dataset:    dataset,
+		retries:    c.streamRetries,
+		retryDelay: c.streamRetryDelay,
 	}
 
-	// load initial collection so that context is there
-	var err error
-	es.currentCollection, err = es.client.GetChanges(es.dataset, es.startFrom, es.take, latestOnly, es.reverse, es.expandURIs)
-	if err != nil {
-		return nil, err
+	es.firstBatch = func() (*egdm.EntityCollection, error) {
+		return es.client.GetChanges(es.dataset, es.startFrom, es.take, latestOnly, es.reverse, es.expandURIs)
 	}
-
 	es.nextBatch = func() (*egdm.EntityCollection, error) {
 		return es.client.GetChanges(es.dataset, es.currentCollection.Continuation.Token, es.take, latestOnly, es.reverse, es.expandURIs)
 	}
@@ -434,15 +1033,13 @@ func (c *Client) newEntitiesStream(dataset string, from string, take int, revers
 		reverse:    reverse,
 		expandURIs: expandURIs,
 		dataset:    dataset,
+		retries:    c.streamRetries,
+		retryDelay: c.streamRetryDelay,
 	}
 
-	// load initial collection so that context is there
-	var err error
-	es.currentCollection, err = es.client.GetEntities(es.dataset, es.startFrom, es.take, es.reverse, es.expandURIs)
-	if err != nil {
-		return nil, err
+	es.firstBatch = func() (*egdm.EntityCollection, error) {
+		return es.client.GetEntities(es.dataset, es.startFrom, es.take, es.reverse, es.expandURIs)
 	}
-
 	es.nextBatch = func() (*egdm.EntityCollection, error) {
 		return es.client.GetEntities(es.dataset, es.currentCollection.Continuation.Token, es.take, es.reverse, es.expandURIs)
 	}
@@ -451,19 +1048,55 @@ func (c *Client) newEntitiesStream(dataset string, from string, take int, revers
 }
 
 func (e *EntitiesStream) Next() (*egdm.Entity, error) {
-	var err error
-	if e.currentPos == len(e.currentCollection.Entities) {
+	if e.closed {
+		return nil, &ClientProcessingError{Msg: "entity stream is closed"}
+	}
+
+	err := e.load()
+	if err != nil {
+		return nil, err
+	}
+
+	for e.currentPos == len(e.currentCollection.Entities) {
+		previous := e.currentCollection.Continuation
+		if previous == nil {
+			return nil, nil
+		}
+
+		if e.done {
+			return nil, nil
+		}
+
 		// query for next page with client
-		e.currentCollection, err = e.nextBatch() // e.client.GetEntities(e.dataset, e.currentCollection.Continuation.Token, e.take, e.reverse, e.expandURIs)
+		batch, err := e.fetch(e.nextBatch)
 		if err != nil {
 			return nil, err
 		}
+
+		tokenAdvanced := batch.Continuation != nil && batch.Continuation.Token != previous.Token
+		if batch.Continuation == nil {
+			// keep the last known position so that the stream can be resumed from it
+			batch.Continuation = previous
+			e.done = !e.follow
+		} else if !tokenAdvanced {
+			// the server repeated the token, anything returned with it has already been seen
+			batch.Entities = nil
+		}
+		e.currentCollection = batch
 		e.currentPos = 0
-	}
 
-	// no more entities
-	if len(e.currentCollection.Entities) == 0 {
-		return nil, nil
+		// an empty page is only the end of the stream if the server did not move the token on
+		if len(batch.Entities) == 0 && !tokenAdvanced {
+			if !e.follow {
+				e.done = true
+				return nil, nil
+			}
+
+			err = e.waitForNextPoll()
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	entity := e.currentCollection.Entities[e.currentPos]
@@ -472,22 +1105,124 @@ func (e *EntitiesStream) Next() (*egdm.Entity, error) {
 	return entity, nil
 }
 
+// load fetches the first batch if it has not been fetched yet.
+func (e *EntitiesStream) load() error {
+	if e.currentCollection != nil {
+		return nil
+	}
+
+	batch, err := e.fetch(e.firstBatch)
+	if err != nil {
+		return err
+	}
+	e.currentCollection = batch
+	e.currentPos = 0
+	return nil
+}
+
+// fetch gets a batch, retrying network failures up to the configured number of retries.
+// Batches are requested from the last successful continuation token, so a retry resumes where the stream was.
+func (e *EntitiesStream) fetch(batch func() (*egdm.EntityCollection, error)) (*egdm.EntityCollection, error) {
+	for attempt := 0; ; attempt++ {
+		collection, err := batch()
+		if err == nil || attempt >= e.retries || !isNetworkError(err) {
+			return collection, err
+		}
+		e.client.log().Debugf("retrying %s batch after network error, attempt %d of %d: %v", e.dataset, attempt+1, e.retries, err)
+
+		if e.ctx != nil {
+			timer := time.NewTimer(e.retryDelay)
+			select {
+			case <-e.ctx.Done():
+				timer.Stop()
+				return nil, e.ctx.Err()
+			case <-timer.C:
+			}
+		} else {
+			time.Sleep(e.retryDelay)
+		}
+	}
+}
+
+// isNetworkError reports whether the error was caused by a failure to reach the server or a dropped connection,
+// as opposed to an error status or a response that cannot be parsed. A response body that ends unexpectedly
+// is treated as a dropped connection. TLS failures, such as a certificate that cannot be verified, are permanent
+// and not network errors. The url.Error that wraps every failed request is a net.Error, so it is not checked.
+func isNetworkError(err error) bool {
+	var certErr *tls.CertificateVerificationError
+	var alertErr tls.AlertError
+	var recordErr tls.RecordHeaderError
+	if errors.As(err, &certErr) || errors.As(err, &alertErr) || errors.As(err, &recordErr) {
+		return false
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	if errors.As(err, &opErr) || errors.As(err, &dnsErr) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// waitForNextPoll blocks for the poll interval or until the stream context is done.
+func (e *EntitiesStream) waitForNextPoll() error {
+	timer := time.NewTimer(e.pollInterval)
+	defer timer.Stop()
+
+	select {
+	case <-e.ctx.Done():
+		return e.ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Context returns the namespace context of the stream, fetching the first batch if needed.
+// returns nil if the first batch cannot be fetched.
 func (e *EntitiesStream) Context() *egdm.Context {
-	if e.currentCollection == nil {
+	if e.closed && e.currentCollection == nil {
+		return nil
+	}
+
+	if e.load() != nil {
 		return nil
 	}
 
 	return e.currentCollection.NamespaceManager.AsContext()
 }
 
+// Token returns the current continuation of the stream. Before the first batch is fetched this is the
+// position the stream was started from, or nil if it starts from the beginning.
 func (e *EntitiesStream) Token() *egdm.Continuation {
 	if e.currentCollection == nil {
-		return nil
+		if e.startFrom == "" {
+			return nil
+		}
+		continuation := egdm.NewContinuation()
+		continuation.Token = e.startFrom
+		return continuation
 	}
 
 	return e.currentCollection.Continuation
 }
 
+// Close closes the stream. The last continuation token remains available from Token so that
+// consumption can be resumed later.
+func (e *EntitiesStream) Close() error {
+	e.closed = true
+	if e.currentCollection != nil {
+		e.currentCollection.Entities = nil
+	}
+	e.currentPos = 0
+	return nil
+}
+
 // GetDatasets gets list of datasets.
 // returns []*Dataset for the named dataset.
 // returns an AuthenticationError if the client is unable to authenticate.
@@ -521,26 +1256,253 @@ func (c *Client) GetDatasets() ([]*Dataset, error) {
 // returns a RequestError if the request fails.
 // returns a ClientProcessingError if the response cannot be processed.
 func (c *Client) StoreEntities(dataset string, entityCollection *egdm.EntityCollection) error {
+	_, err := c.StoreEntitiesWithResult(dataset, entityCollection)
+	return err
+}
+
+// StoreResult is the outcome of storing entities in a dataset
+// EntitiesProcessed is the number of entities accepted by the server
+// Token is the continuation token returned by the server, empty if none was returned
+type StoreResult struct {
+	EntitiesProcessed int    `json:"entitiesProcessed"`
+	Token             string `json:"token"`
+}
+
+// StoreEntitiesWithResult stores the entities in a named dataset and returns the result reported by the server.
+// If the server does not report the number of entities processed it is taken from the collection that was sent.
+// Collections larger than the client batch size are uploaded in batches, see WithBatchSize and WithUploadConcurrency.
+// The result then holds the total processed over all batches and the token returned for the last batch.
+// dataset is the name of the dataset to be updated.
+// entityCollection is the set of entities to store.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the dataset name is empty or entityCollection is nil, or if WithValidation is set
+// and the collection is not valid.
+// returns a RequestError if the request fails.
+// returns a ClientProcessingError if the response cannot be processed.
+// returns a BatchError if the collection was split into batches and one of them fails.
+func (c *Client) StoreEntitiesWithResult(dataset string, entityCollection *egdm.EntityCollection) (*StoreResult, error) {
 	if dataset == "" {
-		return &ParameterError{Msg: "dataset name is required"}
+		return nil, &ParameterError{Msg: "dataset name is required"}
+	}
+
+	if entityCollection == nil {
+		return nil, &ParameterError{Msg: "entity collection cannot be nil"}
+	}
+
+	if c.validateEntities {
+		err := ValidateEntities(entityCollection)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	batches := splitEntityCollection(entityCollection, c.batchSize)
+	if len(batches) == 1 {
+		return c.storeEntities(dataset, entityCollection)
+	}
+
+	if c.uploadConcurrency > 1 {
+		return c.storeEntitiesConcurrently(dataset, batches)
+	}
+
+	result := &StoreResult{}
+	for i, batch := range batches {
+		batchResult, err := c.storeEntities(dataset, batch)
+		if err != nil {
+			return nil, &BatchError{Msg: "unable to store entities", Err: err, Batch: i + 1, Batches: len(batches), EntitiesProcessed: result.EntitiesProcessed}
+		}
+		result.EntitiesProcessed += batchResult.EntitiesProcessed
+		result.Token = batchResult.Token
+	}
+
+	return result, nil
+}
+
+// StoreEntitiesIfMatch stores the entities in a named dataset only if the dataset has not changed since version.
+// Use it for optimistic concurrency between several writers: read the dataset, for example with GetChanges, and
+// pass the continuation token as version. The version is sent in the If-Match header and the server rejects the
+// request if the dataset has changed since. The collection is sent in a single request whatever the batch size,
+// so that the condition applies to all of its entities.
+// dataset is the name of the dataset to be updated.
+// version is the since token the dataset is expected to be at.
+// entityCollection is the set of entities to store.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the dataset name or version is empty or entityCollection is nil, or if WithValidation
+// is set and the collection is not valid.
+// returns a ConflictError if the server rejects the request with status 409 or 412 as the dataset has changed.
+// returns a RequestError if the request fails.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) StoreEntitiesIfMatch(dataset string, version string, entityCollection *egdm.EntityCollection) (*StoreResult, error) {
+	if dataset == "" {
+		return nil, &ParameterError{Msg: "dataset name is required"}
+	}
+
+	if version == "" {
+		return nil, &ParameterError{Msg: "version is required"}
 	}
 
 	if entityCollection == nil {
-		return &ParameterError{Msg: "entity collection cannot be nil"}
+		return nil, &ParameterError{Msg: "entity collection cannot be nil"}
+	}
+
+	if c.validateEntities {
+		err := ValidateEntities(entityCollection)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	err := c.checkToken()
 	if err != nil {
-		return &AuthenticationError{Msg: "unable to authenticate", Err: err}
+		return nil, &AuthenticationError{Msg: "unable to authenticate", Err: err}
 	}
 
-	client := c.makeHttpClient()
-	reader, err := client.makeStreamingWriterRequest(httpPost, "/datasets/"+dataset+"/entities", entityCollection.WriteEntityGraphJSON, nil, nil)
+	result, err := sendEntities(context.Background(), c.makeHttpClient(), dataset, entityCollection, map[string]string{"If-Match": version})
 	if err != nil {
-		return &RequestError{Msg: "unable to store entities", Err: err}
+		if isConflict(err) {
+			return nil, &ConflictError{Msg: "dataset " + dataset + " has changed since version " + version, Err: err}
+		}
+		return nil, err
 	}
 
-	return reader.Close()
+	return result, nil
+}
+
+// storeEntities sends a single entity collection to the dataset
+func (c *Client) storeEntities(dataset string, entityCollection *egdm.EntityCollection) (*StoreResult, error) {
+	err := c.checkToken()
+	if err != nil {
+		return nil, &AuthenticationError{Msg: "unable to authenticate", Err: err}
+	}
+
+	return sendEntities(context.Background(), c.makeHttpClient(), dataset, entityCollection, nil)
+}
+
+// storeEntitiesConcurrently uploads the batches using a pool of uploadConcurrency workers.
+// The first failure stops any batches that have not been started and aborts the uploads in flight.
+func (c *Client) storeEntitiesConcurrently(dataset string, batches []*egdm.EntityCollection) (*StoreResult, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	results := make([]*StoreResult, len(batches))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var tokenLock sync.Mutex
+	var failOnce sync.Once
+	var failure error
+	failedBatch := 0
+
+	for w := 0; w < min(c.uploadConcurrency, len(batches)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+
+				// the token may be refreshed, so only one worker checks it at a time
+				tokenLock.Lock()
+				var client *httpClient
+				err := c.checkToken()
+				if err != nil {
+					err = &AuthenticationError{Msg: "unable to authenticate", Err: err}
+				} else {
+					client = c.makeHttpClient()
+				}
+				tokenLock.Unlock()
+
+				if err == nil {
+					results[i], err = sendEntities(ctx, client, dataset, batches[i], nil)
+				}
+				if err != nil {
+					failOnce.Do(func() {
+						failure = err
+						failedBatch = i
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for i := range batches {
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case jobs <- i:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	result := &StoreResult{}
+	for _, batchResult := range results {
+		if batchResult != nil {
+			result.EntitiesProcessed += batchResult.EntitiesProcessed
+			result.Token = batchResult.Token
+		}
+	}
+
+	if failure != nil {
+		return nil, &BatchError{Msg: "unable to store entities", Err: failure, Batch: failedBatch + 1, Batches: len(batches), EntitiesProcessed: result.EntitiesProcessed}
+	}
+
+	return result, nil
+}
+
+// sendEntities posts a single entity collection to the dataset with the given headers and parses the result
+func sendEntities(ctx context.Context, client *httpClient, dataset string, entityCollection *egdm.EntityCollection, headers map[string]string) (*StoreResult, error) {
+	reader, err := client.makeStreamingWriterRequestContext(ctx, httpPost, "/datasets/"+dataset+"/entities", entityCollection.WriteEntityGraphJSON, headers, nil)
+	if err != nil {
+		return nil, &RequestError{Msg: "unable to store entities", Err: err}
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	response, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, &RequestError{Msg: "unable to read store entities response", Err: err}
+	}
+
+	// only a JSON object carries a result, other responses are ignored as they were before results were parsed
+	result := &StoreResult{EntitiesProcessed: -1}
+	if bytes.HasPrefix(bytes.TrimSpace(response), []byte("{")) {
+		err = json.Unmarshal(response, result)
+		if err != nil {
+			return nil, &ClientProcessingError{Msg: "unable to unmarshal store entities result", Err: err}
+		}
+	}
+
+	if result.EntitiesProcessed < 0 {
+		result.EntitiesProcessed = len(entityCollection.Entities)
+	}
+
+	return result, nil
+}
+
+// splitEntityCollection divides the collection into collections of at most batchSize entities that share
+// the namespace manager. Only the last batch carries the continuation of the collection.
+// returns the collection itself if batchSize is not positive or the collection is within the limit.
+func splitEntityCollection(entityCollection *egdm.EntityCollection, batchSize int) []*egdm.EntityCollection {
+	if batchSize <= 0 || len(entityCollection.Entities) <= batchSize {
+		return []*egdm.EntityCollection{entityCollection}
+	}
+
+	batches := make([]*egdm.EntityCollection, 0, (len(entityCollection.Entities)+batchSize-1)/batchSize)
+	for start := 0; start < len(entityCollection.Entities); start += batchSize {
+		end := min(start+batchSize, len(entityCollection.Entities))
+		batch := *entityCollection
+		batch.Entities = entityCollection.Entities[start:end]
+		if end < len(entityCollection.Entities) {
+			batch.Continuation = nil
+		}
+		batches = append(batches, &batch)
+	}
+
+	return batches
 }
 
 // StoreEntityStream stores the entities in a named dataset.
@@ -548,9 +1510,33 @@ func (c *Client) StoreEntities(dataset string, entityCollection *egdm.EntityColl
 // data is the stream of entities to store.
 // returns an AuthenticationError if the client is unable to authenticate.
 // returns a ParameterError if the dataset name is empty or entityCollection is nil.
-// returns a RequestError if the request fails.
+// returns a RequestError if the request fails or data cannot be read or parsed, the upload is aborted so that
+// the server does not store a truncated stream.
 // returns a ClientProcessingError if the response cannot be processed.
 func (c *Client) StoreEntityStream(dataset string, data io.Reader) error {
+	return c.StoreEntitiesStreamWithProgress(context.Background(), dataset, data, nil)
+}
+
+// streamProgressInterval is the number of entities written between calls to the progress function of
+// StoreEntitiesStreamWithProgress.
+const streamProgressInterval = 1000
+
+// StoreEntitiesStreamWithProgress stores the entities in a named dataset as StoreEntityStream does, bound to ctx
+// and reporting progress.
+// ctx cancels the upload, the request is aborted so that the server does not store a truncated stream.
+// dataset is the name of the dataset to be updated.
+// data is the stream of entities to store.
+// progress is an optional function called with the number of entities written so far every 1000 entities and
+// once with the total when the stream has been written. It is called from the goroutine writing the request.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the context is nil, the dataset name is empty or data is nil.
+// returns a RequestError if the request fails, is cancelled or data cannot be read or parsed.
+// Use errors.Is with context.Canceled to detect a cancelled upload.
+func (c *Client) StoreEntitiesStreamWithProgress(ctx context.Context, dataset string, data io.Reader, progress func(entitiesWritten int)) error {
+	if ctx == nil {
+		return &ParameterError{Msg: "context cannot be nil"}
+	}
+
 	if dataset == "" {
 		return &ParameterError{Msg: "dataset name is required"}
 	}
@@ -566,33 +1552,117 @@ func (c *Client) StoreEntityStream(dataset string, data io.Reader) error {
 
 	writerFunc := func(writer io.Writer) error {
 		// write the empty context as we expand all URIs
-		ctx := egdm.NewContext()
-		contextJson, _ := json.Marshal(ctx)
-		_, err = writer.Write(contextJson)
+		nsContext := egdm.NewContext()
+		contextJson, _ := json.Marshal(nsContext)
+		_, err := writer.Write(append([]byte("["), contextJson...))
 		if err != nil {
 			return errors.New("unable to write context")
 		}
 
 		// create entity parser and read from data stream
-		entityParser := egdm.NewEntityParser(nil).WithExpandURIs().WithLenientNamespaceChecks()
-		err := entityParser.Parse(data,
+		entitiesWritten := 0
+		entityParser := egdm.NewEntityParser(egdm.NewNamespaceContext()).WithExpandURIs().WithLenientNamespaceChecks()
+		err = entityParser.Parse(data,
 			func(entity *egdm.Entity) error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
 				entityJson, _ := json.Marshal(entity)
-				_, err = writer.Write(entityJson)
+				_, err = writer.Write(append([]byte(","), entityJson...))
 				if err != nil {
 					return errors.New("unable to write entity")
 				}
+				entitiesWritten++
+				if progress != nil && entitiesWritten%streamProgressInterval == 0 {
+					progress(entitiesWritten)
+				}
 				return nil
 			},
 			nil)
+		if err != nil {
+			return err
+		}
+
+		_, err = writer.Write([]byte("]"))
+		if err == nil && progress != nil {
+			progress(entitiesWritten)
+		}
 		return err
 	}
 
 	client := c.makeHttpClient()
-	reader, err := client.makeStreamingWriterRequest(httpPost, "/datasets/"+dataset+"/entities", writerFunc, nil, nil)
+	reader, err := client.makeStreamingWriterRequestContext(ctx, httpPost, "/datasets/"+dataset+"/entities", writerFunc, nil, nil)
 	if err != nil {
 		return &RequestError{Msg: "unable to store entities", Err: err}
 	}
 
 	return reader.Close()
 }
+
+// ReplicateDataset copies all changes of a dataset into a dataset of the destination client, which may be
+// connected to a different data hub. The destination dataset must exist.
+// batchSize is the number of changes read and stored per request, if 0 or less the client batch size is used.
+// returns the number of changes copied.
+// returns the errors of ReplicateDatasetSince.
+func (c *Client) ReplicateDataset(srcDataset string, dst *Client, dstDataset string, batchSize int) (int, error) {
+	count, _, err := c.ReplicateDatasetSince(srcDataset, "", dst, dstDataset, batchSize)
+	return count, err
+}
+
+// ReplicateDatasetSince copies the changes of a dataset after the since token into a dataset of the destination
+// client. Changes are read a page at a time and each page is stored before the next is read.
+// since is an optional token to resume from, the empty string copies all changes.
+// batchSize is the number of changes read and stored per request, if 0 or less the client batch size is used.
+// returns the number of changes copied and the token of the last stored page. Pass the token as since to
+// resume replication later, it is also returned on error so that a failed replication can be resumed.
+// returns a ParameterError if a dataset name is empty or the destination client is nil.
+// returns the errors of GetChanges when reading from the source and of StoreEntities when storing to the destination.
+func (c *Client) ReplicateDatasetSince(srcDataset string, since string, dst *Client, dstDataset string, batchSize int) (int, string, error) {
+	if srcDataset == "" {
+		return 0, since, &ParameterError{Msg: "source dataset name is required"}
+	}
+
+	if dst == nil {
+		return 0, since, &ParameterError{Msg: "destination client is required"}
+	}
+
+	if dstDataset == "" {
+		return 0, since, &ParameterError{Msg: "destination dataset name is required"}
+	}
+
+	if batchSize <= 0 {
+		batchSize = c.batchSize
+	}
+
+	count := 0
+	token := since
+	for {
+		page, err := c.GetChanges(srcDataset, token, batchSize, false, false, false)
+		if err != nil {
+			return count, token, err
+		}
+
+		nextToken := token
+		if page.Continuation != nil {
+			nextToken = page.Continuation.Token
+		}
+
+		if len(page.Entities) == 0 {
+			return count, token, nil
+		}
+
+		// the continuation belongs to the source dataset
+		page.Continuation = nil
+		err = dst.StoreEntities(dstDataset, page)
+		if err != nil {
+			return count, token, err
+		}
+
+		count += len(page.Entities)
+		// a page without a continuation or with the same token is the last one, reading again would return it again
+		if nextToken == token {
+			return count, token, nil
+		}
+		token = nextToken
+	}
+}
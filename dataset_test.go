@@ -1,10 +1,26 @@
 package datahub
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	egdm "github.com/mimiro-io/entity-graph-data-model"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func NewAdminUserConfiguredClient() *Client {
@@ -123,6 +139,48 @@ func TestStoreEntities(t *testing.T) {
 	}
 }
 
+func TestGetAllEntities(t *testing.T) {
+	client := NewAdminUserConfiguredClient()
+
+	// make dateset name from test+ a guid
+	datasetName := "test-" + uuid.New().String()
+
+	err := client.AddDataset(datasetName, nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// make entity collection
+	namespaceManager := egdm.NewNamespaceContext()
+	ec := egdm.NewEntityCollection(namespaceManager)
+	for _, uri := range []string{"http://data.example.com/things/entity1", "http://data.example.com/things/entity2"} {
+		prefixedId, err := namespaceManager.AssertPrefixedIdentifierFromURI(uri)
+		if err != nil {
+			t.Error(err)
+		}
+		err = ec.AddEntity(egdm.NewEntity().SetID(prefixedId))
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	// store entities
+	err = client.StoreEntities(datasetName, ec)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// get all entities
+	all, err := client.GetAllEntities(datasetName, false, true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(all.Entities) != 2 {
+		t.Errorf("expected 2 entities, got %d", len(all.Entities))
+	}
+}
+
 func TestGetEntitiesStream(t *testing.T) {
 	client := NewAdminUserConfiguredClient()
 
@@ -378,3 +436,1669 @@ func TestGetChangesUsingTake(t *testing.T) {
 		t.Errorf("expected 0 entities, got %d", len(changes.Entities))
 	}
 }
+
+func TestChangesStreamSkipsEmptyPageWithNewToken(t *testing.T) {
+	// simulate a server catching up, where an empty page still moves the token on
+	pages := map[string]string{
+		"":   `[{"id":"@context","namespaces":{"ns0":"http://data.example.com/things/"}},{"id":"ns0:entity1","refs":{},"props":{}},{"id":"@continuation","token":"t1"}]`,
+		"t1": `[{"id":"@context","namespaces":{"ns0":"http://data.example.com/things/"}},{"id":"@continuation","token":"t2"}]`,
+		"t2": `[{"id":"@context","namespaces":{"ns0":"http://data.example.com/things/"}},{"id":"ns0:entity2","refs":{},"props":{}},{"id":"@continuation","token":"t3"}]`,
+		"t3": `[{"id":"@context","namespaces":{"ns0":"http://data.example.com/things/"}},{"id":"@continuation","token":"t3"}]`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Query().Get("since")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = fmt.Fprint(w, page)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := client.GetChangesStream("people", "", false, 0, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e1, err := stream.Next()
+	if err != nil {
+		t.Error(err)
+	}
+	if e1 == nil || e1.ID != "http://data.example.com/things/entity1" {
+		t.Errorf("expected entity1, got %v", e1)
+	}
+
+	e2, err := stream.Next()
+	if err != nil {
+		t.Error(err)
+	}
+	if e2 == nil || e2.ID != "http://data.example.com/things/entity2" {
+		t.Errorf("expected entity2 after empty page, got %v", e2)
+	}
+
+	e3, err := stream.Next()
+	if err != nil {
+		t.Error(err)
+	}
+	if e3 != nil {
+		t.Errorf("expected entity to be nil, got '%s'", e3.ID)
+	}
+}
+
+func TestChangesStreamFollow(t *testing.T) {
+	// the second entity only appears after the consumer has polled a few times
+	var polls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nsContext := `{"id":"@context","namespaces":{"ns0":"http://data.example.com/things/"}}`
+		switch r.URL.Query().Get("since") {
+		case "":
+			_, _ = fmt.Fprintf(w, `[%s,{"id":"ns0:entity1","refs":{},"props":{}},{"id":"@continuation","token":"t1"}]`, nsContext)
+		case "t1":
+			if polls.Add(1) < 3 {
+				_, _ = fmt.Fprintf(w, `[%s,{"id":"@continuation","token":"t1"}]`, nsContext)
+				return
+			}
+			_, _ = fmt.Fprintf(w, `[%s,{"id":"ns0:entity2","refs":{},"props":{}},{"id":"@continuation","token":"t2"}]`, nsContext)
+		default:
+			_, _ = fmt.Fprintf(w, `[%s,{"id":"@continuation","token":"t2"}]`, nsContext)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := client.GetChangesStreamFollow(ctx, "people", "", false, 0, true, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e1, err := stream.Next()
+	if err != nil {
+		t.Error(err)
+	}
+	if e1 == nil || e1.ID != "http://data.example.com/things/entity1" {
+		t.Errorf("expected entity1, got %v", e1)
+	}
+
+	e2, err := stream.Next()
+	if err != nil {
+		t.Error(err)
+	}
+	if e2 == nil || e2.ID != "http://data.example.com/things/entity2" {
+		t.Errorf("expected entity2 after polling, got %v", e2)
+	}
+
+	if stream.Token().Token != "t2" {
+		t.Errorf("expected token to be 't2', got '%s'", stream.Token().Token)
+	}
+
+	// cancel while waiting for more changes
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err = stream.Next()
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context cancelled error, got %v", err)
+	}
+}
+
+func TestEntitiesStreamClose(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `[{"id":"@context","namespaces":{"ns0":"http://data.example.com/things/"}},{"id":"ns0:entity1","refs":{},"props":{}},{"id":"ns0:entity2","refs":{},"props":{}},{"id":"@continuation","token":"t1"}]`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := client.GetEntitiesStream("people", "", 0, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = stream.Next()
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = stream.Close()
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = stream.Next()
+	if err == nil {
+		t.Error("expected error calling Next on a closed stream")
+	}
+
+	if stream.Token() == nil || stream.Token().Token != "t1" {
+		t.Error("expected token to remain available after close")
+	}
+}
+
+func TestStoreEntitiesWithResult(t *testing.T) {
+	response := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		_, _ = fmt.Fprint(w, response)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ec := egdm.NewEntityCollection(nil)
+	for i := 0; i < 3; i++ {
+		_ = ec.AddEntity(egdm.NewEntity().SetID(fmt.Sprintf("http://data.example.com/things/entity%d", i)))
+	}
+
+	// server reports the result
+	response = `{"entitiesProcessed":2,"token":"t1"}`
+	result, err := client.StoreEntitiesWithResult("people", ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.EntitiesProcessed != 2 || result.Token != "t1" {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	// server returns no result, count is taken from the collection
+	for _, response = range []string{"", "[]", "ok"} {
+		result, err = client.StoreEntitiesWithResult("people", ec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.EntitiesProcessed != 3 || result.Token != "" {
+			t.Errorf("unexpected result %+v for response '%s'", result, response)
+		}
+	}
+}
+
+func TestEntitiesStreamLazyFirstBatch(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_, _ = fmt.Fprint(w, `[{"id":"@context","namespaces":{}}]`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := client.GetEntitiesStream("empty", "", 0, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if requests.Load() != 0 {
+		t.Errorf("expected no requests when constructing the stream, got %d", requests.Load())
+	}
+
+	entity, err := stream.Next()
+	if err != nil {
+		t.Error(err)
+	}
+	if entity != nil {
+		t.Errorf("expected no entities, got %v", entity)
+	}
+
+	if stream.Context() == nil {
+		t.Error("expected context to be available")
+	}
+
+	if requests.Load() != 1 {
+		t.Errorf("expected one request, got %d", requests.Load())
+	}
+}
+
+func TestEntitiesStreamFirstBatchErrorFromNext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := client.GetChangesStream("people", "", false, 0, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = stream.Next()
+	if err == nil {
+		t.Error("expected error from Next when the first batch cannot be fetched")
+	}
+
+	if stream.Context() != nil {
+		t.Error("expected nil context when the first batch cannot be fetched")
+	}
+}
+
+func TestStoreEntitiesInBatches(t *testing.T) {
+	var requests atomic.Int32
+	var failOn atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := requests.Add(1)
+		ec, err := egdm.NewEntityParser(egdm.NewNamespaceContext()).LoadEntityCollection(r.Body)
+		if err != nil || request == failOn.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"entitiesProcessed":%d,"token":"t%d"}`, len(ec.Entities), request)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.WithBatchSize(2)
+
+	ec := egdm.NewEntityCollection(nil)
+	for i := 0; i < 5; i++ {
+		_ = ec.AddEntity(egdm.NewEntity().SetID(fmt.Sprintf("http://data.example.com/things/entity%d", i)))
+	}
+
+	result, err := client.StoreEntitiesWithResult("people", ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() != 3 {
+		t.Errorf("expected 3 batches, got %d", requests.Load())
+	}
+	if result.EntitiesProcessed != 5 || result.Token != "t3" {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	// fail on the last batch
+	requests.Store(0)
+	failOn.Store(3)
+	err = client.StoreEntities("people", ec)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("expected BatchError, got %v", err)
+	}
+	if batchErr.Batch != 3 || batchErr.Batches != 3 || batchErr.EntitiesProcessed != 4 {
+		t.Errorf("unexpected batch error %+v", batchErr)
+	}
+	var requestErr *RequestError
+	if !errors.As(err, &requestErr) {
+		t.Errorf("expected wrapped RequestError, got %v", err)
+	}
+}
+
+func TestEntitiesStreamExactMultipleOfTake(t *testing.T) {
+	const nsContext = `{"id":"@context","namespaces":{"ns0":"http://data.example.com/things/"}}`
+	testCases := []struct {
+		name  string
+		pages map[string]string
+		token string
+	}{
+		{
+			name: "empty last page",
+			pages: map[string]string{
+				"":   `[` + nsContext + `,{"id":"ns0:e1","refs":{},"props":{}},{"id":"ns0:e2","refs":{},"props":{}},{"id":"@continuation","token":"t1"}]`,
+				"t1": `[` + nsContext + `,{"id":"ns0:e3","refs":{},"props":{}},{"id":"ns0:e4","refs":{},"props":{}},{"id":"@continuation","token":"t2"}]`,
+				"t2": `[` + nsContext + `,{"id":"@continuation","token":"t2"}]`,
+			},
+			token: "t2",
+		},
+		{
+			name: "repeated token with entities",
+			pages: map[string]string{
+				"":   `[` + nsContext + `,{"id":"ns0:e1","refs":{},"props":{}},{"id":"ns0:e2","refs":{},"props":{}},{"id":"@continuation","token":"t1"}]`,
+				"t1": `[` + nsContext + `,{"id":"ns0:e3","refs":{},"props":{}},{"id":"ns0:e4","refs":{},"props":{}},{"id":"@continuation","token":"t2"}]`,
+				"t2": `[` + nsContext + `,{"id":"ns0:e3","refs":{},"props":{}},{"id":"ns0:e4","refs":{},"props":{}},{"id":"@continuation","token":"t2"}]`,
+			},
+			token: "t2",
+		},
+		{
+			name: "missing continuation",
+			pages: map[string]string{
+				"":   `[` + nsContext + `,{"id":"ns0:e1","refs":{},"props":{}},{"id":"ns0:e2","refs":{},"props":{}},{"id":"@continuation","token":"t1"}]`,
+				"t1": `[` + nsContext + `,{"id":"ns0:e3","refs":{},"props":{}},{"id":"ns0:e4","refs":{},"props":{}}]`,
+			},
+			token: "t1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1) > 10 {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				page, ok := tc.pages[r.URL.Query().Get("from")]
+				if !ok {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				_, _ = fmt.Fprint(w, page)
+			}))
+			defer server.Close()
+
+			client, err := NewClient(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stream, err := client.GetEntitiesStream("people", "", 2, false, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			count := 0
+			for {
+				entity, err := stream.Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if entity == nil {
+					break
+				}
+				count++
+			}
+
+			if count != 4 {
+				t.Errorf("expected 4 entities, got %d", count)
+			}
+
+			entity, err := stream.Next()
+			if entity != nil || err != nil {
+				t.Errorf("expected stream to stay at the end, got %v, %v", entity, err)
+			}
+
+			if stream.Token() == nil || stream.Token().Token != tc.token {
+				t.Errorf("expected token %s, got %v", tc.token, stream.Token())
+			}
+		})
+	}
+}
+
+func TestStoreEntitiesConcurrently(t *testing.T) {
+	var inFlight, maxInFlight, received atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			seen := maxInFlight.Load()
+			if current <= seen || maxInFlight.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+
+		ec, err := egdm.NewEntityParser(egdm.NewNamespaceContext()).LoadEntityCollection(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+		for _, entity := range ec.Entities {
+			if strings.HasSuffix(entity.ID, "fail") {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		received.Add(int32(len(ec.Entities)))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.WithBatchSize(10).WithUploadConcurrency(4)
+
+	ec := egdm.NewEntityCollection(nil)
+	for i := 0; i < 100; i++ {
+		_ = ec.AddEntity(egdm.NewEntity().SetID(fmt.Sprintf("http://data.example.com/things/entity%d", i)))
+	}
+
+	result, err := client.StoreEntitiesWithResult("people", ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.EntitiesProcessed != 100 || received.Load() != 100 {
+		t.Errorf("expected 100 entities to be stored, got %d (server received %d)", result.EntitiesProcessed, received.Load())
+	}
+	if maxInFlight.Load() < 2 {
+		t.Errorf("expected batches to be uploaded in parallel, max in flight was %d", maxInFlight.Load())
+	}
+
+	// a failing batch is reported
+	_ = ec.AddEntity(egdm.NewEntity().SetID("http://data.example.com/things/fail"))
+	err = client.StoreEntities("people", ec)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("expected BatchError, got %v", err)
+	}
+	if batchErr.Batch != 11 || batchErr.Batches != 11 {
+		t.Errorf("unexpected batch error %+v", batchErr)
+	}
+}
+
+func TestGetChangesAndEntitiesReader(t *testing.T) {
+	const page = `[{"id":"@context","namespaces":{"ns0":"http://data.example.com/things/"}},{"id":"ns0:entity1","refs":{},"props":{}},{"id":"@continuation","token":"t1"}]`
+	paths := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path + "?" + r.URL.RawQuery
+		_, _ = fmt.Fprint(w, page)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := client.GetChangesReader("people", ChangesOptions{Since: "t0", LatestOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entities, err := client.GetEntitiesReader("people", EntitiesOptions{Take: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, reader := range []io.ReadCloser{changes, entities} {
+		var buf bytes.Buffer
+		_, err = io.Copy(&buf, reader)
+		if err != nil {
+			t.Error(err)
+		}
+		_ = reader.Close()
+
+		if !json.Valid(buf.Bytes()) {
+			t.Errorf("expected valid json, got %s", buf.String())
+		}
+	}
+
+	if path := <-paths; path != "/datasets/people/changes?latestOnly=true&since=t0" {
+		t.Errorf("unexpected changes request %s", path)
+	}
+	if path := <-paths; path != "/datasets/people/entities?limit=5" {
+		t.Errorf("unexpected entities request %s", path)
+	}
+}
+
+func TestChangesStreamRetriesNetworkFailures(t *testing.T) {
+	pages := map[string]string{
+		"":   `[{"id":"@context","namespaces":{"ns0":"http://data.example.com/things/"}},{"id":"ns0:entity1","refs":{},"props":{}},{"id":"@continuation","token":"t1"}]`,
+		"t1": `[{"id":"@context","namespaces":{"ns0":"http://data.example.com/things/"}},{"id":"ns0:entity2","refs":{},"props":{}},{"id":"@continuation","token":"t2"}]`,
+		"t2": `[{"id":"@context","namespaces":{"ns0":"http://data.example.com/things/"}},{"id":"@continuation","token":"t2"}]`,
+	}
+	var drops atomic.Int32
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		since := r.URL.Query().Get("since")
+		if since == "t1" && drops.Add(-1) >= 0 {
+			// drop the connection part way through the response
+			w.Header().Set("Content-Length", "1000")
+			_, _ = fmt.Fprint(w, pages[since][:40])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		if since == "bad" {
+			_, _ = fmt.Fprint(w, `not json`)
+			return
+		}
+		_, _ = fmt.Fprint(w, pages[since])
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readAll := func(since string) (int, error) {
+		stream, err := client.GetChangesStream("people", since, false, 0, false, true)
+		if err != nil {
+			return 0, err
+		}
+		count := 0
+		for {
+			entity, err := stream.Next()
+			if err != nil {
+				return count, err
+			}
+			if entity == nil {
+				return count, nil
+			}
+			count++
+		}
+	}
+
+	// without retries the dropped connection fails the stream
+	drops.Store(1)
+	_, err = readAll("")
+	if err == nil {
+		t.Error("expected error when the connection is dropped")
+	}
+
+	// with retries the stream resumes from the last token
+	client.WithStreamRetries(3, time.Millisecond)
+	drops.Store(2)
+	count, err := readAll("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 entities, got %d", count)
+	}
+
+	// responses that cannot be parsed are not retried
+	requests.Store(0)
+	_, err = readAll("bad")
+	if err == nil {
+		t.Error("expected parse error")
+	}
+	if requests.Load() != 1 {
+		t.Errorf("expected parse errors not to be retried, got %d requests", requests.Load())
+	}
+}
+
+func TestChangesStreamDoesNotRetryCertificateFailures(t *testing.T) {
+	var handshakes atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `[{"id":"@context","namespaces":{}}]`)
+	}))
+	server.TLS = &tls.Config{GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		handshakes.Add(1)
+		return nil, nil
+	}}
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	// the client does not trust the self-signed certificate of the server
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.WithStreamRetries(3, time.Millisecond)
+
+	stream, err := client.GetChangesStream("people", "", false, 0, false, true)
+	if err == nil {
+		_, err = stream.Next()
+	}
+	var certErr *tls.CertificateVerificationError
+	if !errors.As(err, &certErr) {
+		t.Errorf("expected a certificate error, got %v", err)
+	}
+	if handshakes.Load() != 1 {
+		t.Errorf("expected the certificate failure not to be retried, got %d handshakes", handshakes.Load())
+	}
+}
+
+func TestForEachSumsPropertyOverStream(t *testing.T) {
+	pages := map[string]string{
+		"":   `[{"id":"@context","namespaces":{"ns0":"http://data.example.com/things/"}},{"id":"ns0:e1","refs":{},"props":{"ns0:amount":10}},{"id":"ns0:e2","refs":{},"props":{"ns0:amount":5}},{"id":"@continuation","token":"t1"}]`,
+		"t1": `[{"id":"@context","namespaces":{"ns0":"http://data.example.com/things/"}},{"id":"ns0:e3","refs":{},"props":{"ns0:amount":27}},{"id":"@continuation","token":"t2"}]`,
+		"t2": `[{"id":"@context","namespaces":{"ns0":"http://data.example.com/things/"}},{"id":"@continuation","token":"t2"}]`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, pages[r.URL.Query().Get("from")])
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := client.GetEntitiesStream("orders", "", 2, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	total := 0.0
+	err = ForEach(stream, func(entity *egdm.Entity) error {
+		amount, ok := entity.Properties["http://data.example.com/things/amount"].(float64)
+		if !ok {
+			return fmt.Errorf("entity %s has no amount", entity.ID)
+		}
+		total += amount
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if total != 42 {
+		t.Errorf("expected total of 42, got %v", total)
+	}
+
+	// an error from fn stops the iteration
+	stream, err = client.GetEntitiesStream("orders", "", 2, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := errors.New("stop")
+	calls := 0
+	err = ForEach(stream, func(entity *egdm.Entity) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("expected iteration to stop on the first error, got %v after %d calls", err, calls)
+	}
+}
+
+func TestGetDatasetChangesCount(t *testing.T) {
+	pages := map[string]string{
+		"":   `[{"id":"@context","namespaces":{"ns0":"http://data.example.com/things/"}},{"id":"ns0:e1","refs":{},"props":{}},{"id":"ns0:e2","refs":{},"props":{}},{"id":"@continuation","token":"t1"}]`,
+		"t1": `[{"id":"@context","namespaces":{"ns0":"http://data.example.com/things/"}},{"id":"ns0:e3","refs":{},"props":{}},{"id":"@continuation","token":"t2"}]`,
+		"t2": `[{"id":"@context","namespaces":{"ns0":"http://data.example.com/things/"}},{"id":"@continuation","token":"t2"}]`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, pages[r.URL.Query().Get("since")])
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		since string
+		count int
+	}{
+		{since: "", count: 3},
+		{since: "t1", count: 1},
+		{since: "t2", count: 0},
+	}
+	for _, tc := range testCases {
+		count, head, err := client.GetDatasetChangesCount("people", tc.since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != tc.count || head != "t2" {
+			t.Errorf("since '%s': expected %d changes and head t2, got %d and %s", tc.since, tc.count, count, head)
+		}
+	}
+}
+
+func TestGetAndSetDatasetNamespaces(t *testing.T) {
+	var stored []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/datasets/people" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			namespaces, _ := json.Marshal(createDatasetConfig{}.PublicNamespaces)
+			if stored != nil {
+				conf := &createDatasetConfig{}
+				_ = json.Unmarshal(stored, conf)
+				namespaces, _ = json.Marshal(conf.PublicNamespaces)
+			}
+			_, _ = fmt.Fprintf(w, `{"id":"ns0:people","refs":{},"props":{"ns0:name":"people","ns0:publicNamespaces":%s}}`, namespaces)
+		case http.MethodPost:
+			stored, _ = io.ReadAll(r.Body)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	namespaces, err := client.GetDatasetNamespaces("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(namespaces) != 0 {
+		t.Errorf("expected no namespaces, got %v", namespaces)
+	}
+
+	err = client.SetDatasetNamespaces("people", []string{"http://data.example.com/people/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(stored), `"publicNamespaces":["http://data.example.com/people/"]`) {
+		t.Errorf("expected public namespaces to be sent as dataset config, got %s", stored)
+	}
+
+	namespaces, err = client.GetDatasetNamespaces("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(namespaces) != 1 || namespaces[0] != "http://data.example.com/people/" {
+		t.Errorf("expected the stored namespace, got %v", namespaces)
+	}
+
+	_, err = client.GetDatasetNamespaces("")
+	var paramErr *ParameterError
+	if !errors.As(err, &paramErr) {
+		t.Errorf("expected a ParameterError for an empty dataset name, got %v", err)
+	}
+}
+
+func TestReplicateDataset(t *testing.T) {
+	client := NewAdminUserConfiguredClient()
+
+	srcDataset := "test-" + uuid.New().String()
+	dstDataset := "test-" + uuid.New().String()
+	for _, name := range []string{srcDataset, dstDataset} {
+		err := client.AddDataset(name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	namespaceManager := egdm.NewNamespaceContext()
+	ec := egdm.NewEntityCollection(namespaceManager)
+	for i := 0; i < 5; i++ {
+		prefixedId, _ := namespaceManager.AssertPrefixedIdentifierFromURI(fmt.Sprintf("http://data.example.com/things/entity%d", i))
+		err := ec.AddEntity(egdm.NewEntity().SetID(prefixedId))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := client.StoreEntities(srcDataset, ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := client.ReplicateDataset(srcDataset, client, dstDataset, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 5 {
+		t.Errorf("expected 5 changes to be copied, got %d", count)
+	}
+
+	ec2, err := client.GetEntities(dstDataset, "", -1, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ec2.Entities) != 5 {
+		t.Errorf("expected 5 entities in the destination, got %d", len(ec2.Entities))
+	}
+}
+
+func TestReplicateDatasetSinceResumes(t *testing.T) {
+	pages := map[string]string{
+		"":   `[{"id":"@context","namespaces":{"ns0":"http://data.example.com/things/"}},{"id":"ns0:e1","refs":{},"props":{}},{"id":"ns0:e2","refs":{},"props":{}},{"id":"@continuation","token":"t1"}]`,
+		"t1": `[{"id":"@context","namespaces":{"ns0":"http://data.example.com/things/"}},{"id":"ns0:e3","refs":{},"props":{}},{"id":"@continuation","token":"t2"}]`,
+		"t2": `[{"id":"@context","namespaces":{"ns0":"http://data.example.com/things/"}},{"id":"@continuation","token":"t2"}]`,
+	}
+	var stored []string
+	failStore := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/datasets/src/changes":
+			_, _ = fmt.Fprint(w, pages[r.URL.Query().Get("since")])
+		case "/datasets/dst/entities":
+			if failStore {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			ec, err := egdm.NewEntityParser(egdm.NewNamespaceContext()).WithLenientNamespaceChecks().LoadEntityCollection(r.Body)
+			if err != nil || ec.Continuation != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			for _, entity := range ec.Entities {
+				stored = append(stored, entity.ID)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, token, err := client.ReplicateDatasetSince("src", "", client, "dst", 2)
+	if err != nil || count != 3 || token != "t2" {
+		t.Fatalf("expected 3 changes copied up to t2, got %d, '%s', %v", count, token, err)
+	}
+
+	// a failed store returns the since token to resume from
+	stored = nil
+	failStore = true
+	count, token, err = client.ReplicateDatasetSince("src", "t1", client, "dst", 2)
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) || count != 0 || token != "t1" {
+		t.Errorf("expected a RequestError and the since token to resume from, got %d, '%s', %v", count, token, err)
+	}
+
+	failStore = false
+	count, token, err = client.ReplicateDatasetSince("src", token, client, "dst", 2)
+	if err != nil || count != 1 || token != "t2" {
+		t.Errorf("expected the resumed replication to copy 1 change up to t2, got %d, '%s', %v", count, token, err)
+	}
+	if len(stored) != 1 {
+		t.Errorf("expected 1 stored entity after resuming, got %v", stored)
+	}
+}
+
+func TestTestProxyDatasetNotFound(t *testing.T) {
+	var changesRequested atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/datasets/missing/changes" {
+			changesRequested.Store(true)
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.TestProxyDataset("missing")
+	var requestErr *RequestError
+	if !errors.As(err, &requestErr) || !strings.Contains(err.Error(), "proxy dataset not found") {
+		t.Errorf("expected a RequestError for a missing dataset, got %v", err)
+	}
+	if changesRequested.Load() {
+		t.Error("expected the changes of a missing dataset not to be requested")
+	}
+}
+
+func TestReplicateDatasetSinglePageWithoutContinuation(t *testing.T) {
+	var stored []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/datasets/src/changes":
+			// the whole dataset in one page without a continuation
+			_, _ = fmt.Fprint(w, `[{"id":"@context","namespaces":{"ns0":"http://data.example.com/things/"}},{"id":"ns0:e1","refs":{},"props":{}},{"id":"ns0:e2","refs":{},"props":{}}]`)
+		case "/datasets/dst/entities":
+			ec, err := egdm.NewEntityParser(egdm.NewNamespaceContext()).WithLenientNamespaceChecks().LoadEntityCollection(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			for _, entity := range ec.Entities {
+				stored = append(stored, entity.ID)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, token, err := client.ReplicateDatasetSince("src", "", client, "dst", 10)
+	if err != nil || count != 2 || token != "" {
+		t.Fatalf("expected 2 changes copied, got %d, '%s', %v", count, token, err)
+	}
+	if len(stored) != 2 {
+		t.Errorf("expected the page to be stored once, got %v", stored)
+	}
+}
+
+func TestTestProxyDataset(t *testing.T) {
+	testCases := []struct {
+		name   string
+		status int
+		body   string
+		errMsg string
+	}{
+		{name: "ok", status: http.StatusOK, body: `[{"id":"@context","namespaces":{}},{"id":"@continuation","token":"t1"}]`},
+		{name: "not found", status: http.StatusNotFound, errMsg: "not found"},
+		{name: "unauthorised", status: http.StatusForbidden, errMsg: "auth provider"},
+		{name: "unreachable", status: http.StatusBadGateway, errMsg: "remote URL"},
+		{name: "bad schema", status: http.StatusOK, body: `{"message":"hello"}`, errMsg: "entity graph data"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/datasets/remote" {
+					_, _ = fmt.Fprint(w, `{"id":"ns0:remote","props":{"ns0:name":"remote"}}`)
+					return
+				}
+				if r.URL.Path != "/datasets/remote/changes" || r.URL.Query().Get("limit") != "1" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				w.WriteHeader(tc.status)
+				_, _ = fmt.Fprint(w, tc.body)
+			}))
+			defer server.Close()
+
+			client, err := NewClient(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = client.TestProxyDataset("remote")
+			if tc.errMsg == "" {
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+				t.Errorf("expected error containing '%s', got %v", tc.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestGetChangesIncludesDeletedEntities(t *testing.T) {
+	client := NewAdminUserConfiguredClient()
+
+	datasetName := "test-" + uuid.New().String()
+	err := client.AddDataset(datasetName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	namespaceManager := egdm.NewNamespaceContext()
+	prefixedId, _ := namespaceManager.AssertPrefixedIdentifierFromURI("http://data.example.com/things/entity1")
+	ec := egdm.NewEntityCollection(namespaceManager)
+	_ = ec.AddEntity(egdm.NewEntity().SetID(prefixedId))
+	err = client.StoreEntities(datasetName, ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted := egdm.NewEntityCollection(namespaceManager)
+	entity := egdm.NewEntity().SetID(prefixedId)
+	entity.IsDeleted = true
+	_ = deleted.AddEntity(entity)
+	err = client.StoreEntities(datasetName, deleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := client.GetChanges(datasetName, "", 0, false, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes.Entities) != 2 || changes.Entities[0].IsDeleted || !changes.Entities[1].IsDeleted {
+		t.Errorf("expected the stored change followed by the deleted change, got %v", changes.Entities)
+	}
+}
+
+func TestChangesStreamSurfacesDeletedEntities(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("since") != "" {
+			_, _ = fmt.Fprint(w, `[{"id":"@context","namespaces":{}},{"id":"@continuation","token":"t1"}]`)
+			return
+		}
+		_, _ = fmt.Fprint(w, `[{"id":"@context","namespaces":{"ns0":"http://data.example.com/things/"}},`+
+			`{"id":"ns0:e1","refs":{},"props":{}},`+
+			`{"id":"ns0:e1","deleted":true,"refs":{},"props":{}},`+
+			`{"id":"@continuation","token":"t1"}]`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := client.GetChanges("things", "", 0, false, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes.Entities) != 2 || changes.Entities[0].IsDeleted || !changes.Entities[1].IsDeleted {
+		t.Errorf("expected the second change to be deleted, got %v", changes.Entities)
+	}
+
+	stream, err := client.GetChangesStream("things", "", false, 10, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entities, err := Collect(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 2 || entities[0].IsDeleted || !entities[1].IsDeleted {
+		t.Errorf("expected the second streamed change to be deleted, got %v", entities)
+	}
+	if entities[1].ID != "http://data.example.com/things/e1" {
+		t.Errorf("expected the deleted entity id to be expanded, got %s", entities[1].ID)
+	}
+}
+
+func TestGetDatasetStats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/datasets/people/stats" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"entities":3,"deleted":1,"changes":5,"lastModified":"2024-01-02T03:04:05Z"}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := client.GetDatasetStats("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &DatasetStats{Name: "people", Entities: 3, Deleted: 1, Changes: 5, LastModified: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	if *stats != *expected {
+		t.Errorf("expected %v, got %v", expected, stats)
+	}
+
+	_, err = client.GetDatasetStats("")
+	var paramErr *ParameterError
+	if !errors.As(err, &paramErr) {
+		t.Errorf("expected a ParameterError for an empty dataset name, got %v", err)
+	}
+}
+
+func TestAddDatasets(t *testing.T) {
+	created := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/datasets/")
+		if r.Method != http.MethodPost || name == "broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if _, ok := created[name]; ok {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		created[name] = string(body)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specs := []DatasetSpec{
+		{Name: "people", PublicNamespaces: []string{"http://data.example.com/people/"}},
+		{Name: "places"},
+		{Name: "people"},
+		{Name: ""},
+		{Name: "broken"},
+	}
+	errs, err := client.AddDatasets(specs)
+	var bulkErr *BulkError
+	if !errors.As(err, &bulkErr) || bulkErr.Failed != 2 || bulkErr.Total != 5 {
+		t.Fatalf("expected a BulkError with 2 of 5 failed, got %v", err)
+	}
+	var paramErr *ParameterError
+	var reqErr *RequestError
+	if errs[0] != nil || errs[1] != nil || errs[2] != nil || !errors.As(errs[3], &paramErr) || !errors.As(errs[4], &reqErr) {
+		t.Errorf("unexpected per dataset errors %v", errs)
+	}
+	if !strings.Contains(created["people"], "http://data.example.com/people/") || len(created) != 2 {
+		t.Errorf("expected people and places to be created with their namespaces, got %v", created)
+	}
+
+	// applying the same datasets again succeeds
+	errs, err = client.AddDatasets(specs[:3])
+	if err != nil {
+		t.Errorf("expected existing datasets to count as created, got %v", errs)
+	}
+}
+
+func TestGetDatasetPublicNamespaces(t *testing.T) {
+	client := NewAdminUserConfiguredClient()
+
+	datasetName := "test-" + uuid.New().String()
+	err := client.AddDataset(datasetName, []string{"http://data.example.com/things/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataset, err := client.GetDataset(datasetName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dataset.PublicNamespaces) != 1 || dataset.PublicNamespaces[0] != "http://data.example.com/things/" {
+		t.Errorf("expected the public namespace to be read back, got %v", dataset.PublicNamespaces)
+	}
+}
+
+func TestGetDatasetReadsPublicNamespaces(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"id":"ns0:people","refs":{},"props":{"ns0:name":"people","ns0:publicNamespaces":["http://data.example.com/people/"]}}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataset, err := client.GetDataset("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dataset.Name != "people" || len(dataset.PublicNamespaces) != 1 || dataset.PublicNamespaces[0] != "http://data.example.com/people/" {
+		t.Errorf("expected people with its public namespace, got %v", dataset)
+	}
+}
+
+func TestGetDatasetNameWithServerPrefix(t *testing.T) {
+	response := `{"id":"ns3:people","refs":{},"props":{"ns3:name":"people"}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, response)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataset, err := client.GetDataset("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dataset.Name != "people" {
+		t.Errorf("expected dataset people, got %v", dataset.Name)
+	}
+
+	// a missing or non-string name is reported instead of panicking
+	for _, response = range []string{`{"id":"ns3:people","refs":{},"props":{}}`, `{"id":"ns3:people","refs":{},"props":{"ns3:name":42}}`} {
+		_, err = client.GetDataset("people")
+		var processingErr *ClientProcessingError
+		if !errors.As(err, &processingErr) {
+			t.Errorf("expected a ClientProcessingError for %s, got %v", response, err)
+		}
+	}
+}
+
+func TestSetDatasetPublicNamespaces(t *testing.T) {
+	client := NewAdminUserConfiguredClient()
+
+	datasetName := "test-" + uuid.New().String()
+	err := client.AddDataset(datasetName, []string{"http://data.example.com/things/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.SetDatasetPublicNamespaces(datasetName, []string{"http://data.example.com/people/", "http://data.example.com/places/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataset, err := client.GetDataset(datasetName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dataset.PublicNamespaces, []string{"http://data.example.com/people/", "http://data.example.com/places/"}) {
+		t.Errorf("expected the public namespaces to be replaced, got %v", dataset.PublicNamespaces)
+	}
+}
+
+func TestSetDatasetPublicNamespacesRequest(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/datasets/people" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodGet {
+			// only the existence of the dataset is checked, the entity does not need a name
+			_, _ = w.Write([]byte(`{"id":"ns0:people","props":{}}`))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.SetDatasetPublicNamespaces("people", []string{"http://data.example.com/people/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.SetDatasetPublicNamespaces("people", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		`{"proxyDatasetConfig":null,"publicNamespaces":["http://data.example.com/people/"]}`,
+		`{"proxyDatasetConfig":null,"publicNamespaces":[]}`,
+	}
+	if !reflect.DeepEqual(bodies, expected) {
+		t.Errorf("expected the namespaces to be sent as dataset config, got %v", bodies)
+	}
+
+	// a dataset that does not exist is not created
+	bodies = nil
+	err = client.SetDatasetPublicNamespaces("peple", []string{"http://data.example.com/people/"})
+	var requestErr *RequestError
+	if !errors.As(err, &requestErr) || !strings.Contains(err.Error(), "dataset peple not found") {
+		t.Errorf("expected a RequestError for a missing dataset, got %v", err)
+	}
+	if len(bodies) != 0 {
+		t.Errorf("expected no dataset config to be sent, got %v", bodies)
+	}
+}
+
+func TestTruncateDataset(t *testing.T) {
+	client := NewAdminUserConfiguredClient()
+
+	datasetName := "test-" + uuid.New().String()
+	err := client.AddDataset(datasetName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	namespaceManager := egdm.NewNamespaceContext()
+	ec := egdm.NewEntityCollection(namespaceManager)
+	for _, id := range []string{"entity1", "entity2"} {
+		prefixedId, _ := namespaceManager.AssertPrefixedIdentifierFromURI("http://data.example.com/things/" + id)
+		_ = ec.AddEntity(egdm.NewEntity().SetID(prefixedId))
+	}
+	err = client.StoreEntities(datasetName, ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.TruncateDataset(datasetName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entities, err := client.GetEntities(datasetName, "", 0, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities.Entities) != 0 {
+		t.Errorf("expected no entities after truncating, got %d", len(entities.Entities))
+	}
+
+	_, err = client.GetDataset(datasetName)
+	if err != nil {
+		t.Errorf("expected the dataset to still exist, got %v", err)
+	}
+
+	err = client.TruncateDataset("")
+	var paramErr *ParameterError
+	if !errors.As(err, &paramErr) {
+		t.Errorf("expected a ParameterError for an empty dataset name, got %v", err)
+	}
+}
+
+func TestStoreEntitiesIfMatch(t *testing.T) {
+	// the stub dataset is at version "1" and moves to the next version on every write
+	var lock sync.Mutex
+	version := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/datasets/people/entities" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = io.ReadAll(r.Body)
+
+		lock.Lock()
+		defer lock.Unlock()
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != strconv.Itoa(version) {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"message":"dataset has changed"}`))
+			return
+		}
+		version++
+		_, _ = w.Write([]byte(`{"entitiesProcessed":1,"token":"` + strconv.Itoa(version) + `"}`))
+	}))
+	defer server.Close()
+
+	writerA, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writerB := writerA.Clone()
+
+	newCollection := func(id string) *egdm.EntityCollection {
+		nsManager := egdm.NewNamespaceContext()
+		nsManager.StorePrefixExpansionMapping("ns0", "http://data.example.com/people/")
+		ec := egdm.NewEntityCollection(nsManager)
+		_ = ec.AddEntity(egdm.NewEntity().SetID("ns0:" + id))
+		return ec
+	}
+
+	// both writers read version 1, the first write wins and the second must not overwrite it
+	result, err := writerA.StoreEntitiesIfMatch("people", "1", newCollection("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Token != "2" {
+		t.Errorf("expected the new version 2, got %v", result.Token)
+	}
+
+	_, err = writerB.StoreEntitiesIfMatch("people", "1", newCollection("b"))
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected a ConflictError, got %v", err)
+	}
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || serverErr.Message != "dataset has changed" {
+		t.Errorf("expected the server error in the conflict, got %v", err)
+	}
+
+	// after reading the dataset again the second writer succeeds
+	_, err = writerB.StoreEntitiesIfMatch("people", result.Token, newCollection("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = writerB.StoreEntitiesIfMatch("people", "", newCollection("b"))
+	var paramErr *ParameterError
+	if !errors.As(err, &paramErr) {
+		t.Errorf("expected a ParameterError for an empty version, got %v", err)
+	}
+}
+
+func TestGetDatasetStatsCountsStoredEntities(t *testing.T) {
+	client := NewAdminUserConfiguredClient()
+
+	datasetName := "test-" + uuid.New().String()
+	err := client.AddDataset(datasetName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	namespaceManager := egdm.NewNamespaceContext()
+	ec := egdm.NewEntityCollection(namespaceManager)
+	for _, id := range []string{"entity1", "entity2", "entity3"} {
+		prefixedId, _ := namespaceManager.AssertPrefixedIdentifierFromURI("http://data.example.com/things/" + id)
+		entity := egdm.NewEntity().SetID(prefixedId)
+		entity.IsDeleted = id == "entity3"
+		_ = ec.AddEntity(entity)
+	}
+	err = client.StoreEntities(datasetName, ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := client.GetDatasetStats(datasetName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Entities != 3 || stats.Deleted != 1 {
+		t.Errorf("expected 3 entities of which 1 is deleted, got %v", stats)
+	}
+	if stats.LastModified.IsZero() {
+		t.Errorf("expected the last modified time to be set, got %v", stats)
+	}
+}
+
+func TestStoreEntityStreamSendsValidJSON(t *testing.T) {
+	received := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- body
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := `[{"id":"@context","namespaces":{"ns0":"http://data.example.com/people/"}},{"id":"ns0:a","props":{"ns0:name":"a"}},{"id":"ns0:b"}]`
+	err = client.StoreEntityStream("people", strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entities []map[string]any
+	body := <-received
+	if err := json.Unmarshal(body, &entities); err != nil {
+		t.Fatalf("expected a JSON array, got %s: %v", body, err)
+	}
+	if len(entities) != 3 || entities[1]["id"] != "http://data.example.com/people/a" || entities[2]["id"] != "http://data.example.com/people/b" {
+		t.Errorf("expected the context and two expanded entities, got %s", body)
+	}
+}
+
+func TestUpdateDatasetEntityIfMatch(t *testing.T) {
+	// the stub dataset entity is at version "1" and moves to the next version on every update
+	var lock sync.Mutex
+	version := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/datasets/people" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("ETag", strconv.Itoa(version))
+			_, _ = w.Write([]byte(`{"id":"ns0:people","props":{}}`))
+		case http.MethodPut:
+			_, _ = io.ReadAll(r.Body)
+			if r.Header.Get("If-Match") != strconv.Itoa(version) {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			version++
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entityA, versionA, err := client.GetDatasetEntityWithVersion("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versionA != "1" || entityA.ID != "ns0:people" {
+		t.Fatalf("expected entity ns0:people at version 1, got %s at version %s", entityA.ID, versionA)
+	}
+	entityB, versionB, err := client.GetDatasetEntityWithVersion("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first update wins and the second must not overwrite it
+	err = client.UpdateDatasetEntityIfMatch("people", versionA, entityA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.UpdateDatasetEntityIfMatch("people", versionB, entityB)
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Errorf("expected a ConflictError, got %v", err)
+	}
+
+	err = client.UpdateDatasetEntityIfMatch("people", "", entityB)
+	var paramErr *ParameterError
+	if !errors.As(err, &paramErr) {
+		t.Errorf("expected a ParameterError for an empty version, got %v", err)
+	}
+}
+
+func TestNoLimitSendsNoLimitParameter(t *testing.T) {
+	var queries []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query())
+		_, _ = w.Write([]byte(`[{"id":"@context","namespaces":{}},{"id":"@continuation","token":"next"}]`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, take := range []int{NoLimit, 0, 5} {
+		queries = nil
+		if _, err := client.GetChanges("people", "", take, false, false, false); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.GetEntities("people", "", take, false, false); err != nil {
+			t.Fatal(err)
+		}
+		for _, query := range queries {
+			if take > 0 && query.Get("limit") != strconv.Itoa(take) {
+				t.Errorf("expected limit %d for take %d, got %v", take, take, query)
+			}
+			if take <= 0 && query.Has("limit") {
+				t.Errorf("expected no limit for take %d, got %v", take, query)
+			}
+		}
+	}
+}
+
+func TestStoreEntitiesStreamWithProgress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entities := func(from int, to int) string {
+		var sb strings.Builder
+		for i := from; i < to; i++ {
+			sb.WriteString(`,{"id":"ns0:` + strconv.Itoa(i) + `"}`)
+		}
+		return sb.String()
+	}
+	header := `[{"id":"@context","namespaces":{"ns0":"http://data.example.com/people/"}}`
+
+	var reported []int
+	err = client.StoreEntitiesStreamWithProgress(context.Background(), "people", strings.NewReader(header+entities(0, 2500)+"]"),
+		func(entitiesWritten int) { reported = append(reported, entitiesWritten) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reported, []int{1000, 2000, 2500}) {
+		t.Errorf("expected progress 1000, 2000 and 2500, got %v", reported)
+	}
+
+	// cancel the upload once the first 1000 entities are written, while the data is still being produced
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dataReader, dataWriter := io.Pipe()
+	defer dataReader.Close()
+	go func() {
+		_, _ = dataWriter.Write([]byte(header + entities(0, 1500)))
+		<-ctx.Done()
+		_, _ = dataWriter.Write([]byte(entities(1500, 1600) + "]"))
+		_ = dataWriter.Close()
+	}()
+
+	var lock sync.Mutex
+	reported = nil
+	err = client.StoreEntitiesStreamWithProgress(ctx, "people", dataReader, func(entitiesWritten int) {
+		lock.Lock()
+		defer lock.Unlock()
+		reported = append(reported, entitiesWritten)
+		if entitiesWritten == 1000 {
+			cancel()
+		}
+	})
+	var requestErr *RequestError
+	if !errors.As(err, &requestErr) || !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a RequestError for the cancelled upload, got %v", err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if !reflect.DeepEqual(reported, []int{1000}) {
+		t.Errorf("expected progress to stop at 1000, got %v", reported)
+	}
+}
+
+func TestStoreEntitiesConcurrentlyAbortsUploadsInFlight(t *testing.T) {
+	var aborted atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ec, err := egdm.NewEntityParser(egdm.NewNamespaceContext()).LoadEntityCollection(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, entity := range ec.Entities {
+			if strings.HasSuffix(entity.ID, "fail") {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		// the other batch is slow and must be aborted by the failure
+		select {
+		case <-r.Context().Done():
+			aborted.Add(1)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.WithBatchSize(10).WithUploadConcurrency(2)
+
+	ec := egdm.NewEntityCollection(nil)
+	for i := 0; i < 10; i++ {
+		_ = ec.AddEntity(egdm.NewEntity().SetID(fmt.Sprintf("http://data.example.com/things/entity%d", i)))
+	}
+	_ = ec.AddEntity(egdm.NewEntity().SetID("http://data.example.com/things/fail"))
+
+	start := time.Now()
+	err = client.StoreEntities("people", ec)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || batchErr.Batch != 2 {
+		t.Fatalf("expected the second batch to fail, got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("expected the slow batch to be aborted, the upload took %s", time.Since(start))
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for aborted.Load() != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if aborted.Load() != 1 {
+		t.Errorf("expected the server to see the slow batch aborted")
+	}
+}
@@ -0,0 +1,164 @@
+package datahub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	egdm "github.com/mimiro-io/entity-graph-data-model"
+)
+
+// EntityDiff is the difference between two entity collections, see DiffEntities.
+// Added are the ids of entities only in the second collection, Removed the ids of entities only in the first
+// collection and Modified the ids of entities in both with different properties, references or deleted flag.
+// Ids are expanded URIs and sorted.
+type EntityDiff struct {
+	Added    []string
+	Removed  []string
+	Modified []string
+}
+
+// IsEmpty returns true if the collections contain the same entities.
+func (d *EntityDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+// DiffEntities compares two entity collections, for example the entities of a dataset before and after a sync.
+// Entities are matched on their expanded id and compared on their expanded properties and references, so
+// collections using different prefixes for the same namespaces compare equal. If a collection contains several
+// versions of an entity, as changes do, the last version is compared.
+// returns a ParameterError if a collection is nil.
+// returns a ClientProcessingError if an id, property or reference uses a prefix the collection does not define.
+func DiffEntities(a *egdm.EntityCollection, b *egdm.EntityCollection) (*EntityDiff, error) {
+	if a == nil || b == nil {
+		return nil, &ParameterError{Msg: "entity collections cannot be nil"}
+	}
+
+	entitiesA, err := expandEntities(a)
+	if err != nil {
+		return nil, &ClientProcessingError{Msg: "unable to expand first entity collection", Err: err}
+	}
+
+	entitiesB, err := expandEntities(b)
+	if err != nil {
+		return nil, &ClientProcessingError{Msg: "unable to expand second entity collection", Err: err}
+	}
+
+	diff := &EntityDiff{Added: make([]string, 0), Removed: make([]string, 0), Modified: make([]string, 0)}
+	for id, entityA := range entitiesA {
+		entityB, ok := entitiesB[id]
+		if !ok {
+			diff.Removed = append(diff.Removed, id)
+		} else if !reflect.DeepEqual(entityA, entityB) {
+			diff.Modified = append(diff.Modified, id)
+		}
+	}
+	for id := range entitiesB {
+		if _, ok := entitiesA[id]; !ok {
+			diff.Added = append(diff.Added, id)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Modified)
+	return diff, nil
+}
+
+// expandedEntity is an entity with expanded URIs and JSON normalised values, so that it can be compared with DeepEqual
+type expandedEntity struct {
+	deleted    bool
+	properties map[string]any
+	references map[string]any
+}
+
+// expandEntities returns the last version of each entity in the collection keyed by its expanded id
+func expandEntities(ec *egdm.EntityCollection) (map[string]*expandedEntity, error) {
+	nsManager := ec.NamespaceManager
+	if nsManager == nil {
+		nsManager = egdm.NewNamespaceContext()
+	}
+
+	entities := make(map[string]*expandedEntity)
+	for _, entity := range ec.Entities {
+		id, err := nsManager.GetFullURI(entity.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		expanded := &expandedEntity{deleted: entity.IsDeleted, properties: map[string]any{}, references: map[string]any{}}
+		for key, value := range entity.Properties {
+			fullKey, err := nsManager.GetFullURI(key)
+			if err != nil {
+				return nil, err
+			}
+			normalised, err := normaliseValue(value)
+			if err != nil {
+				return nil, err
+			}
+			expanded.properties[fullKey] = normalised
+		}
+
+		for key, value := range entity.References {
+			fullKey, err := nsManager.GetFullURI(key)
+			if err != nil {
+				return nil, err
+			}
+			fullValue, err := expandReference(nsManager, value)
+			if err != nil {
+				return nil, err
+			}
+			expanded.references[fullKey] = fullValue
+		}
+
+		entities[id] = expanded
+	}
+	return entities, nil
+}
+
+// expandReference expands a reference value, a single id or a list of ids, to a string or a slice of any
+func expandReference(nsManager egdm.NamespaceManager, value any) (any, error) {
+	switch ref := value.(type) {
+	case string:
+		return nsManager.GetFullURI(ref)
+	case []string:
+		values := make([]any, len(ref))
+		for i, v := range ref {
+			fullValue, err := nsManager.GetFullURI(v)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = fullValue
+		}
+		return values, nil
+	case []any:
+		values := make([]any, len(ref))
+		for i, v := range ref {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("unexpected reference value %v", v)
+			}
+			fullValue, err := nsManager.GetFullURI(s)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = fullValue
+		}
+		return values, nil
+	}
+	return nil, errors.New("unexpected type in refs")
+}
+
+// normaliseValue round trips a property value through JSON, so that for example an int set in code and a
+// float64 read from the server compare equal
+func normaliseValue(value any) (any, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var normalised any
+	err = json.Unmarshal(data, &normalised)
+	return normalised, err
+}
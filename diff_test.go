@@ -0,0 +1,68 @@
+package datahub
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	egdm "github.com/mimiro-io/entity-graph-data-model"
+)
+
+func TestDiffEntities(t *testing.T) {
+	a, err := egdm.NewEntityParser(egdm.NewNamespaceContext()).WithLenientNamespaceChecks().LoadEntityCollection(strings.NewReader(`[
+		{"id":"@context","namespaces":{"ns0":"http://data.example.com/things/","ns1":"http://data.example.com/props/"}},
+		{"id":"ns0:same","props":{"ns1:name":"same","ns1:age":42},"refs":{"ns1:friend":["ns0:other"]}},
+		{"id":"ns0:changed","props":{"ns1:name":"before"},"refs":{}},
+		{"id":"ns0:changed-ref","props":{},"refs":{"ns1:friend":"ns0:same"}},
+		{"id":"ns0:removed","props":{},"refs":{}},
+		{"id":"ns0:deleted","props":{},"refs":{}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second collection uses different prefixes for the same namespaces
+	nsManager := egdm.NewNamespaceContext()
+	b := egdm.NewEntityCollection(nsManager)
+	nsManager.StorePrefixExpansionMapping("things", "http://data.example.com/things/")
+	nsManager.StorePrefixExpansionMapping("props", "http://data.example.com/props/")
+	entities := []*egdm.Entity{
+		egdm.NewEntity().SetID("things:same").SetProperty("props:name", "same").SetProperty("props:age", 42).
+			SetReference("props:friend", []string{"things:other"}),
+		egdm.NewEntity().SetID("things:changed").SetProperty("props:name", "after"),
+		egdm.NewEntity().SetID("things:changed-ref").SetReference("props:friend", "things:removed"),
+		egdm.NewEntity().SetID("things:deleted"),
+		egdm.NewEntity().SetID("things:added"),
+	}
+	entities[3].IsDeleted = true
+	for _, entity := range entities {
+		if err := b.AddEntity(entity); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	diff, err := DiffEntities(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &EntityDiff{
+		Added:    []string{"http://data.example.com/things/added"},
+		Removed:  []string{"http://data.example.com/things/removed"},
+		Modified: []string{"http://data.example.com/things/changed", "http://data.example.com/things/changed-ref", "http://data.example.com/things/deleted"},
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("expected %v, got %v", expected, diff)
+	}
+
+	diff, err = DiffEntities(a, a)
+	if err != nil || !diff.IsEmpty() {
+		t.Errorf("expected a collection to equal itself, got %v, %v", diff, err)
+	}
+
+	_, err = DiffEntities(a, nil)
+	var paramErr *ParameterError
+	if !errors.As(err, &paramErr) {
+		t.Errorf("expected a ParameterError for a nil collection, got %v", err)
+	}
+}
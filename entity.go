@@ -0,0 +1,91 @@
+package datahub
+
+import (
+	egdm "github.com/mimiro-io/entity-graph-data-model"
+)
+
+// NewEntityCollection creates an empty entity collection with its own namespace manager, ready to be filled
+// with AddEntityFromURI and stored with StoreEntities.
+func (c *Client) NewEntityCollection() *egdm.EntityCollection {
+	return egdm.NewEntityCollection(egdm.NewNamespaceContext())
+}
+
+// AddEntityFromURI creates a new entity with the given URI as id and adds it to the collection.
+// A prefix is created in the collection namespace manager for the namespace of the URI if it has none.
+// returns the entity so that properties and references can be set on it.
+// returns a ParameterError if the collection is nil or a prefix cannot be created for the URI.
+func AddEntityFromURI(ec *egdm.EntityCollection, uri string) (*egdm.Entity, error) {
+	if ec == nil || ec.NamespaceManager == nil {
+		return nil, &ParameterError{Msg: "entity collection and its namespace manager cannot be nil"}
+	}
+
+	entityId, err := ec.NamespaceManager.AssertPrefixedIdentifierFromURI(uri)
+	if err != nil {
+		return nil, &ParameterError{Msg: "unable to create prefixed identifier for " + uri, Err: err}
+	}
+
+	entity := egdm.NewEntity().SetID(entityId)
+	err = ec.AddEntity(entity)
+	if err != nil {
+		return nil, &ParameterError{Msg: "unable to add entity " + uri, Err: err}
+	}
+	return entity, nil
+}
+
+// EntityCollectionBuilder is a builder for entity collections.
+// Entities are added using full URIs for ids, property names, reference names and reference values,
+// the builder manages the namespace prefixes.
+type EntityCollectionBuilder struct {
+	collection *egdm.EntityCollection
+	err        error
+}
+
+// NewEntityCollectionBuilder creates a new EntityCollectionBuilder.
+// Use AddEntity to add entities then call Build to get the EntityCollection
+func NewEntityCollectionBuilder() *EntityCollectionBuilder {
+	return &EntityCollectionBuilder{collection: egdm.NewEntityCollection(egdm.NewNamespaceContext())}
+}
+
+// AddEntity adds an entity with the given URI as id to the collection.
+// props are the entity properties keyed by full URI, values are stored as is.
+// refs are the entity references keyed by full URI, values are a URI or a slice of URIs.
+// Both props and refs may be nil.
+func (eb *EntityCollectionBuilder) AddEntity(idURI string, props map[string]any, refs map[string]any) *EntityCollectionBuilder {
+	if eb.err != nil {
+		return eb
+	}
+
+	if !eb.collection.NamespaceManager.IsFullUri(idURI) {
+		eb.err = &ParameterError{Msg: "entity id must be a full URI, got '" + idURI + "'"}
+		return eb
+	}
+
+	entity := egdm.NewEntity().SetID(idURI)
+	for key, value := range props {
+		entity.SetProperty(key, value)
+	}
+	for key, value := range refs {
+		entity.SetReference(key, value)
+	}
+
+	err := compressEntity(eb.collection.NamespaceManager, entity)
+	if err != nil {
+		eb.err = err
+		return eb
+	}
+
+	err = eb.collection.AddEntity(entity)
+	if err != nil {
+		eb.err = &ParameterError{Msg: "unable to add entity " + idURI, Err: err}
+	}
+	return eb
+}
+
+// Build returns the entity collection, ready to be stored with StoreEntities.
+// returns a ParameterError if an entity id is not a full URI or a prefix cannot be created for a URI.
+func (eb *EntityCollectionBuilder) Build() (*egdm.EntityCollection, error) {
+	if eb.err != nil {
+		return nil, eb.err
+	}
+	return eb.collection, nil
+}
@@ -0,0 +1,99 @@
+package datahub
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	egdm "github.com/mimiro-io/entity-graph-data-model"
+)
+
+func TestAddEntityFromURI(t *testing.T) {
+	var stored *egdm.EntityCollection
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		stored, err = egdm.NewEntityParser(egdm.NewNamespaceContext()).WithExpandURIs().LoadEntityCollection(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ec := client.NewEntityCollection()
+	for _, uri := range []string{"http://data.example.com/people/alice", "http://data.example.com/places/oslo"} {
+		_, err := AddEntityFromURI(ec, uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ec.NamespaceManager.GetNamespaceMappings()) != 2 {
+		t.Errorf("expected a prefix for each namespace, got %v", ec.NamespaceManager.GetNamespaceMappings())
+	}
+
+	err = client.StoreEntities("people", ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Entities) != 2 || stored.Entities[0].ID != "http://data.example.com/people/alice" ||
+		stored.Entities[1].ID != "http://data.example.com/places/oslo" {
+		t.Errorf("expected the entities to be stored with their URIs, got %v", stored.Entities)
+	}
+
+	_, err = AddEntityFromURI(nil, "http://data.example.com/people/bob")
+	var paramErr *ParameterError
+	if !errors.As(err, &paramErr) {
+		t.Errorf("expected a ParameterError for a nil collection, got %v", err)
+	}
+}
+
+func TestEntityCollectionBuilder(t *testing.T) {
+	ec, err := NewEntityCollectionBuilder().
+		AddEntity("http://data.example.com/people/alice", map[string]any{"http://data.example.com/props/name": "Alice"},
+			map[string]any{
+				"http://data.example.com/props/livesIn": "http://data.example.com/places/oslo",
+				"http://data.example.com/props/knows":   []string{"http://data.example.com/people/bob"},
+			}).
+		AddEntity("http://data.example.com/places/oslo", nil, nil).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = ec.WriteEntityGraphJSON(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := egdm.NewEntityParser(egdm.NewNamespaceContext()).WithExpandURIs().LoadEntityCollection(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parsed.Entities) != 2 {
+		t.Fatalf("expected 2 entities, got %d", len(parsed.Entities))
+	}
+	alice := parsed.Entities[0]
+	if alice.ID != "http://data.example.com/people/alice" || alice.Properties["http://data.example.com/props/name"] != "Alice" {
+		t.Errorf("unexpected entity %+v", alice)
+	}
+	if alice.References["http://data.example.com/props/livesIn"] != "http://data.example.com/places/oslo" {
+		t.Errorf("expected the reference to be expanded, got %v", alice.References)
+	}
+	if knows, ok := alice.References["http://data.example.com/props/knows"].([]string); !ok || len(knows) != 1 ||
+		knows[0] != "http://data.example.com/people/bob" {
+		t.Errorf("expected the reference list to be expanded, got %v", alice.References)
+	}
+
+	_, err = NewEntityCollectionBuilder().AddEntity("alice", nil, nil).Build()
+	var paramErr *ParameterError
+	if !errors.As(err, &paramErr) {
+		t.Errorf("expected a ParameterError for an id that is not a URI, got %v", err)
+	}
+}
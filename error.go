@@ -1,6 +1,11 @@
 package datahub
 
-import "fmt"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
 
 // RequestError is an error that occurs when there is an issue making the request
 // or with the request data.
@@ -18,6 +23,16 @@ func (e *RequestError) Unwrap() error {
 	return e.Err
 }
 
+// RequestID returns the id sent with the failed request if the client is configured with WithRequestIDFunc,
+// or the empty string. Use it to find the request in the server logs.
+func (e *RequestError) RequestID() string {
+	var idErr *requestIDError
+	if errors.As(e.Err, &idErr) {
+		return idErr.requestID
+	}
+	return ""
+}
+
 // AuthenticationError is an error that occurs when there is an issue
 // authenticating with the server.
 // Check the inner error for more details.
@@ -65,3 +80,158 @@ func (e *ParameterError) Error() string {
 func (e *ParameterError) Unwrap() error {
 	return e.Err
 }
+
+// BatchError is an error that occurs when one batch of a batched upload fails.
+// Batch is the 1-based index of the failed batch out of Batches. EntitiesProcessed is the number of
+// entities stored by the batches that completed before the failure, they are not rolled back.
+// When batches are uploaded concurrently, see WithUploadConcurrency, the completed batches are not necessarily the
+// ones before Batch: batches after it may already have been committed and batches before it may have been aborted.
+// Check the inner error for more details.
+type BatchError struct {
+	Err               error
+	Msg               string
+	Batch             int
+	Batches           int
+	EntitiesProcessed int
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%s: batch %d of %d failed after %d entities were processed: %v", e.Msg, e.Batch, e.Batches, e.EntitiesProcessed, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// BulkError is an error that occurs when some items of a bulk operation fail.
+// Failed is the number of items that failed out of Total, the other items succeeded.
+// Check the inner error for the joined errors of the failed items.
+type BulkError struct {
+	Err    error
+	Msg    string
+	Failed int
+	Total  int
+}
+
+func (e *BulkError) Error() string {
+	return fmt.Sprintf("%s: %d of %d failed: %v", e.Msg, e.Failed, e.Total, e.Err)
+}
+
+func (e *BulkError) Unwrap() error {
+	return e.Err
+}
+
+// JobError is an error that occurs when a job run records an error on the server.
+// JobID is the id of the job.
+// Check the inner error for the error recorded by the run.
+type JobError struct {
+	Err   error
+	Msg   string
+	JobID string
+}
+
+func (e *JobError) Error() string {
+	return fmt.Sprintf("%s: job %s: %v", e.Msg, e.JobID, e.Err)
+}
+
+func (e *JobError) Unwrap() error {
+	return e.Err
+}
+
+// ConflictError is an error that occurs when the server rejects a conditional request because the resource
+// changed since the version the caller sent, for example when another writer stored entities in the dataset.
+// It is also in the chain of the RequestError of any request the server rejects with status 409, for example
+// when a job or dataset already exists, use errors.As to find it.
+// Check the inner error for more details.
+type ConflictError struct {
+	Err error
+	Msg string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Msg, e.Err)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+// NotFoundError is an error that occurs when the requested resource does not exist on the server.
+// Check the inner error for more details, it is nil if the absence was detected by the client.
+type NotFoundError struct {
+	Err error
+	Msg string
+}
+
+func (e *NotFoundError) Error() string {
+	if e.Err == nil {
+		return e.Msg
+	}
+	return fmt.Sprintf("%s: %v", e.Msg, e.Err)
+}
+
+func (e *NotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// ForbiddenError is an error that occurs when the server rejects a request with status 403 because the client is
+// authenticated but not permitted to make it, for example because of the access control rules of its client id.
+// Authenticating again does not help, unlike for an AuthenticationError. It is in the chain of the RequestError
+// of the request, use errors.As to find it.
+// Check the inner error for more details.
+type ForbiddenError struct {
+	Err error
+	Msg string
+}
+
+func (e *ForbiddenError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Msg, e.Err)
+}
+
+func (e *ForbiddenError) Unwrap() error {
+	return e.Err
+}
+
+// ServerError is the structured error returned by the data hub in the body of a failed response.
+// StatusCode is the http status of the response, Code is the machine readable error code if the server sent one
+// and Message is the error message. Use errors.As on a RequestError to read it, it is only set if the response
+// body is JSON with a code or message field.
+type ServerError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *ServerError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("server error %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("server error %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// parseServerError parses a response body into a ServerError.
+// returns nil if the body is not a JSON object with a code, message or error field.
+func parseServerError(statusCode int, body []byte) *ServerError {
+	payload := map[string]any{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil
+	}
+
+	serverErr := &ServerError{StatusCode: statusCode}
+	switch code := payload["code"].(type) {
+	case string:
+		serverErr.Code = code
+	case float64:
+		serverErr.Code = strconv.FormatFloat(code, 'f', -1, 64)
+	}
+	if message, ok := payload["message"].(string); ok {
+		serverErr.Message = message
+	} else if message, ok := payload["error"].(string); ok {
+		serverErr.Message = message
+	}
+
+	if serverErr.Code == "" && serverErr.Message == "" {
+		return nil
+	}
+	return serverErr
+}
@@ -8,6 +8,7 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/mimiro-io/entity-graph-data-model v0.7.9
 	golang.org/x/oauth2 v0.24.0
+	golang.org/x/time v0.8.0
 )
 
 require (
@@ -2,12 +2,18 @@ package datahub
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"regexp"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"golang.org/x/time/rate"
 )
 
 func newHttpClient(server string, accessToken string) *httpClient {
@@ -15,6 +21,7 @@ func newHttpClient(server string, accessToken string) *httpClient {
 	client.server = server
 	client.accessToken = accessToken
 	client.timeout = 0
+	client.logger = noopLogger{}
 	return client
 }
 
@@ -23,16 +30,372 @@ func (client *httpClient) withTimeout(seconds int) *httpClient {
 	return client
 }
 
+func (client *httpClient) withTransport(transport http.RoundTripper) *httpClient {
+	client.transport = transport
+	return client
+}
+
+func (client *httpClient) withBasicAuth(username string, password string) *httpClient {
+	client.basicAuthUser = username
+	client.basicAuthPassword = password
+	client.useBasicAuth = true
+	return client
+}
+
+func (client *httpClient) withLogger(logger Logger) *httpClient {
+	client.logger = logger
+	return client
+}
+
+func (client *httpClient) withObserver(observer RequestObserver) *httpClient {
+	client.observer = observer
+	return client
+}
+
+func (client *httpClient) withHeaders(headers map[string]string) *httpClient {
+	client.headers = headers
+	return client
+}
+
+// setHeaders sets the client headers and then the request headers, so that request headers take precedence.
+func (client *httpClient) setHeaders(req *http.Request, headers map[string]string) {
+	for key, val := range client.headers {
+		req.Header.Set(key, val)
+	}
+	for key, val := range headers {
+		req.Header.Set(key, val)
+	}
+}
+
+func (client *httpClient) withRequestIDFunc(requestIDFunc func() string, header string) *httpClient {
+	client.requestIDFunc = requestIDFunc
+	client.requestIDHeader = header
+	return client
+}
+
+func (client *httpClient) withRateLimiter(rateLimiter *rate.Limiter) *httpClient {
+	client.rateLimiter = rateLimiter
+	return client
+}
+
+func (client *httpClient) withMaxResponseSize(maxResponseSize int64) *httpClient {
+	client.maxResponseSize = maxResponseSize
+	return client
+}
+
+func (client *httpClient) withDryRun(capture func(method string, path string, body []byte)) *httpClient {
+	client.dryRun = capture
+	return client
+}
+
+func (client *httpClient) withRequestLogger(requestLogger func(entry LogEntry), maxBodySize int) *httpClient {
+	client.requestLogger = requestLogger
+	client.requestLogBodySize = maxBodySize
+	return client
+}
+
 func (client *httpClient) withUserAgent(userAgent string) *httpClient {
 	client.userAgent = userAgent
 	return client
 }
 
+// do sends the request and logs the outcome and the time taken to receive the response headers.
+// If an observer is configured it is notified once the response body has been read to the end or closed.
+// bytesSent returns the size of the request body sent so far.
+// If a rate limiter is configured the request waits for it before it is sent.
+// If a request id function is configured the generated id is sent in the request id header, logged and
+// passed to the observer. Errors are wrapped with the request id, see withRequestID.
+// In dry run mode the request is passed to the capture function instead of being sent, see dryRunResponse.
+func (client *httpClient) do(c http.Client, req *http.Request, method httpVerb, path string, bytesSent func() int64) (*http.Response, error) {
+	requestID := ""
+	logID := ""
+	if client.requestIDFunc != nil {
+		requestID = client.requestIDFunc()
+		req.Header.Set(client.requestIDHeader, requestID)
+		logID = " request id " + requestID
+	}
+
+	if client.dryRun != nil {
+		return client.dryRunResponse(req, method, path)
+	}
+
+	if client.rateLimiter != nil {
+		err := client.rateLimiter.Wait(req.Context())
+		if err != nil {
+			return nil, client.withRequestID(req, fmt.Errorf("waiting for rate limit: %w", err))
+		}
+	}
+
+	var requestBody, responseBody *cappedBuffer
+	if client.requestLogger != nil && client.requestLogBodySize > 0 {
+		requestBody = &cappedBuffer{max: client.requestLogBodySize}
+		responseBody = &cappedBuffer{max: client.requestLogBodySize}
+		if req.Body != nil {
+			req.Body = &teeBody{Reader: io.TeeReader(req.Body, requestBody), Closer: req.Body}
+		}
+	}
+
+	start := time.Now()
+	resp, err := c.Do(req)
+	if err != nil {
+		client.logger.Errorf("%s %s%s failed after %s: %v", method, path, logID, time.Since(start), err)
+		if client.observer != nil {
+			client.observer.ObserveRequest(RequestEvent{Method: string(method), Path: path, RequestID: requestID,
+				BytesSent: bytesSent(), Duration: time.Since(start), Err: err})
+		}
+		if client.requestLogger != nil {
+			client.requestLogger(newLogEntry(req, requestID, 0, time.Since(start), requestBody, nil, err))
+		}
+		return nil, client.withRequestID(req, err)
+	}
+
+	client.logger.Debugf("%s %s%s %d %s", method, path, logID, resp.StatusCode, time.Since(start))
+	if client.observer != nil || client.requestLogger != nil {
+		body := &observedBody{ReadCloser: resp.Body, done: func(received int64, err error) {
+			if client.observer != nil {
+				client.observer.ObserveRequest(RequestEvent{Method: string(method), Path: path, RequestID: requestID,
+					StatusCode: resp.StatusCode, BytesSent: bytesSent(), BytesReceived: received, Duration: time.Since(start), Err: err})
+			}
+			if client.requestLogger != nil {
+				client.requestLogger(newLogEntry(req, requestID, resp.StatusCode, time.Since(start), requestBody, responseBody, err))
+			}
+		}}
+		if responseBody != nil {
+			body.capture = responseBody
+		}
+		resp.Body = body
+	}
+	return resp, nil
+}
+
+// dryRunResponse reads the request body, passes the request to the capture function and returns an empty
+// response with status 200 without sending the request.
+func (client *httpClient) dryRunResponse(req *http.Request, method httpVerb, path string) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, client.withRequestID(req, fmt.Errorf("reading dry run request body: %w", err))
+		}
+	}
+
+	client.logger.Debugf("%s %s dry run", method, path)
+	client.dryRun(string(method), path, body)
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       io.NopCloser(bytes.NewReader(nil)),
+		Request:    req,
+	}, nil
+}
+
+// requestIDError carries the id of the failed request, see RequestError.RequestID
+type requestIDError struct {
+	requestID string
+	err       error
+}
+
+func (e *requestIDError) Error() string {
+	return fmt.Sprintf("request id %s: %v", e.requestID, e.err)
+}
+
+func (e *requestIDError) Unwrap() error {
+	return e.err
+}
+
+// withRequestID wraps err with the id sent in the request id header, if any
+func (client *httpClient) withRequestID(req *http.Request, err error) error {
+	if client.requestIDFunc == nil {
+		return err
+	}
+	return &requestIDError{requestID: req.Header.Get(client.requestIDHeader), err: err}
+}
+
+// countingWriter counts the bytes written to the underlying writer and records whether a write failed
+type countingWriter struct {
+	writer io.Writer
+	count  atomic.Int64
+	failed atomic.Bool
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.count.Add(int64(n))
+	if err != nil {
+		w.failed.Store(true)
+	}
+	return n, err
+}
+
+// observedBody counts the bytes read from a response body and calls done once, at the end of the body or on Close.
+// If capture is set the bytes read are also written to it.
+type observedBody struct {
+	io.ReadCloser
+	received int64
+	capture  io.Writer
+	once     sync.Once
+	done     func(received int64, err error)
+}
+
+func (b *observedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.received += int64(n)
+	if b.capture != nil && n > 0 {
+		_, _ = b.capture.Write(p[:n])
+	}
+	if err == io.EOF {
+		b.once.Do(func() { b.done(b.received, nil) })
+	} else if err != nil {
+		b.once.Do(func() { b.done(b.received, err) })
+	}
+	return n, err
+}
+
+func (b *observedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(b.received, nil) })
+	return err
+}
+
+// teeBody is a request body that copies what is read to a cappedBuffer for the request logger
+type teeBody struct {
+	io.Reader
+	io.Closer
+}
+
+// cappedBuffer keeps the first max bytes written to it and discards the rest
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if remaining := b.max - b.buf.Len(); remaining < len(p) {
+		b.truncated = true
+		b.buf.Write(p[:max(remaining, 0)])
+	} else {
+		b.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// logBody returns the kept bytes with credentials redacted and ... appended if bytes were discarded,
+// or the empty string for a nil buffer
+func (b *cappedBuffer) logBody() string {
+	if b == nil {
+		return ""
+	}
+	body := sensitiveJSONField.ReplaceAllString(b.buf.String(), `${1}"`+redacted+`"`)
+	if b.truncated {
+		return body + "..."
+	}
+	return body
+}
+
+// redacted is the value logged in place of credentials
+const redacted = "REDACTED"
+
+// sensitiveName matches the names of headers, query parameters and JSON fields that carry credentials
+var sensitiveName = regexp.MustCompile(`(?i)authorization|cookie|token|secret|password|api-?key`)
+
+// sensitiveJSONField matches JSON fields with a sensitive name and a string value or a flat object value,
+// such as the ValueReader of a token provider secret. The value may be cut off by the end of a truncated body.
+// Continuation tokens are not credentials and are kept.
+var sensitiveJSONField = regexp.MustCompile(`("[^"]*(?i:access_token|refresh_token|id_token|secret|password|api-?key)[^"]*"\s*:\s*)("(?:[^"\\]|\\.)*"?|\{[^{}]*\}?)`)
+
+// newLogEntry creates the entry for the request logger with credentials in headers, query and bodies redacted
+func newLogEntry(req *http.Request, requestID string, statusCode int, duration time.Duration, requestBody *cappedBuffer, responseBody *cappedBuffer, err error) LogEntry {
+	header := req.Header.Clone()
+	for key := range header {
+		if sensitiveName.MatchString(key) {
+			header[key] = []string{redacted}
+		}
+	}
+
+	logURL := *req.URL
+	if logURL.RawQuery != "" {
+		query := logURL.Query()
+		for key := range query {
+			if sensitiveName.MatchString(key) {
+				query[key] = []string{redacted}
+			}
+		}
+		logURL.RawQuery = query.Encode()
+	}
+
+	return LogEntry{
+		Method:        req.Method,
+		URL:           logURL.Redacted(),
+		StatusCode:    statusCode,
+		Duration:      duration,
+		RequestID:     requestID,
+		RequestHeader: header,
+		RequestBody:   requestBody.logBody(),
+		ResponseBody:  responseBody.logBody(),
+		Err:           err,
+	}
+}
+
+// httpStatusError is returned for responses with a status other than 200 or 201.
+// body is the response body if it was read, serverErr is the body parsed as a ServerError if possible.
+type httpStatusError struct {
+	statusCode int
+	status     string
+	body       string
+	serverErr  *ServerError
+}
+
+// newStatusError returns the error for a response with a status other than 200 or 201 and the body read from it.
+// Statuses callers handle differently are wrapped in their error type, so that they can be found with errors.As.
+func newStatusError(resp *http.Response, body []byte) error {
+	statusErr := &httpStatusError{statusCode: resp.StatusCode, status: resp.Status, body: string(body),
+		serverErr: parseServerError(resp.StatusCode, body)}
+	switch resp.StatusCode {
+	case http.StatusForbidden:
+		return &ForbiddenError{Msg: "not permitted", Err: statusErr}
+	case http.StatusConflict:
+		return &ConflictError{Msg: "conflict with the current state of the resource", Err: statusErr}
+	}
+	return statusErr
+}
+
+func (e *httpStatusError) Unwrap() error {
+	if e.serverErr == nil {
+		return nil
+	}
+	return e.serverErr
+}
+
+func (e *httpStatusError) Error() string {
+	if e.body == "" {
+		return "error in request http status " + e.status
+	}
+	return "error in request http status " + e.status + " : " + e.body
+}
+
 type httpClient struct {
-	userAgent   string
-	server      string
-	accessToken string
-	timeout     time.Duration
+	userAgent          string
+	server             string
+	accessToken        string
+	timeout            time.Duration
+	useBasicAuth       bool
+	basicAuthUser      string
+	basicAuthPassword  string
+	transport          http.RoundTripper
+	headers            map[string]string
+	requestIDFunc      func() string
+	requestIDHeader    string
+	rateLimiter        *rate.Limiter
+	maxResponseSize    int64
+	dryRun             func(method string, path string, body []byte)
+	requestLogger      func(entry LogEntry)
+	requestLogBodySize int
+	logger             Logger
+	observer           RequestObserver
 }
 
 type httpVerb string
@@ -44,27 +407,64 @@ const (
 	httpDelete httpVerb = "DELETE"
 )
 
-func (client *httpClient) makeRequest(method httpVerb, path string, content []byte, headers map[string]string, queryParams map[string]string) ([]byte, error) {
-	resp, err := client.makeStreamingRequest(method, path, content, headers, queryParams)
+// joinURL joins the path to the base url. A path prefix of the base url, such as /datahub when the data hub is
+// deployed behind a proxy at https://host/datahub, is kept and slashes between the two are not doubled.
+// path may contain escaped characters such as %2F, they are not escaped again.
+func joinURL(base string, path string) (*url.URL, error) {
+	baseURL, err := url.Parse(base)
 	if err != nil {
 		return nil, err
 	}
+	return baseURL.JoinPath(path), nil
+}
+
+func (client *httpClient) makeRequest(method httpVerb, path string, content []byte, headers map[string]string, queryParams map[string]string) ([]byte, error) {
+	bodyBytes, _, err := client.makeRequestWithHeader(method, path, content, headers, queryParams)
+	return bodyBytes, err
+}
+
+// makeRequestWithHeader makes the request as makeRequest does and also returns the response headers.
+func (client *httpClient) makeRequestWithHeader(method httpVerb, path string, content []byte, headers map[string]string, queryParams map[string]string) ([]byte, http.Header, error) {
+	resp, err := client.sendRequest(method, path, content, headers, queryParams)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	defer func() {
-		_ = resp.Close()
+		_ = resp.Body.Close()
 	}()
 
-	bodyBytes, err := io.ReadAll(resp)
+	if client.maxResponseSize > 0 {
+		bodyBytes, err := io.ReadAll(io.LimitReader(resp.Body, client.maxResponseSize+1))
+		if err != nil {
+			return nil, nil, err
+		}
+		if int64(len(bodyBytes)) > client.maxResponseSize {
+			return nil, nil, &ClientProcessingError{Msg: "response too large",
+				Err: fmt.Errorf("response of %s %s exceeds the maximum size of %d bytes", method, path, client.maxResponseSize)}
+		}
+		return bodyBytes, resp.Header, nil
+	}
+
+	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return bodyBytes, nil
+	return bodyBytes, resp.Header, nil
 }
 
 func (client *httpClient) makeStreamingRequest(method httpVerb, path string, content []byte, headers map[string]string, queryParams map[string]string) (io.ReadCloser, error) {
-	baseURL := fmt.Sprintf("%s%s", client.server, path)
-	parsedURL, err := url.Parse(baseURL)
+	resp, err := client.sendRequest(method, path, content, headers, queryParams)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// sendRequest sends the request and returns the response if the status is 200 or 201, the caller must close the body.
+func (client *httpClient) sendRequest(method httpVerb, path string, content []byte, headers map[string]string, queryParams map[string]string) (*http.Response, error) {
+	parsedURL, err := joinURL(client.server, path)
 	if err != nil {
 		return nil, err
 	}
@@ -86,38 +486,43 @@ func (client *httpClient) makeStreamingRequest(method httpVerb, path string, con
 		return nil, err
 	}
 
-	if client.accessToken != "" {
+	if client.useBasicAuth {
+		req.SetBasicAuth(client.basicAuthUser, client.basicAuthPassword)
+	} else if client.accessToken != "" {
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", client.accessToken))
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", client.userAgent)
-	if headers != nil {
-		for key, val := range headers {
-			req.Header.Set(key, val)
-		}
-	}
+	client.setHeaders(req, headers)
 
 	c := http.Client{
-		Timeout: client.timeout,
+		Timeout:   client.timeout,
+		Transport: client.transport,
 	}
 
-	resp, err := c.Do(req)
+	resp, err := client.do(c, req, method, path, func() int64 { return int64(len(content)) })
 	if err != nil {
 		return nil, err
 	}
 
 	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
-		return resp.Body, nil
+		return resp, nil
 	} else {
+		defer resp.Body.Close()
 		msg, _ := io.ReadAll(resp.Body)
-		return nil, errors.New("error in request http status " + resp.Status + " : " + string(msg))
+		return nil, client.withRequestID(req, newStatusError(resp, msg))
 	}
 }
 
 func (client *httpClient) makeStreamingWriterRequest(method httpVerb, path string, writeBody func(writer io.Writer) error, headers map[string]string, queryParams map[string]string) (io.ReadCloser, error) {
-	baseURL := fmt.Sprintf("%s%s", client.server, path)
-	parsedURL, err := url.Parse(baseURL)
+	return client.makeStreamingWriterRequestContext(context.Background(), method, path, writeBody, headers, queryParams)
+}
+
+// makeStreamingWriterRequestContext makes the request as makeStreamingWriterRequest does, bound to ctx.
+// Cancelling ctx aborts the request, the pipe to writeBody is then closed so that its writes fail.
+func (client *httpClient) makeStreamingWriterRequestContext(ctx context.Context, method httpVerb, path string, writeBody func(writer io.Writer) error, headers map[string]string, queryParams map[string]string) (io.ReadCloser, error) {
+	parsedURL, err := joinURL(client.server, path)
 	if err != nil {
 		return nil, err
 	}
@@ -135,42 +540,82 @@ func (client *httpClient) makeStreamingWriterRequest(method httpVerb, path strin
 	fullUrl := parsedURL.String()
 
 	reader, writer := io.Pipe()
-	req, err := http.NewRequest(string(method), fullUrl, reader)
+	req, err := http.NewRequestWithContext(ctx, string(method), fullUrl, reader)
 	if err != nil {
 		return nil, err
 	}
 
-	if client.accessToken != "" {
+	if client.useBasicAuth {
+		req.SetBasicAuth(client.basicAuthUser, client.basicAuthPassword)
+	} else if client.accessToken != "" {
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", client.accessToken))
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", client.userAgent)
-
-	if headers != nil {
-		for key, val := range headers {
-			req.Header.Set(key, val)
-		}
-	}
+	client.setHeaders(req, headers)
 
 	c := http.Client{
-		Timeout: client.timeout,
+		Timeout:   client.timeout,
+		Transport: client.transport,
 	}
 
+	// the error of writeBody is sent before the pipe is closed with it, so it is available once the request
+	// fails because of it. Closing the pipe with the error aborts the request instead of sending a truncated body.
+	writeErr := make(chan error, 1)
+	bodyWriter := &countingWriter{writer: writer}
 	go func() {
-		defer writer.Close()
-		writeBody(writer)
+		err := writeBody(bodyWriter)
+		// an error caused by a failed write to the pipe is the consequence of the request failing, not its cause
+		if !bodyWriter.failed.Load() {
+			writeErr <- err
+		}
+		_ = writer.CloseWithError(err)
 	}()
 
-	resp, err := c.Do(req)
+	resp, err := client.do(c, req, method, path, bodyWriter.count.Load)
 	if err != nil {
+		if bodyErr := writeBodyError(writeErr); bodyErr != nil {
+			return nil, client.withRequestID(req, bodyErr)
+		}
+		// the body may not have been read to the end, closing the reader unblocks writeBody
+		_ = reader.CloseWithError(err)
 		return nil, err
 	}
 
+	if bodyErr := writeBodyError(writeErr); bodyErr != nil {
+		_ = resp.Body.Close()
+		return nil, client.withRequestID(req, bodyErr)
+	}
+
 	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
 		return resp.Body, nil
 	} else {
-		resp.Body.Close()
-		return nil, errors.New("error in request http status " + resp.Status)
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(resp.Body)
+		err := client.withRequestID(req, newStatusError(resp, msg))
+		_ = reader.CloseWithError(err)
+		return nil, err
+	}
+}
+
+// isConflict returns true if the request failed with status 409 or 412, which the server returns for a
+// conditional request when the resource has changed.
+func isConflict(err error) bool {
+	var statusErr *httpStatusError
+	return errors.As(err, &statusErr) &&
+		(statusErr.statusCode == http.StatusConflict || statusErr.statusCode == http.StatusPreconditionFailed)
+}
+
+// writeBodyError returns the error of the body writer of a streaming writer request if it has failed,
+// without waiting for it to finish.
+func writeBodyError(writeErr <-chan error) error {
+	select {
+	case err := <-writeErr:
+		if err != nil {
+			return fmt.Errorf("writing request body: %w", err)
+		}
+	default:
 	}
+	return nil
 }
@@ -1,10 +1,17 @@
 package datahub
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/url"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
 )
 
 type Transform struct {
@@ -23,6 +30,12 @@ func NewJavascriptTransform(code string, parallelism int) *Transform {
 	return transform
 }
 
+// NewJavascriptTransformFromSource creates a new JavascriptTransform from plain javascript source.
+// The source is base64 encoded as required by the data hub
+func NewJavascriptTransformFromSource(src string, parallelism int) *Transform {
+	return NewJavascriptTransform(EncodeJavascript(src), parallelism)
+}
+
 // JobTrigger represents a trigger for a job
 // TriggerType can be cron or onchange
 // JobType can be incremental or fullsync
@@ -214,6 +227,20 @@ func (jb *JobBuilder) WithDatasetSource(name string, latestOnly bool) *JobBuilde
 	return jb
 }
 
+// WithDatasetSourceSince adds a dataset source to the job that starts from the since token instead of the
+// beginning of the dataset on the first incremental run, for example when a job is migrated and should not
+// reprocess the changes that were already processed. Use ResetJobSinceToken to move an existing job instead.
+// name is the name of the dataset
+// since is the since token to start from, an empty token starts from the beginning as WithDatasetSource does
+// latestOnly is a flag to indicate whether only the latest version of the entities should be used
+func (jb *JobBuilder) WithDatasetSourceSince(name string, since string, latestOnly bool) *JobBuilder {
+	jb.WithDatasetSource(name, latestOnly)
+	if since != "" {
+		jb.job.Source["Since"] = since
+	}
+	return jb
+}
+
 // WithHttpSource adds an http source to the job
 // url is the url to the source
 // latestOnly is a flag to indicate whether only the latest version of the entities should be used
@@ -229,7 +256,8 @@ func (jb *JobBuilder) WithHttpSource(url string, latestOnly bool) *JobBuilder {
 // WithSecureHttpSource adds a secure http source to the job
 // url is the url to the source
 // latestOnly is a flag to indicate whether only the latest version of the entities should be used
-// tokenProvider is the name of the token provider to use
+// tokenProvider is the name of the token provider to use, it is not checked when the job is added and the job
+// fails when it runs if the provider does not exist. Use Client.CheckJobTokenProviders to check it before adding the job.
 func (jb *JobBuilder) WithSecureHttpSource(url string, latestOnly bool, tokenProvider string) *JobBuilder {
 	jb.job.Source = map[string]interface{}{
 		"Type":          "HttpDatasetSource",
@@ -240,6 +268,19 @@ func (jb *JobBuilder) WithSecureHttpSource(url string, latestOnly bool, tokenPro
 	return jb
 }
 
+// WithHttpSourceHeaders adds an http source to the job that sends static headers, such as an api key, with
+// each request instead of using a token provider.
+// url is the url to the source
+// headers are the headers sent to the source
+func (jb *JobBuilder) WithHttpSourceHeaders(url string, headers map[string]string) *JobBuilder {
+	jb.job.Source = map[string]interface{}{
+		"Type":    "HttpDatasetSource",
+		"Url":     url,
+		"Headers": headers,
+	}
+	return jb
+}
+
 // WithDatasetSink adds a dataset sink to the job
 // name is the name of the dataset
 func (jb *JobBuilder) WithDatasetSink(name string) *JobBuilder {
@@ -262,7 +303,8 @@ func (jb *JobBuilder) WithHttpSink(url string) *JobBuilder {
 
 // WithSecureHttpSink adds a secure http sink to the job
 // url is the url to the sink
-// tokenProvider is the name of the token provider to use
+// tokenProvider is the name of the token provider to use, it is not checked when the job is added and the job
+// fails when it runs if the provider does not exist. Use Client.CheckJobTokenProviders to check it before adding the job.
 func (jb *JobBuilder) WithSecureHttpSink(url string, tokenProvider string) *JobBuilder {
 	jb.job.Sink = map[string]interface{}{
 		"Type":          "HttpDatasetSink",
@@ -335,18 +377,68 @@ func (c *Client) AddJob(job *Job) error {
 	return nil
 }
 
+// CheckJobTokenProviders checks that the token providers referenced by the source and sink of the job exist on
+// the data hub, the data hub does not check them when the job is added.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the job is nil.
+// returns a NotFoundError if a token provider does not exist.
+// returns a RequestError if the request fails.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) CheckJobTokenProviders(job *Job) error {
+	if job == nil {
+		return &ParameterError{Msg: "job cannot be nil"}
+	}
+
+	for _, config := range []map[string]interface{}{job.Source, job.Sink} {
+		name, _ := config["TokenProvider"].(string)
+		if name == "" {
+			continue
+		}
+
+		provider, err := c.GetTokenProvider(name)
+		if err != nil {
+			var statusErr *httpStatusError
+			if errors.As(err, &statusErr) && statusErr.statusCode == http.StatusNotFound {
+				return &NotFoundError{Msg: fmt.Sprintf("token provider %s of job %s not found", name, job.Id), Err: err}
+			}
+			return err
+		}
+		if provider.Name == "" {
+			return &NotFoundError{Msg: fmt.Sprintf("token provider %s of job %s not found", name, job.Id)}
+		}
+	}
+
+	return nil
+}
+
+// AddJobs adds each job to the data hub, continuing after a job fails to be added.
+// returns a slice with the error of each job in the same order as jobs, nil where the job was added.
+// returns a BulkError if any job failed, the per job errors are the errors of AddJob.
+func (c *Client) AddJobs(jobs []*Job) ([]error, error) {
+	errs := make([]error, len(jobs))
+	for i, job := range jobs {
+		errs[i] = c.AddJob(job)
+	}
+	return errs, bulkError("unable to add jobs", errs)
+}
+
 // GetJobs gets a list of jobs from the data hub
 // returns an AuthenticationError if the client is unable to authenticate.
 // returns a RequestError if the request fails.
 // returns a ClientProcessingError if the response cannot be processed.
 func (c *Client) GetJobs() ([]*Job, error) {
+	return c.getJobs(nil)
+}
+
+// getJobs gets the jobs from the data hub, sending the query parameters with the request
+func (c *Client) getJobs(queryParams map[string]string) ([]*Job, error) {
 	err := c.checkToken()
 	if err != nil {
 		return nil, &AuthenticationError{Msg: "unable to authenticate", Err: err}
 	}
 
 	client := c.makeHttpClient()
-	data, err := client.makeRequest(httpGet, "/jobs", nil, nil, nil)
+	data, err := client.makeRequest(httpGet, "/jobs", nil, nil, queryParams)
 	if err != nil {
 		return nil, &RequestError{Msg: "unable to get jobs", Err: err}
 	}
@@ -384,6 +476,70 @@ func (c *Client) DeleteJob(id string) error {
 	return nil
 }
 
+// DeleteJobs deletes each job from the data hub, continuing after a job fails to be deleted.
+// ids are the ids of the jobs to delete
+// returns a slice with the error of each id in the same order as ids, nil where the job was deleted.
+// returns a BulkError if any job failed, the per job errors are the errors of DeleteJob.
+func (c *Client) DeleteJobs(ids []string) ([]error, error) {
+	errs := make([]error, len(ids))
+	for i, id := range ids {
+		errs[i] = c.DeleteJob(id)
+	}
+	return errs, bulkError("unable to delete jobs", errs)
+}
+
+// DeleteJobsByFilter deletes all jobs matching the filter from the data hub, continuing after a job fails to be
+// deleted. To protect against deleting all jobs by mistake the filter must have at least one predicate.
+// filter selects the jobs to delete, see NewJobsFilter
+// returns the number of jobs deleted.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the filter is nil, has no predicates or has a duration or time that cannot be parsed.
+// returns a RequestError if the jobs cannot be listed.
+// returns a BulkError if any matching job failed to be deleted, the per job errors are the errors of DeleteJob.
+func (c *Client) DeleteJobsByFilter(filter *jobsFilter) (int, error) {
+	if filter == nil || filter.isEmpty() {
+		return 0, &ParameterError{Msg: "jobs filter must have at least one predicate"}
+	}
+
+	err := filter.validate()
+	if err != nil {
+		return 0, err
+	}
+
+	jobs, err := c.getJobsByFilter(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	ids := make([]string, len(jobs))
+	for i, job := range jobs {
+		ids[i] = job.Id
+	}
+
+	errs, err := c.DeleteJobs(ids)
+	deleted := 0
+	for _, jobErr := range errs {
+		if jobErr == nil {
+			deleted++
+		}
+	}
+	return deleted, err
+}
+
+// bulkError returns a BulkError joining the non nil errors, or nil if there are none.
+func bulkError(msg string, errs []error) error {
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	return &BulkError{Msg: msg, Err: errors.Join(errs...), Failed: failed, Total: len(errs)}
+}
+
 // GetJob gets a job from the data hub
 // id is the id of the job to get
 // returns an AuthenticationError if the client is unable to authenticate.
@@ -415,6 +571,56 @@ func (c *Client) GetJob(id string) (*Job, error) {
 	return job, nil
 }
 
+// JobExists checks if a job exists in the data hub
+// id is the id of the job to check
+// returns true if the job exists and false if it does not.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the job id is empty.
+// returns a RequestError if the request fails for any other reason than the job not existing.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) JobExists(id string) (bool, error) {
+	job, err := c.GetJob(id)
+	if err != nil {
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) && statusErr.statusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return job != nil, nil
+}
+
+// GetJobByTitle gets the job with the exact title from the data hub
+// title is the title of the job to get
+// returns the job, or nil if no job has the title.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the title is empty or more than one job has the title.
+// returns a RequestError if the request fails.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) GetJobByTitle(title string) (*Job, error) {
+	if title == "" {
+		return nil, &ParameterError{Msg: "title cannot be empty"}
+	}
+
+	jobs, err := c.GetJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	var found *Job
+	for _, job := range jobs {
+		if job == nil || job.Title != title {
+			continue
+		}
+		if found != nil {
+			return nil, &ParameterError{Msg: "title is ambiguous", Err: fmt.Errorf("jobs %s and %s both have the title %s", found.Id, job.Id, title)}
+		}
+		found = job
+	}
+
+	return found, nil
+}
+
 // UpdateJob updates a job in the data hub
 // Use the JobBuilder to create valid jobs
 // returns an AuthenticationError if the client is unable to authenticate.
@@ -606,6 +812,44 @@ func (c *Client) ResumeJob(id string) error {
 	return nil
 }
 
+// PauseAllJobs pauses every job in the data hub that is not already paused, for example during maintenance.
+// returns the ids of the jobs it paused, pass them to ResumeAllJobs to resume only those jobs and leave jobs
+// that were paused on purpose paused. The ids are also returned on error.
+// returns a BulkError if any job failed to be paused, the per job errors are the errors of PauseJob.
+// returns the errors of GetJobs if the jobs cannot be listed.
+func (c *Client) PauseAllJobs() ([]string, error) {
+	jobs, err := c.GetJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	paused := make([]string, 0)
+	errs := make([]error, 0)
+	for _, job := range jobs {
+		if job == nil || job.Paused {
+			continue
+		}
+		err := c.PauseJob(job.Id)
+		if err == nil {
+			paused = append(paused, job.Id)
+		}
+		errs = append(errs, err)
+	}
+
+	return paused, bulkError("unable to pause jobs", errs)
+}
+
+// ResumeAllJobs resumes the jobs paused by PauseAllJobs.
+// ids are the ids returned by PauseAllJobs
+// returns a BulkError if any job failed to be resumed, the per job errors are the errors of ResumeJob.
+func (c *Client) ResumeAllJobs(ids []string) error {
+	errs := make([]error, len(ids))
+	for i, id := range ids {
+		errs[i] = c.ResumeJob(id)
+	}
+	return bulkError("unable to resume jobs", errs)
+}
+
 // RunJobAsIncremental runs a job as an incremental job
 // id is the id of the job to run
 // returns an AuthenticationError if the client is unable to authenticate.
@@ -680,27 +924,31 @@ func (c *Client) KillJob(id string) error {
 
 // ResetJobSinceToken resets the job since token
 // id is the id of the job to reset
-// token is the since token to reset to
+// token is the since token to reset to, an empty token resets the job to process from the beginning as ResetJob does
 // returns an AuthenticationError if the client is unable to authenticate.
-// returns a ParameterError if the job id is empty.
+// returns a ParameterError if the job id is empty or the token contains whitespace or control characters.
 // returns a RequestError if the request fails.
 func (c *Client) ResetJobSinceToken(id string, token string) error {
 	if id == "" {
 		return &ParameterError{Msg: "id cannot be empty"}
 	}
 
+	if strings.IndexFunc(token, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return &ParameterError{Msg: "since token is not valid", Err: fmt.Errorf("token %q contains whitespace or control characters", token)}
+	}
+
 	err := c.checkToken()
 	if err != nil {
 		return &AuthenticationError{Msg: "unable to authenticate", Err: err}
 	}
 
-	path := "/job/" + id + "/reset"
+	var queryParams map[string]string
 	if token != "" {
-		path += "?since=" + url.QueryEscape(token)
+		queryParams = map[string]string{"since": token}
 	}
 
 	client := c.makeHttpClient()
-	_, err = client.makeRequest(httpPut, path, nil, nil, nil)
+	_, err = client.makeRequest(httpPut, "/job/"+id+"/reset", nil, nil, queryParams)
 	if err != nil {
 		return &RequestError{Msg: "unable to reset job since token", Err: err}
 	}
@@ -708,8 +956,57 @@ func (c *Client) ResetJobSinceToken(id string, token string) error {
 	return nil
 }
 
+// ResetJob resets the job since token so that the next run of an incremental job processes its source from
+// the beginning.
+// id is the id of the job to reset
+// returns the errors of ResetJobSinceToken.
+func (c *Client) ResetJob(id string) error {
+	return c.ResetJobSinceToken(id, "")
+}
+
+// GetJobSinceToken gets the since token an incremental job has progressed to.
+// Together with GetDatasetChangesCount this shows how far a job is behind its source dataset.
+// id is the id of the job
+// returns the since token, or the empty string if the job has not stored a token yet.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the job id is empty.
+// returns a RequestError if the request fails.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) GetJobSinceToken(id string) (string, error) {
+	if id == "" {
+		return "", &ParameterError{Msg: "id cannot be empty"}
+	}
+
+	err := c.checkToken()
+	if err != nil {
+		return "", &AuthenticationError{Msg: "unable to authenticate", Err: err}
+	}
+
+	client := c.makeHttpClient()
+	data, err := client.makeRequest(httpGet, "/job/"+id+"/since", nil, nil, nil)
+	if err != nil {
+		return "", &RequestError{Msg: "unable to get job since token", Err: err}
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		return "", nil
+	}
+
+	since := &struct {
+		Token string `json:"token"`
+	}{}
+	err = json.Unmarshal(data, since)
+	if err != nil {
+		return "", &ClientProcessingError{Msg: "unable to unmarshal job since token", Err: err}
+	}
+
+	return since.Token, nil
+}
+
 // GetJobStatus gets the status of a job from the data hub
 // id is the id of the job to get the status for
+// returns the status of the running job, or nil and no error if the job is not running. Use IsJobRunning
+// to check if a job is running.
 // returns an AuthenticationError if the client is unable to authenticate.
 // returns a ParameterError if the job id is empty.
 // returns a RequestError if the request fails.
@@ -743,8 +1040,91 @@ func (c *Client) GetJobStatus(id string) (*JobStatus, error) {
 	return jobStatuses[0], nil
 }
 
+// IsJobRunning checks if a job is currently running in the data hub
+// id is the id of the job to check
+// returns true if the job is running and false if it is not, including if the job does not exist.
+// returns the errors of GetJobStatus.
+func (c *Client) IsJobRunning(id string) (bool, error) {
+	status, err := c.GetJobStatus(id)
+	if err != nil {
+		return false, err
+	}
+	return status != nil, nil
+}
+
+// WaitForJob waits for a run of a job started with RunJobAsFullSync or RunJobAsIncremental to complete and
+// returns its result from the job history. The job status is polled every pollInterval until the job is no
+// longer running and the job has a result in the history. Call it straight after starting the run.
+// The run may not have started when WaitForJob is called, so the result of an earlier run is only accepted once
+// the job has been seen running, otherwise only a result that started after WaitForJob was called is accepted.
+// returns the result of the run.
+// returns a JobError together with the result if the run recorded an error.
+// returns the context error if ctx is done before the run completes.
+// returns a ParameterError if the job id is empty or pollInterval is not positive.
+// returns the errors of GetJobStatus and GetJobsHistory.
+func (c *Client) WaitForJob(ctx context.Context, id string, pollInterval time.Duration) (*JobResult, error) {
+	if id == "" {
+		return nil, &ParameterError{Msg: "id cannot be empty"}
+	}
+
+	if pollInterval <= 0 {
+		return nil, &ParameterError{Msg: "poll interval must be positive"}
+	}
+
+	called := time.Now()
+	seenRunning := false
+	for {
+		running, err := c.IsJobRunning(id)
+		if err != nil {
+			return nil, err
+		}
+		seenRunning = seenRunning || running
+
+		if !running {
+			// the history is written when a run completes
+			result, err := c.latestJobResult(id)
+			if err != nil {
+				return nil, err
+			}
+
+			// until the job has been seen running the latest result may be the one of an earlier run
+			if result != nil && (seenRunning || !result.Start.Before(called)) {
+				if result.LastError != "" {
+					return result, &JobError{Msg: "job run failed", JobID: id, Err: errors.New(result.LastError)}
+				}
+				return result, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// latestJobResult returns the most recent run of the job in the job history, or nil if it has not run.
+func (c *Client) latestJobResult(id string) (*JobResult, error) {
+	results, err := c.GetJobsHistory()
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *JobResult
+	for _, result := range results {
+		if result != nil && result.ID == id && (latest == nil || result.Start.After(latest.Start)) {
+			latest = result
+		}
+	}
+	return latest, nil
+}
+
 // Jobs Filtering
-func newJobsFilter() *jobsFilter {
+
+// NewJobsFilter creates an empty jobs filter, use the Has and Is functions to add predicates.
+// A job matches the filter if it matches all of its predicates.
+func NewJobsFilter() *jobsFilter {
 	jf := &jobsFilter{}
 	jf.hasTags = make([]string, 0)
 	return jf
@@ -765,9 +1145,10 @@ func newJobsFilter() *jobsFilter {
 // lastrun<2020-11-19T14:56:17+01:00 or lastrun>2020-11-19T14:56:17+01:00
 // triggers=@every 60 or triggers=fullsync or triggers=person.Crm
 
-// jobsFilter structure used for filtering jobs when using the ListJobs function
+// jobsFilter structure used for filtering jobs with GetJobsFiltered and DeleteJobsByFilter
 type jobsFilter struct {
 	isPaused               bool
+	filterPaused           bool
 	hasTitle               string
 	hasTags                []string
 	hasId                  string
@@ -803,6 +1184,7 @@ func (jf *jobsFilter) HasId(id string) *jobsFilter {
 // IsPaused adds a paused filter to the jobsFilter
 func (jf *jobsFilter) IsPaused(paused bool) *jobsFilter {
 	jf.isPaused = paused
+	jf.filterPaused = true
 	return jf
 }
 
@@ -859,3 +1241,203 @@ func (jf *jobsFilter) HasTrigger(triggers string) *jobsFilter {
 	jf.hasTrigger = triggers
 	return jf
 }
+
+// isEmpty returns true if the filter has no predicates, so that it matches all jobs
+func (jf *jobsFilter) isEmpty() bool {
+	return jf.hasTitle == "" && len(jf.hasTags) == 0 && jf.hasId == "" && !jf.filterPaused && jf.hasSource == "" &&
+		jf.hasSink == "" && jf.hasTransform == "" && jf.hasTrigger == "" && !jf.needsHistory()
+}
+
+// needsHistory returns true if the filter has predicates on the last run of a job
+func (jf *jobsFilter) needsHistory() bool {
+	return jf.hasError != "" || jf.hasDurationGreaterThan != "" || jf.hasDurationLessThan != "" ||
+		jf.hasLastRunAfter != "" || jf.hasLastRunBefore != ""
+}
+
+// validate checks that the duration and time predicates can be parsed
+func (jf *jobsFilter) validate() error {
+	for _, duration := range []string{jf.hasDurationGreaterThan, jf.hasDurationLessThan} {
+		if duration == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(duration); err != nil {
+			return &ParameterError{Msg: "invalid duration in jobs filter", Err: err}
+		}
+	}
+	for _, lastRun := range []string{jf.hasLastRunAfter, jf.hasLastRunBefore} {
+		if lastRun == "" {
+			continue
+		}
+		if _, err := time.Parse(time.RFC3339, lastRun); err != nil {
+			return &ParameterError{Msg: "invalid last run time in jobs filter, expected RFC3339", Err: err}
+		}
+	}
+	return nil
+}
+
+// matches returns true if the job matches all predicates of the filter. lastRun is the most recent run of the
+// job, or nil if it has not run, a job without a run does not match predicates on the last run.
+// Title, id, error, source, sink, transform and trigger match case-insensitive substrings, for example source
+// "dataset" matches DatasetSource and trigger "fullsync" matches a full sync trigger. Tags match exactly.
+// The filter must be valid, see validate.
+func (jf *jobsFilter) matches(job *Job, lastRun *JobResult) bool {
+	if !containsFold(job.Title, jf.hasTitle) || !containsFold(job.Id, jf.hasId) {
+		return false
+	}
+
+	for _, tag := range jf.hasTags {
+		if !slices.Contains(job.Tags, tag) {
+			return false
+		}
+	}
+
+	if jf.filterPaused && job.Paused != jf.isPaused {
+		return false
+	}
+
+	if jf.hasSource != "" && !containsFold(fmt.Sprint(job.Source["Type"]), jf.hasSource) {
+		return false
+	}
+	if jf.hasSink != "" && !containsFold(fmt.Sprint(job.Sink["Type"]), jf.hasSink) {
+		return false
+	}
+	if jf.hasTransform != "" && (job.Transform == nil || !containsFold(job.Transform.Type, jf.hasTransform)) {
+		return false
+	}
+
+	if jf.hasTrigger != "" && !slices.ContainsFunc(job.Triggers, func(trigger *JobTrigger) bool {
+		return trigger != nil && (containsFold(trigger.TriggerType, jf.hasTrigger) || containsFold(trigger.JobType, jf.hasTrigger) ||
+			containsFold(trigger.Schedule, jf.hasTrigger) || containsFold(trigger.MonitoredDataset, jf.hasTrigger))
+	}) {
+		return false
+	}
+
+	if !jf.needsHistory() {
+		return true
+	}
+	if lastRun == nil || !containsFold(lastRun.LastError, jf.hasError) {
+		return false
+	}
+
+	duration := lastRun.End.Sub(lastRun.Start)
+	if jf.hasDurationGreaterThan != "" {
+		limit, _ := time.ParseDuration(jf.hasDurationGreaterThan)
+		if duration <= limit {
+			return false
+		}
+	}
+	if jf.hasDurationLessThan != "" {
+		limit, _ := time.ParseDuration(jf.hasDurationLessThan)
+		if duration >= limit {
+			return false
+		}
+	}
+	if jf.hasLastRunAfter != "" {
+		after, _ := time.Parse(time.RFC3339, jf.hasLastRunAfter)
+		if !lastRun.Start.After(after) {
+			return false
+		}
+	}
+	if jf.hasLastRunBefore != "" {
+		before, _ := time.Parse(time.RFC3339, jf.hasLastRunBefore)
+		if !lastRun.Start.Before(before) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsFold reports whether substr is within s, ignoring case. An empty substr is always contained.
+func containsFold(s string, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// queryParams returns the predicates on the job definition as query parameters for GET /jobs. Predicates on the
+// last run are not included, they are evaluated by the client.
+func (jf *jobsFilter) queryParams() map[string]string {
+	params := map[string]string{}
+	if jf.hasTitle != "" {
+		params["title"] = jf.hasTitle
+	}
+
+	if len(jf.hasTags) > 0 {
+		params["tags"] = strings.Join(jf.hasTags, ",")
+	}
+
+	if jf.hasId != "" {
+		params["id"] = jf.hasId
+	}
+
+	if jf.filterPaused {
+		params["paused"] = strconv.FormatBool(jf.isPaused)
+	}
+
+	if jf.hasSource != "" {
+		params["source"] = jf.hasSource
+	}
+
+	if jf.hasSink != "" {
+		params["sink"] = jf.hasSink
+	}
+
+	if jf.hasTransform != "" {
+		params["transform"] = jf.hasTransform
+	}
+
+	if jf.hasTrigger != "" {
+		params["triggers"] = jf.hasTrigger
+	}
+	return params
+}
+
+// GetJobsFiltered gets the jobs matching the filter from the data hub.
+// The predicates on the job definition, title, tags, id, paused, source, sink, transform and trigger, are sent as
+// query parameters so that a server that supports them filters the jobs before sending them. The predicates on
+// the last run, error, duration and last run time, are evaluated by the client using the jobs history. All
+// predicates are also evaluated by the client, so the result is the same for servers that ignore the parameters.
+// filter selects the jobs, see NewJobsFilter. A nil or empty filter gets all jobs.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the filter has a duration or time that cannot be parsed.
+// returns a RequestError if the request fails.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) GetJobsFiltered(filter *jobsFilter) ([]*Job, error) {
+	if filter == nil {
+		filter = NewJobsFilter()
+	}
+
+	err := filter.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.getJobsByFilter(filter)
+}
+
+// getJobsByFilter gets the jobs matching the filter, fetching the jobs history only if the filter needs it
+func (c *Client) getJobsByFilter(filter *jobsFilter) ([]*Job, error) {
+	jobs, err := c.getJobs(filter.queryParams())
+	if err != nil {
+		return nil, err
+	}
+
+	lastRuns := make(map[string]*JobResult)
+	if filter.needsHistory() {
+		results, err := c.GetJobsHistory()
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range results {
+			if result != nil && (lastRuns[result.ID] == nil || result.Start.After(lastRuns[result.ID].Start)) {
+				lastRuns[result.ID] = result
+			}
+		}
+	}
+
+	matching := make([]*Job, 0)
+	for _, job := range jobs {
+		if job != nil && filter.matches(job, lastRuns[job.Id]) {
+			matching = append(matching, job)
+		}
+	}
+	return matching, nil
+}
@@ -1,10 +1,18 @@
 package datahub
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"github.com/google/uuid"
 	egdm "github.com/mimiro-io/entity-graph-data-model"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -133,6 +141,32 @@ func TestJobBuilder(t *testing.T) {
 	}
 }
 
+func TestJavascriptTransformFromSource(t *testing.T) {
+	src := "function transform(record) { return record; }"
+	transform := NewJavascriptTransformFromSource(src, 2)
+
+	if transform.Type != "JavascriptTransform" {
+		t.Errorf("expected type to be 'JavascriptTransform', got '%s'", transform.Type)
+	}
+
+	if transform.Code != base64.StdEncoding.EncodeToString([]byte(src)) {
+		t.Errorf("expected code to be base64 encoded source, got '%s'", transform.Code)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(transform.Code)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(decoded) != src {
+		t.Errorf("expected decoded code to be '%s', got '%s'", src, string(decoded))
+	}
+
+	if transform.Parallelism != 2 {
+		t.Errorf("expected parallelism to be 2, got %d", transform.Parallelism)
+	}
+}
+
 func TestAddJob(t *testing.T) {
 	client := NewAdminUserConfiguredClient()
 
@@ -671,8 +705,12 @@ func TestJobManagement(t *testing.T) {
 		t.Error(err)
 	}
 
-	// add pause here just in case...
-	time.Sleep(2 * time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = client.WaitForJob(ctx, jobId, 100*time.Millisecond)
+	if err != nil {
+		t.Error(err)
+	}
 
 	// check data in second dataset
 	entities, err = client.GetEntities(datasetId2, "", 0, false, true)
@@ -852,8 +890,12 @@ func TestUnionDatasetSource(t *testing.T) {
 		t.Error(err)
 	}
 
-	// add pause here just in case...
-	time.Sleep(2 * time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = client.WaitForJob(ctx, jobId, 100*time.Millisecond)
+	if err != nil {
+		t.Error(err)
+	}
 
 	// check data in third dataset
 	entities, err = client.GetEntities(datasetId3, "", 0, false, true)
@@ -876,3 +918,661 @@ func TestUnionDatasetSource(t *testing.T) {
 	client.DeleteDataset(datasetId2)
 	client.DeleteDataset(datasetId3)
 }
+
+func TestGetJobSinceToken(t *testing.T) {
+	tokens := map[string]string{"job1": `{"token":"t42"}`, "job2": ``}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/job/"), "/since")
+		body, ok := tokens[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := client.GetJobSinceToken("job1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "t42" {
+		t.Errorf("expected token t42, got '%s'", token)
+	}
+
+	token, err = client.GetJobSinceToken("job2")
+	if err != nil || token != "" {
+		t.Errorf("expected no token for a job that has not run, got '%s', %v", token, err)
+	}
+
+	_, err = client.GetJobSinceToken("")
+	var paramErr *ParameterError
+	if !errors.As(err, &paramErr) {
+		t.Errorf("expected ParameterError for empty id, got %v", err)
+	}
+}
+
+func TestAddAndDeleteJobs(t *testing.T) {
+	jobs := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/jobs":
+			job := &Job{}
+			if err := json.NewDecoder(r.Body).Decode(job); err != nil || job.Id == "existing" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			jobs[job.Id] = true
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/jobs/"):
+			id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+			if !jobs[id] {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			delete(jobs, id)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	toAdd := []*Job{
+		NewJobBuilder("job one", "job1").WithDatasetSource("source", false).WithDatasetSink("sink").Build(),
+		NewJobBuilder("", "job2").Build(),
+		NewJobBuilder("rejected", "existing").Build(),
+		NewJobBuilder("job three", "job3").WithDatasetSource("source", false).WithDatasetSink("sink").Build(),
+	}
+	errs, err := client.AddJobs(toAdd)
+	var bulkErr *BulkError
+	if !errors.As(err, &bulkErr) || bulkErr.Failed != 2 || bulkErr.Total != 4 {
+		t.Fatalf("expected a BulkError with 2 of 4 failed, got %v", err)
+	}
+	var paramErr *ParameterError
+	var reqErr *RequestError
+	if errs[0] != nil || !errors.As(errs[1], &paramErr) || !errors.As(errs[2], &reqErr) || errs[3] != nil {
+		t.Errorf("unexpected per job errors %v", errs)
+	}
+	if !jobs["job1"] || !jobs["job3"] {
+		t.Errorf("expected the valid jobs to be added, got %v", jobs)
+	}
+
+	errs, err = client.DeleteJobs([]string{"job1", "missing", "job3"})
+	if !errors.As(err, &bulkErr) || bulkErr.Failed != 1 {
+		t.Fatalf("expected a BulkError with 1 failed, got %v", err)
+	}
+	if errs[0] != nil || errs[1] == nil || errs[2] != nil {
+		t.Errorf("unexpected per job errors %v", errs)
+	}
+
+	errs, err = client.DeleteJobs(nil)
+	if err != nil || len(errs) != 0 {
+		t.Errorf("expected no errors deleting no jobs, got %v, %v", errs, err)
+	}
+}
+
+func TestJobExistsAndGetJobByTitle(t *testing.T) {
+	jobs := []*Job{
+		{Id: "job1", Title: "import people"},
+		{Id: "job2", Title: "export people"},
+		{Id: "job3", Title: "export people"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/jobs" {
+			_ = json.NewEncoder(w).Encode(jobs)
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+		for _, job := range jobs {
+			if job.Id == id {
+				_ = json.NewEncoder(w).Encode(job)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err := client.JobExists("job1")
+	if err != nil || !exists {
+		t.Errorf("expected job1 to exist, got %v, %v", exists, err)
+	}
+
+	exists, err = client.JobExists("missing")
+	if err != nil || exists {
+		t.Errorf("expected missing job not to exist, got %v, %v", exists, err)
+	}
+
+	job, err := client.GetJobByTitle("import people")
+	if err != nil || job == nil || job.Id != "job1" {
+		t.Errorf("expected job1, got %v, %v", job, err)
+	}
+
+	job, err = client.GetJobByTitle("unknown")
+	if err != nil || job != nil {
+		t.Errorf("expected no job for an unknown title, got %v, %v", job, err)
+	}
+
+	_, err = client.GetJobByTitle("export people")
+	var paramErr *ParameterError
+	if !errors.As(err, &paramErr) {
+		t.Errorf("expected a ParameterError for an ambiguous title, got %v", err)
+	}
+}
+
+func TestWaitForJob(t *testing.T) {
+	var polls atomic.Int32
+	lastError := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/job/job1/status":
+			// running for the first two polls
+			if polls.Add(1) <= 2 {
+				_, _ = w.Write([]byte(`[{"jobId":"job1","jobTitle":"job one"}]`))
+				return
+			}
+			_, _ = w.Write([]byte(`[]`))
+		case "/jobs/_/history":
+			_ = json.NewEncoder(w).Encode([]*JobResult{
+				{ID: "job2", Title: "job two", Start: time.Now()},
+				{ID: "job1", Title: "job one", Start: time.Now().Add(-time.Hour), Processed: 1},
+				{ID: "job1", Title: "job one", Start: time.Now(), Processed: 2, LastError: lastError},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := client.WaitForJob(context.Background(), "job1", time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if polls.Load() != 3 || result.Processed != 2 {
+		t.Errorf("expected the latest run after 3 polls, got %d polls and %v", polls.Load(), result)
+	}
+
+	polls.Store(0)
+	lastError = "sink unavailable"
+	result, err = client.WaitForJob(context.Background(), "job1", time.Millisecond)
+	var jobErr *JobError
+	if !errors.As(err, &jobErr) || result == nil || !strings.Contains(err.Error(), "sink unavailable") {
+		t.Errorf("expected a JobError with the run result, got %v, %v", result, err)
+	}
+
+	// the context ends the wait while the job is running
+	polls.Store(-1000)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = client.WaitForJob(ctx, "job1", time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the context deadline error, got %v", err)
+	}
+}
+
+func TestIsJobRunning(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/job/running/status":
+			_, _ = w.Write([]byte(`[{"jobId":"running","jobTitle":"running job","started":"2024-01-02T03:04:05Z"}]`))
+		case "/job/stopped/status":
+			_, _ = w.Write([]byte(`[]`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	running, err := client.IsJobRunning("running")
+	if err != nil || !running {
+		t.Errorf("expected the job to be running, got %v, %v", running, err)
+	}
+	status, err := client.GetJobStatus("running")
+	if err != nil || status == nil || status.JobId != "running" {
+		t.Errorf("expected the running job status, got %v, %v", status, err)
+	}
+
+	running, err = client.IsJobRunning("stopped")
+	if err != nil || running {
+		t.Errorf("expected the job not to be running, got %v, %v", running, err)
+	}
+	status, err = client.GetJobStatus("stopped")
+	if err != nil || status != nil {
+		t.Errorf("expected no status for a stopped job, got %v, %v", status, err)
+	}
+
+	_, err = client.IsJobRunning("failing")
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) {
+		t.Errorf("expected a RequestError, got %v", err)
+	}
+}
+
+func TestResetJob(t *testing.T) {
+	client := NewAdminUserConfiguredClient()
+
+	sourceDataset := "dataset-" + uuid.New().String()
+	sinkDataset := "dataset-" + uuid.New().String()
+	for _, name := range []string{sourceDataset, sinkDataset} {
+		err := client.AddDataset(name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	collection := egdm.NewEntityCollection(nil)
+	for _, id := range []string{"entity-1", "entity-2"} {
+		prefixedId, err := collection.NamespaceManager.AssertPrefixedIdentifierFromURI("http://data.example.com/things/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = collection.AddEntity(egdm.NewEntity().SetID(prefixedId))
+	}
+	err := client.StoreEntities(sourceDataset, collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobId := "job-" + uuid.New().String()
+	tb := NewJobTriggerBuilder()
+	tb.WithIncremental()
+	tb.WithCron("@every 1h")
+	job := NewJobBuilder(jobId, jobId).
+		WithDatasetSource(sourceDataset, true).
+		WithDatasetSink(sinkDataset).
+		WithPaused(true).
+		AddTrigger(tb.Build()).
+		Build()
+	err = client.AddJob(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.DeleteJob(jobId) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = client.RunJobAsIncremental(jobId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := client.WaitForJob(ctx, jobId, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Processed != 2 {
+		t.Errorf("expected the first run to process 2 entities, got %d", result.Processed)
+	}
+
+	// after a reset the next run processes the source from the start again
+	err = client.ResetJob(jobId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := client.GetJobSinceToken(jobId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		t.Errorf("expected no since token after a reset, got '%s'", token)
+	}
+
+	err = client.RunJobAsIncremental(jobId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = client.WaitForJob(ctx, jobId, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Processed != 2 {
+		t.Errorf("expected the run after the reset to process 2 entities, got %d", result.Processed)
+	}
+}
+
+func TestResetJobSinceTokenRequest(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/job/job1/reset" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		queries = append(queries, r.URL.Query().Get("since"))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.ResetJob("job1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.ResetJobSinceToken("job1", "abc+/=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 2 || queries[0] != "" || queries[1] != "abc+/=" {
+		t.Errorf("expected no since token and then an escaped token, got %v", queries)
+	}
+
+	err = client.ResetJobSinceToken("job1", "abc\n")
+	var paramErr *ParameterError
+	if !errors.As(err, &paramErr) {
+		t.Errorf("expected a ParameterError for a token with a newline, got %v", err)
+	}
+}
+
+func TestPauseAndResumeAllJobs(t *testing.T) {
+	jobs := []*Job{
+		{Id: "running1", Title: "running one"},
+		{Id: "paused", Title: "paused on purpose", Paused: true},
+		{Id: "running2", Title: "running two"},
+		{Id: "stuck", Title: "cannot be paused"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/jobs" {
+			_ = json.NewEncoder(w).Encode(jobs)
+			return
+		}
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/job/"), "/")
+		if len(parts) != 2 || parts[0] == "stuck" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		for _, job := range jobs {
+			if job.Id == parts[0] {
+				job.Paused = parts[1] == "pause"
+			}
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paused, err := client.PauseAllJobs()
+	var bulkErr *BulkError
+	if !errors.As(err, &bulkErr) || bulkErr.Failed != 1 || bulkErr.Total != 3 {
+		t.Errorf("expected a BulkError with 1 of 3 failed, got %v", err)
+	}
+	if len(paused) != 2 || paused[0] != "running1" || paused[1] != "running2" {
+		t.Fatalf("expected the running jobs to be paused, got %v", paused)
+	}
+
+	err = client.ResumeAllJobs(paused)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, job := range jobs {
+		if job.Paused != (job.Id == "paused") {
+			t.Errorf("expected only the job paused on purpose to stay paused, job %s paused is %v", job.Id, job.Paused)
+		}
+	}
+}
+
+func TestDeleteJobsByFilter(t *testing.T) {
+	jobs := map[string]*Job{
+		"job1": NewJobBuilder("people import", "job1").WithTags([]string{"deprecated", "people"}).WithDatasetSource("people", false).Build(),
+		"job2": NewJobBuilder("places import", "job2").WithTags([]string{"places"}).WithDatasetSource("places", false).Build(),
+		"job3": NewJobBuilder("people export", "job3").WithTags([]string{"deprecated"}).WithHttpSource("http://example.com", false).Build(),
+		"job4": NewJobBuilder("locked", "job4").WithTags([]string{"deprecated"}).Build(),
+	}
+	var lock sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/jobs":
+			list := make([]*Job, 0)
+			for _, id := range []string{"job1", "job2", "job3", "job4"} {
+				if job, ok := jobs[id]; ok {
+					list = append(list, job)
+				}
+			}
+			_ = json.NewEncoder(w).Encode(list)
+		case r.Method == http.MethodDelete && r.URL.Path == "/jobs/job4":
+			w.WriteHeader(http.StatusForbidden)
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/jobs/"):
+			delete(jobs, strings.TrimPrefix(r.URL.Path, "/jobs/"))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only import jobs tagged deprecated
+	deleted, err := client.DeleteJobsByFilter(NewJobsFilter().HasTags("deprecated").HasTitle("Import"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 || jobs["job1"] != nil || len(jobs) != 3 {
+		t.Errorf("expected only job1 to be deleted, got %d deleted and %v remaining", deleted, jobs)
+	}
+
+	deleted, err = client.DeleteJobsByFilter(NewJobsFilter().HasTags("deprecated"))
+	var bulkErr *BulkError
+	if !errors.As(err, &bulkErr) || bulkErr.Failed != 1 || bulkErr.Total != 2 {
+		t.Errorf("expected a BulkError for the job that cannot be deleted, got %v", err)
+	}
+	if deleted != 1 || jobs["job3"] != nil || jobs["job2"] == nil {
+		t.Errorf("expected job3 to be deleted, got %d deleted and %v remaining", deleted, jobs)
+	}
+
+	var paramErr *ParameterError
+	for _, filter := range []*jobsFilter{nil, NewJobsFilter(), NewJobsFilter().HasDurationGreaterThan("ten seconds")} {
+		_, err = client.DeleteJobsByFilter(filter)
+		if !errors.As(err, &paramErr) {
+			t.Errorf("expected a ParameterError for filter %v, got %v", filter, err)
+		}
+	}
+	if len(jobs) != 2 {
+		t.Errorf("expected invalid filters not to delete jobs, got %v", jobs)
+	}
+}
+
+func TestGetJobsFiltered(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	var queries []string
+	// the stub ignores the filter parameters, so the client must evaluate them as well
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/jobs":
+			queries = append(queries, r.URL.RawQuery)
+			_ = json.NewEncoder(w).Encode([]*Job{
+				NewJobBuilder("people import", "job1").WithTags([]string{"people"}).WithDatasetSource("people", false).
+					WithJavascriptTransform("", 0).AddTrigger(NewJobTriggerBuilder().WithCron("@every 60s").WithFullSync().Build()).Build(),
+				NewJobBuilder("people export", "job2").WithTags([]string{"people"}).WithDatasetSource("people", false).
+					WithHttpSink("http://example.com").WithPaused(true).Build(),
+				NewJobBuilder("places import", "job3").WithTags([]string{"places"}).WithHttpSource("http://example.com", false).Build(),
+			})
+		case "/jobs/_/history":
+			_ = json.NewEncoder(w).Encode([]*JobResult{
+				{ID: "job1", Start: start, End: start.Add(20 * time.Second)},
+				{ID: "job2", Start: start, End: start.Add(time.Second), LastError: "sink unavailable"},
+				{ID: "job3", Start: start.Add(time.Hour), End: start.Add(time.Hour + 30*time.Second)},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filter   *jobsFilter
+		query    string
+		expected []string
+	}{
+		{nil, "", []string{"job1", "job2", "job3"}},
+		{NewJobsFilter().HasTitle("import"), "title=import", []string{"job1", "job3"}},
+		{NewJobsFilter().HasTags("people").IsPaused(false), "paused=false&tags=people", []string{"job1"}},
+		{NewJobsFilter().HasSource("http").HasTags("places"), "source=http&tags=places", []string{"job3"}},
+		{NewJobsFilter().HasSink("http"), "sink=http", []string{"job2"}},
+		{NewJobsFilter().HasTransform("javascript").HasTrigger("fullsync").HasId("JOB"), "id=JOB&transform=javascript&triggers=fullsync", []string{"job1"}},
+		{NewJobsFilter().HasError("unavailable"), "", []string{"job2"}},
+		{NewJobsFilter().HasDurationGreaterThan("10s").HasLastRunBefore("2024-01-02T03:30:00Z"), "", []string{"job1"}},
+		{NewJobsFilter().HasDurationLessThan("25s").HasLastRunAfter("2024-01-02T03:00:00Z"), "", []string{}},
+	}
+	for _, test := range tests {
+		queries = nil
+		jobs, err := client.GetJobsFiltered(test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, 0)
+		for _, job := range jobs {
+			ids = append(ids, job.Id)
+		}
+		if !reflect.DeepEqual(ids, test.expected) {
+			t.Errorf("filter %+v: expected %v, got %v", test.filter, test.expected, ids)
+		}
+		if len(queries) != 1 || queries[0] != test.query {
+			t.Errorf("filter %+v: expected query '%s', got %v", test.filter, test.query, queries)
+		}
+	}
+
+	_, err = client.GetJobsFiltered(NewJobsFilter().HasLastRunAfter("yesterday"))
+	var paramErr *ParameterError
+	if !errors.As(err, &paramErr) {
+		t.Errorf("expected a ParameterError for an invalid time, got %v", err)
+	}
+}
+
+func TestHttpSourceAndSinkAuth(t *testing.T) {
+	headers := map[string]string{"X-Api-Key": "my-key"}
+	job := NewJobBuilder("myjob", "job1").WithHttpSourceHeaders("http://example.com/source", headers).Build()
+	expected := map[string]interface{}{"Type": "HttpDatasetSource", "Url": "http://example.com/source", "Headers": headers}
+	if !reflect.DeepEqual(job.Source, expected) {
+		t.Errorf("expected source %v, got %v", expected, job.Source)
+	}
+
+	job = NewJobBuilder("myjob", "job1").WithSecureHttpSource("http://example.com/source", true, "source-provider").
+		WithSecureHttpSink("http://example.com/sink", "sink-provider").Build()
+	if job.Source["TokenProvider"] != "source-provider" {
+		t.Errorf("expected source token provider 'source-provider', got '%v'", job.Source["TokenProvider"])
+	}
+	if job.Sink["TokenProvider"] != "sink-provider" {
+		t.Errorf("expected sink token provider 'sink-provider', got '%v'", job.Sink["TokenProvider"])
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/provider/login/source-provider" {
+			_ = json.NewEncoder(w).Encode(&ProviderConfig{Name: "source-provider", Type: ProviderTypeToken})
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.CheckJobTokenProviders(NewJobBuilder("myjob", "job1").
+		WithSecureHttpSource("http://example.com/source", true, "source-provider").WithDatasetSink("people").Build())
+	if err != nil {
+		t.Errorf("expected the source provider to exist, got %v", err)
+	}
+
+	err = client.CheckJobTokenProviders(job)
+	var notFoundErr *NotFoundError
+	if !errors.As(err, &notFoundErr) || !strings.Contains(err.Error(), "sink-provider") {
+		t.Errorf("expected a NotFoundError for the sink provider, got %v", err)
+	}
+}
+
+func TestDatasetSourceSince(t *testing.T) {
+	job := NewJobBuilder("myjob", "job1").WithDatasetSourceSince("people", "MTIzNA==", true).Build()
+	expected := map[string]interface{}{"Type": "DatasetSource", "Name": "people", "LatestOnly": true, "Since": "MTIzNA=="}
+	if !reflect.DeepEqual(job.Source, expected) {
+		t.Errorf("expected source %v, got %v", expected, job.Source)
+	}
+
+	job = NewJobBuilder("myjob", "job1").WithDatasetSourceSince("people", "", false).Build()
+	if _, ok := job.Source["Since"]; ok {
+		t.Errorf("expected no since token for an empty token, got %v", job.Source)
+	}
+}
+
+func TestWaitForJobIgnoresEarlierRun(t *testing.T) {
+	var polls atomic.Int32
+	var lock sync.Mutex
+	// the job has completed once before it is triggered again
+	history := []*JobResult{{ID: "job1", Title: "job one", Start: time.Now().Add(-time.Hour), Processed: 1}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		switch r.URL.Path {
+		case "/job/job1/status":
+			// the new run has not started for the first two polls, then runs for two polls
+			switch poll := polls.Add(1); {
+			case poll <= 2:
+				_, _ = w.Write([]byte(`[]`))
+			case poll <= 4:
+				_, _ = w.Write([]byte(`[{"jobId":"job1","jobTitle":"job one"}]`))
+			default:
+				if len(history) == 1 {
+					history = append(history, &JobResult{ID: "job1", Title: "job one", Start: time.Now(), Processed: 2})
+				}
+				_, _ = w.Write([]byte(`[]`))
+			}
+		case "/jobs/_/history":
+			_ = json.NewEncoder(w).Encode(history)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := client.WaitForJob(context.Background(), "job1", time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Processed != 2 || polls.Load() != 5 {
+		t.Errorf("expected the result of the new run after 5 polls, got %v after %d polls", result, polls.Load())
+	}
+}
@@ -0,0 +1,63 @@
+package datahub
+
+import "encoding/json"
+
+// GetNamespaces gets the global namespace prefix mappings of the data hub instance.
+// returns a map from prefix to namespace URI, for example ns0 to http://data.mimiro.io/core/dataset/.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a RequestError if the request fails.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) GetNamespaces() (map[string]string, error) {
+	err := c.checkToken()
+	if err != nil {
+		return nil, &AuthenticationError{Msg: "invalid token or unable to authenticate", Err: err}
+	}
+
+	client := c.makeHttpClient()
+	data, err := client.makeRequest(httpGet, "/namespaces", nil, nil, nil)
+	if err != nil {
+		return nil, &RequestError{Msg: "unable to get namespaces", Err: err}
+	}
+
+	namespaces := make(map[string]string)
+	err = json.Unmarshal(data, &namespaces)
+	if err != nil {
+		return nil, &ClientProcessingError{Msg: "unable to unmarshal namespaces", Err: err}
+	}
+
+	return namespaces, nil
+}
+
+// AddNamespace registers a namespace prefix mapping with the data hub instance.
+// prefix is the short prefix used in entity ids and property names, for example people.
+// uri is the namespace URI the prefix expands to, for example http://data.example.com/people/.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the prefix or uri is empty.
+// returns a RequestError if the request fails.
+func (c *Client) AddNamespace(prefix string, uri string) error {
+	if prefix == "" {
+		return &ParameterError{Msg: "namespace prefix is required"}
+	}
+
+	if uri == "" {
+		return &ParameterError{Msg: "namespace uri is required"}
+	}
+
+	data, err := json.Marshal(map[string]string{prefix: uri})
+	if err != nil {
+		return &ParameterError{Msg: "unable to serialise namespace", Err: err}
+	}
+
+	err = c.checkToken()
+	if err != nil {
+		return &AuthenticationError{Msg: "invalid token or unable to authenticate", Err: err}
+	}
+
+	client := c.makeHttpClient()
+	_, err = client.makeRequest(httpPost, "/namespaces", data, nil, nil)
+	if err != nil {
+		return &RequestError{Msg: "unable to add namespace", Err: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package datahub
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestGetAndAddNamespaces(t *testing.T) {
+	var lock sync.Mutex
+	namespaces := map[string]string{"ns0": "http://data.mimiro.io/core/dataset/"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if r.URL.Path != "/namespaces" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			_ = json.NewEncoder(w).Encode(namespaces)
+		case http.MethodPost:
+			added := map[string]string{}
+			if err := json.NewDecoder(r.Body).Decode(&added); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			for prefix, uri := range added {
+				namespaces[prefix] = uri
+			}
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.AddNamespace("people", "http://data.example.com/people/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := client.GetNamespaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 2 || result["people"] != "http://data.example.com/people/" {
+		t.Errorf("expected the added namespace to be returned, got %v", result)
+	}
+
+	err = client.AddNamespace("", "http://data.example.com/people/")
+	var paramErr *ParameterError
+	if !errors.As(err, &paramErr) {
+		t.Errorf("expected a ParameterError for an empty prefix, got %v", err)
+	}
+}
@@ -1,34 +1,99 @@
 package datahub
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
-func createJWTForTokenRequest(subject string, audience string, privateKey *rsa.PrivateKey) (string, error) {
+// signingMethodForKey returns the JWT signing method for the private key type.
+// RSA keys sign with RS256, ECDSA keys with the ES method matching the curve and Ed25519 keys with EdDSA.
+func signingMethodForKey(privateKey crypto.PrivateKey) (jwt.SigningMethod, error) {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PrivateKey:
+		if key == nil || key.Curve == nil {
+			return nil, errors.New("ecdsa private key has no curve")
+		}
+		switch key.Curve.Params().BitSize {
+		case 256:
+			return jwt.SigningMethodES256, nil
+		case 384:
+			return jwt.SigningMethodES384, nil
+		case 521:
+			return jwt.SigningMethodES512, nil
+		}
+		return nil, fmt.Errorf("unsupported ecdsa curve %s", key.Curve.Params().Name)
+	case ed25519.PrivateKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", privateKey)
+}
+
+// default validity and clock skew tolerance of the signed token request
+const (
+	defaultAssertionValidity  = time.Minute
+	defaultAssertionClockSkew = 30 * time.Second
+)
+
+// createJWTForTokenRequest creates the signed client assertion for a token request.
+// The assertion is issued now, is valid from clockSkew in the past so that authorizers with a clock running
+// slightly behind accept it, and expires after validity. Defaults are used if validity or clockSkew are not positive.
+func createJWTForTokenRequest(subject string, audience string, privateKey crypto.PrivateKey, validity time.Duration, clockSkew time.Duration) (string, error) {
+	signingMethod, err := signingMethodForKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	if validity <= 0 {
+		validity = defaultAssertionValidity
+	}
+	if clockSkew <= 0 {
+		clockSkew = defaultAssertionClockSkew
+	}
+
 	uniqueId := uuid.New()
 
+	now := time.Now()
 	claims := jwt.RegisteredClaims{
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute * 1)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now.Add(-clockSkew)),
+		ExpiresAt: jwt.NewNumericDate(now.Add(validity)),
 		ID:        uniqueId.String(),
 		Subject:   subject,
 		Audience:  jwt.ClaimStrings{audience},
 	}
 
-	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(privateKey)
+	token, err := jwt.NewWithClaims(signingMethod, claims).SignedString(privateKey)
 	if err != nil {
 		return "", err
 	}
 	return token, nil
 }
 
+// accessTokenExpiry reads the exp claim from a JWT access token without verifying it.
+// returns false if the token is not a JWT or has no exp claim.
+func accessTokenExpiry(accessToken string) (time.Time, bool) {
+	claims := jwt.RegisteredClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(accessToken, &claims)
+	if err != nil || claims.ExpiresAt == nil {
+		return time.Time{}, false
+	}
+	return claims.ExpiresAt.Time, true
+}
+
 func generateRsaKeyPair() (*rsa.PrivateKey, *rsa.PublicKey, error) {
 	key, err := rsa.GenerateKey(rand.Reader, 4096)
 	if err != nil {
@@ -37,6 +102,36 @@ func generateRsaKeyPair() (*rsa.PrivateKey, *rsa.PublicKey, error) {
 	return key, &key.PublicKey, nil
 }
 
+func generateECKeyPair() (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, &key.PublicKey, nil
+}
+
+func exportECPrivateKeyAsPem(key *ecdsa.PrivateKey) ([]byte, error) {
+	b, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	pemBytes := pem.EncodeToMemory(
+		&pem.Block{
+			Type:  "PRIVATE KEY",
+			Bytes: b,
+		},
+	)
+	return pemBytes, nil
+}
+
+func parseECPrivateKeyFromPem(pemValue []byte) (*ecdsa.PrivateKey, error) {
+	privateKey, err := jwt.ParseECPrivateKeyFromPEM(pemValue)
+	if err != nil {
+		return nil, err
+	}
+	return privateKey, nil
+}
+
 func exportRsaPrivateKeyAsPem(key *rsa.PrivateKey) ([]byte, error) {
 	b, err := x509.MarshalPKCS8PrivateKey(key)
 	if err != nil {
@@ -59,7 +154,34 @@ func parseRsaPrivateKeyFromPem(pemValue []byte) (*rsa.PrivateKey, error) {
 	return privateKey, nil
 }
 
+// parseRsaPrivateKeyFromPemWithPassword parses a passphrase protected PEM encoded RSA private key.
+// returns an error that says the passphrase may be wrong if the key cannot be decrypted.
+func parseRsaPrivateKeyFromPemWithPassword(pemValue []byte, passphrase []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemValue)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing the key")
+	}
+
+	if !x509.IsEncryptedPEMBlock(block) {
+		return nil, errors.New("private key is not encrypted")
+	}
+
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEMWithPassword(pemValue, string(passphrase))
+	if errors.Is(err, x509.IncorrectPasswordError) {
+		return nil, errors.New("unable to decrypt private key, the passphrase is not correct")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt private key, the passphrase may not be correct: %w", err)
+	}
+	return privateKey, nil
+}
+
 func exportRsaPublicKeyAsPem(key *rsa.PublicKey) ([]byte, error) {
+	return exportPublicKeyAsPem(key)
+}
+
+// exportPublicKeyAsPem encodes an RSA or ECDSA public key as a PKIX PEM block
+func exportPublicKeyAsPem(key crypto.PublicKey) ([]byte, error) {
 	b, err := x509.MarshalPKIXPublicKey(key)
 	if err != nil {
 		return nil, err
@@ -76,12 +198,7 @@ func exportRsaPublicKeyAsPem(key *rsa.PublicKey) ([]byte, error) {
 }
 
 func parseRsaPublicKeyFromPem(pemValue []byte) (*rsa.PublicKey, error) {
-	block, _ := pem.Decode(pemValue)
-	if block == nil {
-		return nil, errors.New("failed to parse PEM block containing the key")
-	}
-
-	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	pub, err := parsePublicKeyFromPem(pemValue)
 	if err != nil {
 		return nil, err
 	}
@@ -94,3 +211,28 @@ func parseRsaPublicKeyFromPem(pemValue []byte) (*rsa.PublicKey, error) {
 	}
 	return nil, errors.New("Key type is not RSA")
 }
+
+func parseECPublicKeyFromPem(pemValue []byte) (*ecdsa.PublicKey, error) {
+	pub, err := parsePublicKeyFromPem(pemValue)
+	if err != nil {
+		return nil, err
+	}
+
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		return pub, nil
+	default:
+		break // fall through
+	}
+	return nil, errors.New("Key type is not ECDSA")
+}
+
+// parsePublicKeyFromPem parses a PKIX PEM encoded public key of any supported type
+func parsePublicKeyFromPem(pemValue []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(pemValue)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing the key")
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
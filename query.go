@@ -1,16 +1,19 @@
 package datahub
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	egdm "github.com/mimiro-io/entity-graph-data-model"
 	"io"
 )
 
 // QueryResultIterator is used to iterate over the results of a javascript query.
 type QueryResultIterator struct {
-	dataStream io.ReadCloser
-	decoder    *json.Decoder
-	readStart  bool
+	dataStream   io.ReadCloser
+	decoder      *json.Decoder
+	readStart    bool
+	continuation string
 }
 
 func newQueryResultIterator(dataStream io.ReadCloser) *QueryResultIterator {
@@ -24,31 +27,98 @@ func newQueryResultIterator(dataStream io.ReadCloser) *QueryResultIterator {
 // returns nil if there are no more objects.
 // returns the object if there are no errors.
 func (qri *QueryResultIterator) Next() (map[string]interface{}, error) {
-	var err error
-	if !qri.readStart {
-		token, err := qri.decoder.Token()
-		if err != nil {
-			return nil, &ClientProcessingError{Msg: "unable to decode start of data stream", Err: err}
-		}
-		if token != json.Delim('[') {
-			return nil, &ClientProcessingError{Msg: "expected [ at start of data stream", Err: nil}
-		}
-		qri.readStart = true
+	raw, err := qri.nextResult()
+	if err != nil || raw == nil {
+		return nil, err
+	}
+
+	var obj map[string]interface{}
+	err = json.Unmarshal(raw, &obj)
+	if err != nil {
+		return nil, &ClientProcessingError{Msg: "unable to decode data stream", Err: err}
+	}
+	return obj, nil
+}
+
+// Decode decodes the next object in the query result iterator into the value pointed to by v.
+// It mirrors (*json.Decoder).Decode and allows results to be read into typed structs.
+// returns a ClientProcessingError if there is an issue decoding the data stream.
+// returns nil and leaves v unmodified if there are no more objects.
+func (qri *QueryResultIterator) Decode(v interface{}) error {
+	raw, err := qri.nextResult()
+	if err != nil || raw == nil {
+		return err
+	}
+
+	err = json.Unmarshal(raw, v)
+	if err != nil {
+		return &ClientProcessingError{Msg: "unable to decode data stream", Err: err}
+	}
+	return nil
+}
+
+// Continuation returns the continuation token emitted by the server for paged query results.
+// The token is only known once the iterator has read past it, so call this after the results are exhausted.
+// returns the empty string if the server did not emit a token.
+func (qri *QueryResultIterator) Continuation() string {
+	return qri.continuation
+}
+
+// nextResult reads the next result row from the data stream, recording and skipping continuation tokens.
+// returns nil if there are no more rows.
+func (qri *QueryResultIterator) nextResult() (json.RawMessage, error) {
+	err := qri.readStartOfStream()
+	if err != nil {
+		return nil, err
 	}
 
-	if qri.decoder.More() {
-		var obj map[string]interface{}
-		err = qri.decoder.Decode(&obj)
+	for qri.decoder.More() {
+		var raw json.RawMessage
+		err = qri.decoder.Decode(&raw)
 		if err != nil {
 			return nil, &ClientProcessingError{Msg: "unable to decode data stream", Err: err}
 		}
-		return obj, nil
+
+		if token, ok := continuationToken(raw); ok {
+			qri.continuation = token
+			continue
+		}
+		return raw, nil
 	}
 
-	// No error, return the object
 	return nil, nil
 }
 
+// continuationToken checks if a result row is a continuation token object and returns the token if it is.
+func continuationToken(raw json.RawMessage) (string, bool) {
+	if len(raw) == 0 || raw[0] != '{' {
+		return "", false
+	}
+
+	continuation := &egdm.Continuation{}
+	if err := json.Unmarshal(raw, continuation); err != nil || continuation.ID != "@continuation" {
+		return "", false
+	}
+	return continuation.Token, true
+}
+
+// readStartOfStream consumes the opening [ of the result array the first time it is called.
+func (qri *QueryResultIterator) readStartOfStream() error {
+	if qri.readStart {
+		return nil
+	}
+
+	token, err := qri.decoder.Token()
+	if err != nil {
+		return &ClientProcessingError{Msg: "unable to decode start of data stream", Err: err}
+	}
+	if token != json.Delim('[') {
+		return &ClientProcessingError{Msg: "expected [ at start of data stream", Err: nil}
+	}
+	qri.readStart = true
+	return nil
+}
+
 // Close closes the query result iterator. This must be called when the iterator is no longer needed.
 // returns a ClientProcessingError if there is an issue closing the data stream.
 func (qri *QueryResultIterator) Close() error {
@@ -75,8 +145,19 @@ func (c *Client) RunJavascriptQuery(query string) (*QueryResultIterator, error)
 		return nil, &AuthenticationError{Msg: "unable to authenticate", Err: err}
 	}
 
+	return c.runJavascriptQuery(query, "")
+}
+
+// runJavascriptQuery posts a javascript query to the server, resuming from the continuation token if one is given.
+func (c *Client) runJavascriptQuery(query string, continuation string) (*QueryResultIterator, error) {
 	queryObject := map[string]string{"query": query}
+	if continuation != "" {
+		queryObject["continuation"] = continuation
+	}
 	queryBytes, err := json.Marshal(queryObject)
+	if err != nil {
+		return nil, &ParameterError{Msg: "unable to marshal query", Err: err}
+	}
 
 	client := c.makeHttpClient()
 	headers := make(map[string]string)
@@ -89,6 +170,120 @@ func (c *Client) RunJavascriptQuery(query string) (*QueryResultIterator, error)
 	return newQueryResultIterator(data), nil
 }
 
+// JavascriptQueryStream is used to iterate over the results of a paged javascript query.
+// When a page is exhausted and the server returned a continuation token the query is sent again to fetch the next page.
+type JavascriptQueryStream struct {
+	client  *Client
+	query   string
+	current *QueryResultIterator
+	token   string
+}
+
+// RunJavascriptQueryStream executes a javascript query on the server and follows continuation tokens.
+// The query is a base64 encoded string of the javascript code to execute.
+// returns a JavascriptQueryStream that can be used to iterate over the results of all pages.
+// returns an AuthenticationError if the client is not authenticated.
+// returns a ParameterError if the query is empty.
+// returns a RequestError if there is an issue executing the query.
+func (c *Client) RunJavascriptQueryStream(query string) (*JavascriptQueryStream, error) {
+	current, err := c.RunJavascriptQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JavascriptQueryStream{client: c, query: query, current: current}, nil
+}
+
+// Next returns the next object in the stream, fetching the next page when needed.
+// returns a ClientProcessingError if there is an issue decoding the data stream.
+// returns a RequestError if there is an issue fetching the next page.
+// returns nil if there are no more objects.
+func (s *JavascriptQueryStream) Next() (map[string]interface{}, error) {
+	raw, err := s.nextResult()
+	if err != nil || raw == nil {
+		return nil, err
+	}
+
+	var obj map[string]interface{}
+	err = json.Unmarshal(raw, &obj)
+	if err != nil {
+		return nil, &ClientProcessingError{Msg: "unable to decode data stream", Err: err}
+	}
+	return obj, nil
+}
+
+// Decode decodes the next object in the stream into the value pointed to by v, fetching the next page when needed.
+// returns a ClientProcessingError if there is an issue decoding the data stream.
+// returns a RequestError if there is an issue fetching the next page.
+// returns nil and leaves v unmodified if there are no more objects.
+func (s *JavascriptQueryStream) Decode(v interface{}) error {
+	raw, err := s.nextResult()
+	if err != nil || raw == nil {
+		return err
+	}
+
+	err = json.Unmarshal(raw, v)
+	if err != nil {
+		return &ClientProcessingError{Msg: "unable to decode data stream", Err: err}
+	}
+	return nil
+}
+
+// Close closes the stream. This must be called when the stream is no longer needed.
+// returns a ClientProcessingError if there is an issue closing the data stream.
+func (s *JavascriptQueryStream) Close() error {
+	return s.current.Close()
+}
+
+func (s *JavascriptQueryStream) nextResult() (json.RawMessage, error) {
+	for {
+		raw, err := s.current.nextResult()
+		if err != nil || raw != nil {
+			return raw, err
+		}
+
+		// stop when the server no longer returns a new token
+		token := s.current.Continuation()
+		if token == "" || token == s.token {
+			return nil, nil
+		}
+
+		err = s.current.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.client.checkToken()
+		if err != nil {
+			return nil, &AuthenticationError{Msg: "unable to authenticate", Err: err}
+		}
+
+		next, err := s.client.runJavascriptQuery(s.query, token)
+		if err != nil {
+			return nil, err
+		}
+		s.current = next
+		s.token = token
+	}
+}
+
+// EncodeJavascript encodes plain javascript source as the base64 string expected by the data hub
+// for javascript queries and transforms.
+func EncodeJavascript(src string) string {
+	return base64.StdEncoding.EncodeToString([]byte(src))
+}
+
+// RunJavascriptSource executes a javascript query on the server from plain javascript source.
+// The source is base64 encoded before being sent, see RunJavascriptQuery for details.
+// returns a ParameterError if the source is empty.
+func (c *Client) RunJavascriptSource(src string) (*QueryResultIterator, error) {
+	if src == "" {
+		return nil, &ParameterError{Msg: "query source cannot be empty"}
+	}
+
+	return c.RunJavascriptQuery(EncodeJavascript(src))
+}
+
 type Query struct {
 	EntityID         string   `json:"entityId"`
 	StartingEntities []string `json:"startingEntities"`
@@ -99,6 +294,15 @@ type Query struct {
 	Limit            int      `json:"limit"`
 	Continuations    []string `json:"continuations"`
 	NoPartialMerging bool     `json:"noPartialMerging"`
+	// Hops is the path of predicates followed by RunMultiHopQuery. It is resolved by the client and not sent to the server.
+	Hops []Hop `json:"-"`
+}
+
+// Hop is a single step in a multi-hop graph traversal.
+// Predicate is the reference to follow, Inverse follows the reference from object to subject.
+type Hop struct {
+	Predicate string
+	Inverse   bool
 }
 
 type QueryBuilder struct {
@@ -154,25 +358,72 @@ func (qb *QueryBuilder) WithNoPartialMerging(noPartialMerging bool) *QueryBuilde
 	return qb
 }
 
+// AddHop adds a step to the path of predicates followed by RunMultiHopQuery.
+// Hops are followed in the order they are added.
+func (qb *QueryBuilder) AddHop(predicate string, inverse bool) *QueryBuilder {
+	qb.query.Hops = append(qb.query.Hops, Hop{Predicate: predicate, Inverse: inverse})
+	return qb
+}
+
 func (qb *QueryBuilder) Build() *Query {
 	return qb.query
 }
 
+// withContinuation returns a copy of the query that fetches the page identified by the continuation token.
+// All other parameters of the query are kept so that every page is evaluated the same way.
+func (q *Query) withContinuation(token string) *Query {
+	next := *q
+	next.Continuations = []string{token}
+	return &next
+}
+
 type QueryResultEntitiesStream struct {
 	client            *Client
+	query             *Query
 	currentCollection *egdm.EntityCollection
 	currentPos        int
+	closed            bool
 }
 
 func (c *Client) RunHopQuery(entityId string, predicate string, datasets []string, inverse bool, limit int) (EntityIterator, error) {
+	return c.RunHopQueryWithOptions(entityId, predicate, HopQueryOptions{Datasets: datasets, Inverse: inverse, Limit: limit})
+}
+
+// HopQueryOptions are the options for RunHopQueryWithOptions.
+// Datasets optionally restricts the query to the named datasets.
+// Inverse follows the predicate from object to subject.
+// Limit is the page size, all pages are returned by the iterator.
+// Details asks the server to include the relationship and provenance details of the entities.
+type HopQueryOptions struct {
+	Datasets []string
+	Inverse  bool
+	Limit    int
+	Details  bool
+}
+
+// RunHopQueryWithOptions follows the predicate from the entity and returns an EntityIterator over the related entities.
+// Further pages are requested with the same query parameters as the first page.
+// returns an AuthenticationError if the client is not authenticated.
+// returns a ParameterError if the entity id or predicate is empty.
+// returns a RequestError if there is an issue executing the query.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) RunHopQueryWithOptions(entityId string, predicate string, opts HopQueryOptions) (EntityIterator, error) {
+	if entityId == "" {
+		return nil, &ParameterError{Msg: "entity id is required"}
+	}
+
+	if predicate == "" {
+		return nil, &ParameterError{Msg: "predicate is required"}
+	}
+
 	qb := NewQueryBuilder()
-	qb.query.StartingEntities = make([]string, 0)
-	qb.query.StartingEntities = append(qb.query.StartingEntities, entityId)
-	qb.WithInverse(inverse)
-	qb.WithLimit(limit)
+	qb.WithStartingEntities([]string{entityId})
+	qb.WithInverse(opts.Inverse)
+	qb.WithLimit(opts.Limit)
 	qb.WithPredicate(predicate)
-	if datasets != nil {
-		qb.WithDatasets(datasets)
+	qb.WithDetails(opts.Details)
+	if opts.Datasets != nil {
+		qb.WithDatasets(opts.Datasets)
 	}
 	return c.newQueryResultEntitiesStream(qb.Build())
 }
@@ -180,6 +431,7 @@ func (c *Client) RunHopQuery(entityId string, predicate string, datasets []strin
 func (c *Client) newQueryResultEntitiesStream(query *Query) (EntityIterator, error) {
 	es := &QueryResultEntitiesStream{
 		client:     c,
+		query:      query,
 		currentPos: 0,
 	}
 
@@ -188,12 +440,7 @@ func (c *Client) newQueryResultEntitiesStream(query *Query) (EntityIterator, err
 	if err != nil {
 		return nil, err
 	}
-	result, err := c.RunQuery(query)
-	if err != nil {
-		return nil, err
-	}
-
-	es.currentCollection, err = es.makeEntityCollectionFromQueryResult(result)
+	es.currentCollection, _, err = c.runQuery(query)
 	if err != nil {
 		return nil, err
 	}
@@ -201,30 +448,89 @@ func (c *Client) newQueryResultEntitiesStream(query *Query) (EntityIterator, err
 	return es, nil
 }
 
-func (e *QueryResultEntitiesStream) makeEntityCollectionFromQueryResult(data []any) (*egdm.EntityCollection, error) {
-	context := data[0].(map[string]any)
-	resultRows := data[1].([]any)
-	continuation := data[2].([]any)
+// makeEntityCollectionFromQueryResult converts the raw result of a query into an EntityCollection.
+// The result is either [context, entity] for a single entity lookup, or [context, rows, continuations]
+// where each row is [startingEntity, predicate, entity].
+// returns a ClientProcessingError describing the problem if the result is not in the expected shape.
+func makeEntityCollectionFromQueryResult(data []any) (*egdm.EntityCollection, error) {
+	if len(data) < 2 {
+		return nil, &ClientProcessingError{Msg: fmt.Sprintf("malformed query result: expected at least 2 elements, got %d", len(data))}
+	}
+
+	context, ok := data[0].(map[string]any)
+	if !ok {
+		return nil, &ClientProcessingError{Msg: "malformed query result: element 0 is not a context object"}
+	}
 
 	ctx := egdm.NewNamespaceContext()
 
-	namespacePrefixes := context["namespaces"].(map[string]any)
+	namespacePrefixes, ok := context["namespaces"].(map[string]any)
+	if !ok {
+		return nil, &ClientProcessingError{Msg: "malformed query result: context at element 0 has no namespaces object"}
+	}
 	for key, value := range namespacePrefixes {
-		ctx.StorePrefixExpansionMapping(key, value.(string))
+		expansion, ok := value.(string)
+		if !ok {
+			return nil, &ClientProcessingError{Msg: fmt.Sprintf("malformed query result: namespace %s in context at element 0 is not a string", key)}
+		}
+		ctx.StorePrefixExpansionMapping(key, expansion)
 	}
 
 	ec := egdm.NewEntityCollection(ctx)
-	for _, row := range resultRows {
-		ec.AddEntityFromMap(row.([]any)[2].(map[string]any))
+
+	// single entity lookup
+	if entity, ok := data[1].(map[string]any); ok && len(data) == 2 {
+		err := addQueryResultEntity(ec, entity, "element 1")
+		if err != nil {
+			return nil, err
+		}
+		err = ec.ExpandNamespacePrefixes()
+		if err != nil {
+			return nil, &ClientProcessingError{Msg: "unable to expand namespace prefixes in query result", Err: err}
+		}
+		return ec, nil
+	}
+
+	if len(data) < 3 {
+		return nil, &ClientProcessingError{Msg: fmt.Sprintf("malformed query result: expected 3 elements, got %d", len(data))}
+	}
+
+	resultRows, ok := data[1].([]any)
+	if !ok {
+		return nil, &ClientProcessingError{Msg: "malformed query result: element 1 is not an array of result rows"}
+	}
+
+	continuation, ok := data[2].([]any)
+	if !ok {
+		return nil, &ClientProcessingError{Msg: "malformed query result: element 2 is not an array of continuation tokens"}
+	}
+
+	for i, row := range resultRows {
+		columns, ok := row.([]any)
+		if !ok || len(columns) < 3 {
+			return nil, &ClientProcessingError{Msg: fmt.Sprintf("malformed query result: result row %d is not an array of 3 elements", i)}
+		}
+		entity, ok := columns[2].(map[string]any)
+		if !ok {
+			return nil, &ClientProcessingError{Msg: fmt.Sprintf("malformed query result: element 2 of result row %d is not an entity object", i)}
+		}
+		err := addQueryResultEntity(ec, entity, fmt.Sprintf("result row %d", i))
+		if err != nil {
+			return nil, err
+		}
 	}
 	err := ec.ExpandNamespacePrefixes()
 	if err != nil {
-		return nil, err
+		return nil, &ClientProcessingError{Msg: "unable to expand namespace prefixes in query result", Err: err}
 	}
 
 	if len(continuation) == 1 {
+		token, ok := continuation[0].(string)
+		if !ok {
+			return nil, &ClientProcessingError{Msg: "malformed query result: continuation token at element 2 index 0 is not a string"}
+		}
 		cont := egdm.NewContinuation()
-		cont.Token = continuation[0].(string)
+		cont.Token = token
 		ec.SetContinuationToken(cont)
 	} else {
 		ec.SetContinuationToken(nil)
@@ -233,24 +539,52 @@ func (e *QueryResultEntitiesStream) makeEntityCollectionFromQueryResult(data []a
 	return ec, nil
 }
 
+// addQueryResultEntity checks the shape of an entity object from a query result before adding it to the collection.
+// location describes where in the result the entity was found, for error reporting.
+func addQueryResultEntity(ec *egdm.EntityCollection, entity map[string]any, location string) error {
+	if id, found := entity["id"]; found {
+		if _, ok := id.(string); !ok {
+			return &ClientProcessingError{Msg: fmt.Sprintf("malformed query result: entity id in %s is not a string", location)}
+		}
+	}
+
+	for _, key := range []string{"props", "refs"} {
+		if value, found := entity[key]; found {
+			if _, ok := value.(map[string]any); !ok {
+				return &ClientProcessingError{Msg: fmt.Sprintf("malformed query result: entity %s in %s is not an object", key, location)}
+			}
+		}
+	}
+
+	err := ec.AddEntityFromMap(entity)
+	if err != nil {
+		return &ClientProcessingError{Msg: fmt.Sprintf("unable to add entity from %s", location), Err: err}
+	}
+	return nil
+}
+
 func (e *QueryResultEntitiesStream) Next() (*egdm.Entity, error) {
+	if e.closed {
+		return nil, &ClientProcessingError{Msg: "query result stream is closed"}
+	}
+
 	if e.currentPos == len(e.currentCollection.Entities) {
 		if e.currentCollection.Continuation == nil {
 			return nil, nil
 		}
 
-		// query for next page with client
+		// query for next page with client, keeping the parameters of the original query
 		token := e.currentCollection.Continuation.Token
-		query := NewQueryBuilder().WithContinuations([]string{token}).Build()
-		result, err := e.client.RunQuery(query)
-		if err != nil {
-			return nil, err
+		query := e.query
+		if query == nil {
+			query = &Query{}
 		}
-
-		e.currentCollection, err = e.makeEntityCollectionFromQueryResult(result)
+		query = query.withContinuation(token)
+		collection, _, err := e.client.runQuery(query)
 		if err != nil {
 			return nil, err
 		}
+		e.currentCollection = collection
 		e.currentPos = 0
 	}
 
@@ -281,6 +615,16 @@ func (e *QueryResultEntitiesStream) Token() *egdm.Continuation {
 	return e.currentCollection.Continuation
 }
 
+// Close closes the stream. The last continuation token remains available from Token.
+func (e *QueryResultEntitiesStream) Close() error {
+	e.closed = true
+	if e.currentCollection != nil {
+		e.currentCollection.Entities = nil
+	}
+	e.currentPos = 0
+	return nil
+}
+
 func (c *Client) RunStreamingQuery(query *Query) (EntityIterator, error) {
 	if len(query.StartingEntities) != 1 {
 		return nil, &ParameterError{Msg: "query must have exactly one starting entity"}
@@ -293,32 +637,224 @@ func (c *Client) RunStreamingQuery(query *Query) (EntityIterator, error) {
 	return c.newQueryResultEntitiesStream(query)
 }
 
-func (c *Client) RunQuery(query *Query) ([]any, error) {
+// DeleteEntitiesMatching marks every entity matched by the query as deleted in the target dataset.
+// All pages of the query result are followed and the distinct entity ids are collected before the deletions are
+// stored with StoreEntitiesWithResult, so large deletions are uploaded in batches.
+// Use the QueryBuilder to create the query, for example everything referencing a removed entity.
+// returns the result of storing the deletions, with no entities processed if nothing matched.
+// returns an AuthenticationError if the client is not authenticated.
+// returns a ParameterError if the query is nil or the dataset name is empty.
+// returns a RequestError if there is an issue executing the query or storing the deletions.
+// returns a ClientProcessingError if the query response cannot be processed.
+// returns a BatchError if the deletions were uploaded in batches and one of them fails, its EntitiesProcessed
+// is the number of entities already deleted.
+func (c *Client) DeleteEntitiesMatching(query *Query, dataset string) (*StoreResult, error) {
 	if query == nil {
 		return nil, &ParameterError{Msg: "query cannot be nil"}
 	}
 
+	if dataset == "" {
+		return nil, &ParameterError{Msg: "dataset name is required"}
+	}
+
+	deletions := egdm.NewEntityCollection(nil)
+	seen := make(map[string]bool)
+	for {
+		result, err := c.RunQueryTyped(query)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entity := range result.Entities {
+			if seen[entity.ID] {
+				continue
+			}
+			seen[entity.ID] = true
+
+			deleted := egdm.NewEntity().SetID(entity.ID)
+			deleted.IsDeleted = true
+			_ = deletions.AddEntity(deleted)
+		}
+
+		if result.Continuation == nil || len(result.Entities) == 0 {
+			break
+		}
+		query = query.withContinuation(result.Continuation.Token)
+	}
+
+	if len(deletions.Entities) == 0 {
+		return &StoreResult{}, nil
+	}
+
+	return c.StoreEntitiesWithResult(dataset, deletions)
+}
+
+// QueryResult is the parsed result of running a Query.
+// Context holds the namespace mappings returned by the server, Entities the resulting entities with
+// expanded URIs, and Continuation the token to use to fetch the next page, or nil if there are no more results.
+type QueryResult struct {
+	Context      *egdm.Context
+	Entities     []*egdm.Entity
+	Continuation *egdm.Continuation
+}
+
+// RunMultiHopQuery follows the path of hops in the query from the starting entities and returns an
+// EntityIterator over the entities reached by the last hop.
+// Each hop is sent to the server as a query from all entities reached by the previous hop. Datasets scopes
+// every hop, Limit is used as the page size for each hop and all pages are followed. Entities reached more
+// than once are only returned once.
+// Use the QueryBuilder WithStartingEntities and AddHop functions to create the query.
+// returns an AuthenticationError if the client is not authenticated.
+// returns a ParameterError if the query is nil, has no starting entities or no hops.
+// returns a RequestError if there is an issue executing the query.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) RunMultiHopQuery(query *Query) (EntityIterator, error) {
+	if query == nil {
+		return nil, &ParameterError{Msg: "query cannot be nil"}
+	}
+
+	if len(query.StartingEntities) == 0 {
+		return nil, &ParameterError{Msg: "query must have at least one starting entity"}
+	}
+
+	if len(query.Hops) == 0 {
+		return nil, &ParameterError{Msg: "query must have at least one hop"}
+	}
+
+	current := query.StartingEntities
+	result := egdm.NewEntityCollection(nil)
+	for _, hop := range query.Hops {
+		if len(current) == 0 {
+			break
+		}
+
+		var err error
+		result, err = c.runHop(current, hop, query.Datasets, query.Limit)
+		if err != nil {
+			return nil, err
+		}
+
+		current = make([]string, 0, len(result.Entities))
+		for _, entity := range result.Entities {
+			current = append(current, entity.ID)
+		}
+	}
+
+	return &QueryResultEntitiesStream{client: c, currentCollection: result}, nil
+}
+
+// runHop follows a single hop from the starting entities, following all continuations.
+// returns the distinct entities reached.
+func (c *Client) runHop(startingEntities []string, hop Hop, datasets []string, limit int) (*egdm.EntityCollection, error) {
+	qb := NewQueryBuilder()
+	qb.WithStartingEntities(startingEntities)
+	qb.WithPredicate(hop.Predicate)
+	qb.WithInverse(hop.Inverse)
+	qb.WithLimit(limit)
+	if datasets != nil {
+		qb.WithDatasets(datasets)
+	}
+
+	hopResult := egdm.NewEntityCollection(nil)
+	seen := make(map[string]bool)
+	query := qb.Build()
+	for {
+		result, err := c.RunQueryTyped(query)
+		if err != nil {
+			return nil, err
+		}
+
+		for prefix, expansion := range result.Context.Namespaces {
+			hopResult.NamespaceManager.StorePrefixExpansionMapping(prefix, expansion)
+		}
+
+		for _, entity := range result.Entities {
+			if seen[entity.ID] {
+				continue
+			}
+			seen[entity.ID] = true
+			_ = hopResult.AddEntity(entity)
+		}
+
+		if result.Continuation == nil || len(result.Entities) == 0 {
+			break
+		}
+		query = query.withContinuation(result.Continuation.Token)
+	}
+
+	return hopResult, nil
+}
+
+// RunQueryTyped executes a query on the server and parses the response into a QueryResult.
+// Use the QueryBuilder to create valid queries.
+// returns an AuthenticationError if the client is not authenticated.
+// returns a ParameterError if the query is nil.
+// returns a RequestError if there is an issue executing the query.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) RunQueryTyped(query *Query) (*QueryResult, error) {
+	ec, _, err := c.runQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return &QueryResult{
+		Context:      ec.NamespaceManager.AsContext(),
+		Entities:     ec.Entities,
+		Continuation: ec.Continuation,
+	}, nil
+}
+
+// RunQuery executes a query on the server and returns the raw decoded response, which is either
+// [context, entity] for a single entity lookup or [context, rows, continuations] where each row is
+// [startingEntity, predicate, entity]. The decoded response is returned as it is, also when RunQueryTyped
+// cannot parse it, for example [context, null] for a single entity lookup of an entity that does not exist.
+// It is kept for compatibility, use RunQueryTyped to get the response parsed into a QueryResult.
+// returns an AuthenticationError if the client is not authenticated.
+// returns a ParameterError if the query is nil.
+// returns a RequestError if there is an issue executing the query.
+// returns a ClientProcessingError if the response is not a JSON array.
+func (c *Client) RunQuery(query *Query) ([]any, error) {
+	_, raw, err := c.runQuery(query)
+	if raw != nil {
+		return raw, nil
+	}
+	return nil, err
+}
+
+// runQuery executes a query on the server and returns the response as an entity collection together with the
+// raw decoded response. The response is decoded once. If the decoded response cannot be parsed into an entity
+// collection the raw response is returned with the error.
+func (c *Client) runQuery(query *Query) (*egdm.EntityCollection, []any, error) {
+	if query == nil {
+		return nil, nil, &ParameterError{Msg: "query cannot be nil"}
+	}
+
 	data, err := json.Marshal(query)
 	if err != nil {
-		return nil, &ParameterError{Msg: "unable to marshal query", Err: err}
+		return nil, nil, &ParameterError{Msg: "unable to marshal query", Err: err}
 	}
 
 	err = c.checkToken()
 	if err != nil {
-		return nil, &AuthenticationError{Msg: "unable to authenticate", Err: err}
+		return nil, nil, &AuthenticationError{Msg: "unable to authenticate", Err: err}
 	}
 
 	client := c.makeHttpClient()
 	response, err := client.makeRequest(httpPost, "/query", data, nil, nil)
 	if err != nil {
-		return nil, &RequestError{Msg: "unable to execute query", Err: err}
+		return nil, nil, &RequestError{Msg: "unable to execute query", Err: err}
+	}
+
+	raw := make([]any, 0)
+	err = json.Unmarshal(response, &raw)
+	if err != nil {
+		return nil, nil, &ClientProcessingError{Msg: "unable to unmarshal query", Err: err}
 	}
 
-	result := make([]any, 0)
-	err = json.Unmarshal(response, &result)
+	ec, err := makeEntityCollectionFromQueryResult(raw)
 	if err != nil {
-		return nil, &ClientProcessingError{Msg: "unable to unmarshal query", Err: err}
+		return nil, raw, err
 	}
 
-	return result, nil
+	return ec, raw, nil
 }
@@ -2,8 +2,13 @@ package datahub
 
 import (
 	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	egdm "github.com/mimiro-io/entity-graph-data-model"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -76,6 +81,205 @@ func TestJavascriptQuery(t *testing.T) {
 	}
 }
 
+func TestEncodeJavascript(t *testing.T) {
+	src := "function do_query() { WriteQueryResult({key1: \"value1\"}); }"
+
+	encoded := EncodeJavascript(src)
+	if encoded != base64.StdEncoding.EncodeToString([]byte(src)) {
+		t.Errorf("expected standard base64 encoding, got '%s'", encoded)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(decoded) != src {
+		t.Errorf("expected decoded source to be '%s', got '%s'", src, string(decoded))
+	}
+}
+
+func TestJavascriptSource(t *testing.T) {
+	client := NewAdminUserConfiguredClient()
+
+	results, err := client.RunJavascriptSource(`function do_query() {
+							WriteQueryResult({key1: "value1"});
+						}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := results.Next()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if result["key1"] != "value1" {
+		t.Errorf("expected result to be 'value1', got '%s'", result["key1"])
+	}
+
+	err = results.Close()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJavascriptQueryDecode(t *testing.T) {
+	client := NewAdminUserConfiguredClient()
+
+	javascriptQuery := `function do_query() {
+							WriteQueryResult({name: "bob", age: 42});
+							WriteQueryResult({name: "alice", age: 37});
+						}`
+
+	// base64 encode the query
+	javascriptQuery = base64.StdEncoding.EncodeToString([]byte(javascriptQuery))
+
+	results, err := client.RunJavascriptQuery(javascriptQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type person struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+
+	p := &person{}
+	err = results.Decode(p)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if p.Name != "bob" || p.Age != 42 {
+		t.Errorf("expected bob aged 42, got '%s' aged %d", p.Name, p.Age)
+	}
+
+	p = &person{}
+	err = results.Decode(p)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if p.Name != "alice" || p.Age != 37 {
+		t.Errorf("expected alice aged 37, got '%s' aged %d", p.Name, p.Age)
+	}
+
+	// check no more
+	p = &person{}
+	err = results.Decode(p)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if p.Name != "" {
+		t.Errorf("expected no more results")
+	}
+
+	err = results.Close()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJavascriptQueryContinuation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `[{"key1":"value1"},{"key1":"value2"},{"id":"@continuation","token":"next-page"}]`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := client.RunJavascriptSource("function do_query() {}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	for {
+		result, err := results.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result == nil {
+			break
+		}
+		if result["id"] == "@continuation" {
+			t.Error("expected continuation not to be returned as a result")
+		}
+		count++
+	}
+
+	if count != 2 {
+		t.Errorf("expected 2 results, got %d", count)
+	}
+
+	if results.Continuation() != "next-page" {
+		t.Errorf("expected continuation to be 'next-page', got '%s'", results.Continuation())
+	}
+
+	err = results.Close()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJavascriptQueryStream(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body := map[string]string{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		switch body["continuation"] {
+		case "":
+			_, _ = fmt.Fprint(w, `[{"key1":"value1"},{"id":"@continuation","token":"page2"}]`)
+		case "page2":
+			_, _ = fmt.Fprint(w, `[{"key1":"value2"},{"id":"@continuation","token":"page3"}]`)
+		default:
+			_, _ = fmt.Fprint(w, `[{"key1":"value3"}]`)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := client.RunJavascriptQueryStream(EncodeJavascript("function do_query() {}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make([]string, 0)
+	for {
+		result, err := stream.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result == nil {
+			break
+		}
+		values = append(values, result["key1"].(string))
+	}
+
+	if len(values) != 3 || values[0] != "value1" || values[1] != "value2" || values[2] != "value3" {
+		t.Errorf("expected results from all three pages, got %v", values)
+	}
+
+	if requests != 3 {
+		t.Errorf("expected 3 requests, got %d", requests)
+	}
+
+	err = stream.Close()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
 func TestQueryForEntityById(t *testing.T) {
 	client := NewAdminUserConfiguredClient()
 
@@ -159,6 +363,57 @@ func TestForRelatedEntities(t *testing.T) {
 	}
 }
 
+func TestRunQueryTyped(t *testing.T) {
+	client := NewAdminUserConfiguredClient()
+
+	datasetName := "test-" + uuid.New().String()
+
+	err := client.AddDataset(datasetName, nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// make entity collection
+	namespaceManager := egdm.NewNamespaceContext()
+	prefixedId, err := namespaceManager.AssertPrefixedIdentifierFromURI("http://data.example.com/things/entity1")
+	ec := egdm.NewEntityCollection(namespaceManager)
+	entity := egdm.NewEntity().SetID(prefixedId)
+	entity.SetReference("http://data.example.com/things/related", "http://data.example.com/things/entity2")
+	err = ec.AddEntity(entity)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// store entities
+	err = client.StoreEntities(datasetName, ec)
+	if err != nil {
+		t.Error(err)
+	}
+
+	qb := NewQueryBuilder()
+	qb.WithStartingEntities([]string{"http://data.example.com/things/entity2"})
+	qb.WithPredicate("http://data.example.com/things/related")
+	qb.WithInverse(true)
+	qb.WithDatasets([]string{datasetName})
+
+	result, err := client.RunQueryTyped(qb.Build())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Context == nil {
+		t.Error("expected context to be populated")
+	}
+
+	if len(result.Entities) != 1 {
+		t.Fatalf("expected 1 entity, got %d", len(result.Entities))
+	}
+
+	if result.Entities[0].ID != "http://data.example.com/things/entity1" {
+		t.Errorf("expected entity id to be 'http://data.example.com/things/entity1', got '%s'", result.Entities[0].ID)
+	}
+}
+
 func TestStreamResultForHop(t *testing.T) {
 	client := NewAdminUserConfiguredClient()
 
@@ -225,3 +480,479 @@ func TestStreamResultForHop(t *testing.T) {
 		t.Errorf("expected entity to be nil, got '%s'", e3.ID)
 	}
 }
+
+func TestMultiHopQuery(t *testing.T) {
+	client := NewAdminUserConfiguredClient()
+
+	datasetName := "test-" + uuid.New().String()
+
+	err := client.AddDataset(datasetName, nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// make a chain entity1 --worksFor--> entity2 --locatedIn--> entity3
+	namespaceManager := egdm.NewNamespaceContext()
+	ec := egdm.NewEntityCollection(namespaceManager)
+
+	prefixedId, err := namespaceManager.AssertPrefixedIdentifierFromURI("http://data.example.com/things/entity1")
+	entity := egdm.NewEntity().SetID(prefixedId)
+	entity.SetReference("http://data.example.com/things/worksFor", "http://data.example.com/things/entity2")
+	err = ec.AddEntity(entity)
+	if err != nil {
+		t.Error(err)
+	}
+
+	prefixedId, err = namespaceManager.AssertPrefixedIdentifierFromURI("http://data.example.com/things/entity2")
+	entity = egdm.NewEntity().SetID(prefixedId)
+	entity.SetReference("http://data.example.com/things/locatedIn", "http://data.example.com/things/entity3")
+	err = ec.AddEntity(entity)
+	if err != nil {
+		t.Error(err)
+	}
+
+	prefixedId, err = namespaceManager.AssertPrefixedIdentifierFromURI("http://data.example.com/things/entity3")
+	err = ec.AddEntity(egdm.NewEntity().SetID(prefixedId))
+	if err != nil {
+		t.Error(err)
+	}
+
+	// store entities
+	err = client.StoreEntities(datasetName, ec)
+	if err != nil {
+		t.Error(err)
+	}
+
+	qb := NewQueryBuilder()
+	qb.WithStartingEntities([]string{"http://data.example.com/things/entity1"})
+	qb.WithDatasets([]string{datasetName})
+	qb.AddHop("http://data.example.com/things/worksFor", false)
+	qb.AddHop("http://data.example.com/things/locatedIn", false)
+
+	stream, err := client.RunMultiHopQuery(qb.Build())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e1, err := stream.Next()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if e1 == nil || e1.ID != "http://data.example.com/things/entity3" {
+		t.Errorf("expected entity3 at the end of the path, got %v", e1)
+	}
+
+	e2, err := stream.Next()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if e2 != nil {
+		t.Errorf("expected entity to be nil, got '%s'", e2.ID)
+	}
+}
+
+func TestMalformedQueryResult(t *testing.T) {
+	context := map[string]any{"namespaces": map[string]any{"ns0": "http://data.example.com/things/"}}
+	entity := map[string]any{"id": "ns0:entity1", "props": map[string]any{}, "refs": map[string]any{}}
+
+	payloads := map[string][]any{
+		"empty":                  {},
+		"error object":           {map[string]any{"message": "unauthorized"}},
+		"context not object":     {"context", []any{}, []any{}},
+		"missing namespaces":     {map[string]any{}, []any{}, []any{}},
+		"rows not array":         {context, "rows", []any{}},
+		"missing continuations":  {context, []any{}},
+		"continuations not list": {context, []any{}, "token"},
+		"row not array":          {context, []any{"row"}, []any{}},
+		"row too short":          {context, []any{[]any{"a", "b"}}, []any{}},
+		"row entity not object":  {context, []any{[]any{"a", "b", "c"}}, []any{}},
+		"props not object":       {context, []any{[]any{"a", "b", map[string]any{"id": "ns0:entity1", "props": "x"}}}, []any{}},
+		"token not string":       {context, []any{[]any{"a", "b", entity}}, []any{42.0}},
+	}
+
+	for name, payload := range payloads {
+		_, err := makeEntityCollectionFromQueryResult(payload)
+		if err == nil {
+			t.Errorf("%s: expected error for malformed payload", name)
+			continue
+		}
+
+		var processingError *ClientProcessingError
+		if !errors.As(err, &processingError) {
+			t.Errorf("%s: expected ClientProcessingError, got %T", name, err)
+		}
+	}
+
+	// check a well formed payload still parses
+	ec, err := makeEntityCollectionFromQueryResult([]any{context, []any{[]any{"a", "b", entity}}, []any{"token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ec.Entities) != 1 || ec.Entities[0].ID != "http://data.example.com/things/entity1" {
+		t.Errorf("expected entity1 to be parsed, got %v", ec.Entities)
+	}
+
+	if ec.Continuation == nil || ec.Continuation.Token != "token" {
+		t.Errorf("expected continuation token to be 'token'")
+	}
+}
+
+func TestCollectHopQuery(t *testing.T) {
+	client := NewAdminUserConfiguredClient()
+
+	datasetName := "test-" + uuid.New().String()
+
+	err := client.AddDataset(datasetName, nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	namespaceManager := egdm.NewNamespaceContext()
+	ec := egdm.NewEntityCollection(namespaceManager)
+	for _, id := range []string{"entity1", "entity2"} {
+		prefixedId, err := namespaceManager.AssertPrefixedIdentifierFromURI("http://data.example.com/things/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entity := egdm.NewEntity().SetID(prefixedId)
+		entity.SetReference("http://data.example.com/things/related", "http://data.example.com/things/entity3")
+		err = ec.AddEntity(entity)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	err = client.StoreEntities(datasetName, ec)
+	if err != nil {
+		t.Error(err)
+	}
+
+	stream, err := client.RunHopQuery("http://data.example.com/things/entity3", "http://data.example.com/things/related", []string{datasetName}, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entities, err := Collect(stream)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(entities) != 2 {
+		t.Fatalf("expected 2 entities, got %d", len(entities))
+	}
+
+	if entities[0].ID != "http://data.example.com/things/entity1" || entities[1].ID != "http://data.example.com/things/entity2" {
+		t.Errorf("unexpected entities %s, %s", entities[0].ID, entities[1].ID)
+	}
+}
+
+func TestCollectN(t *testing.T) {
+	ec := egdm.NewEntityCollection(nil)
+	for i := 0; i < 5; i++ {
+		_ = ec.AddEntity(egdm.NewEntity().SetID(fmt.Sprintf("http://data.example.com/things/entity%d", i)))
+	}
+	stream := &QueryResultEntitiesStream{currentCollection: ec}
+
+	first, err := CollectN(stream, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 3 {
+		t.Errorf("expected 3 entities, got %d", len(first))
+	}
+
+	rest, err := Collect(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 2 || rest[0].ID != "http://data.example.com/things/entity3" {
+		t.Errorf("expected the remaining 2 entities, got %d", len(rest))
+	}
+
+	_ = stream.Close()
+	_, err = Collect(stream)
+	if err == nil {
+		t.Error("expected error collecting from a closed stream")
+	}
+}
+
+func TestDeleteEntitiesMatching(t *testing.T) {
+	nsContext := map[string]any{"namespaces": map[string]any{"ns0": "http://data.example.com/things/"}}
+	row := func(id string) []any {
+		return []any{"http://data.example.com/things/parent", "http://data.example.com/things/parent", map[string]any{"id": id, "props": map[string]any{}, "refs": map[string]any{}}}
+	}
+	deleted := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/query":
+			query := &Query{}
+			_ = json.NewDecoder(r.Body).Decode(query)
+			page := []any{nsContext, []any{row("ns0:child1"), row("ns0:child2")}, []any{"c1"}}
+			if len(query.Continuations) == 1 && query.Continuations[0] == "c1" {
+				page = []any{nsContext, []any{row("ns0:child2"), row("ns0:child3")}, []any{}}
+			}
+			_ = json.NewEncoder(w).Encode(page)
+		case "/datasets/children/entities":
+			ec, err := egdm.NewEntityParser(egdm.NewNamespaceContext()).WithExpandURIs().LoadEntityCollection(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			for _, entity := range ec.Entities {
+				deleted[entity.ID] = entity.IsDeleted
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	qb := NewQueryBuilder()
+	qb.WithStartingEntities([]string{"http://data.example.com/things/parent"})
+	qb.WithPredicate("http://data.example.com/things/parent")
+	qb.WithInverse(true)
+
+	result, err := client.DeleteEntitiesMatching(qb.Build(), "children")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.EntitiesProcessed != 3 {
+		t.Errorf("expected 3 entities to be deleted, got %d", result.EntitiesProcessed)
+	}
+
+	for _, id := range []string{"child1", "child2", "child3"} {
+		if !deleted["http://data.example.com/things/"+id] {
+			t.Errorf("expected %s to be marked deleted", id)
+		}
+	}
+}
+
+func TestRunHopQueryWithOptions(t *testing.T) {
+	nsContext := map[string]any{"namespaces": map[string]any{"ns0": "http://data.example.com/things/"}}
+	row := func(id string) []any {
+		return []any{"http://data.example.com/things/entity3", "http://data.example.com/things/related", map[string]any{"id": id, "props": map[string]any{}, "refs": map[string]any{}}}
+	}
+	queries := make([]*Query, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := &Query{}
+		_ = json.NewDecoder(r.Body).Decode(query)
+		queries = append(queries, query)
+
+		page := []any{nsContext, []any{row("ns0:entity1"), row("ns0:entity2")}, []any{"c1"}}
+		if len(query.Continuations) > 0 {
+			page = []any{nsContext, []any{row("ns0:entity4")}, []any{}}
+		}
+		_ = json.NewEncoder(w).Encode(page)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := HopQueryOptions{Datasets: []string{"people"}, Inverse: true, Limit: 2, Details: true}
+	stream, err := client.RunHopQueryWithOptions("http://data.example.com/things/entity3", "http://data.example.com/things/related", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entities, err := Collect(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 3 {
+		t.Errorf("expected 3 entities, got %d", len(entities))
+	}
+
+	if len(queries) != 2 {
+		t.Fatalf("expected 2 queries, got %d", len(queries))
+	}
+	for i, query := range queries {
+		if !query.Details || !query.Inverse || query.Limit != 2 || query.Predicate != "http://data.example.com/things/related" ||
+			len(query.Datasets) != 1 || query.Datasets[0] != "people" {
+			t.Errorf("query %d did not keep the query parameters: %+v", i, query)
+		}
+	}
+	if len(queries[1].Continuations) != 1 || queries[1].Continuations[0] != "c1" {
+		t.Errorf("expected second query to use the continuation, got %v", queries[1].Continuations)
+	}
+}
+
+func TestQueryPaginationKeepsQueryParameters(t *testing.T) {
+	nsContext := map[string]any{"namespaces": map[string]any{"ns0": "http://data.example.com/things/"}}
+	const total = 25
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := &Query{}
+		_ = json.NewDecoder(r.Body).Decode(query)
+
+		// the simulated server needs the full query on every page
+		if query.Predicate != "http://data.example.com/things/related" || !query.Inverse || query.Limit != 10 ||
+			len(query.Datasets) != 1 || query.Datasets[0] != "people" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		offset := 0
+		if len(query.Continuations) == 1 {
+			_, _ = fmt.Sscanf(query.Continuations[0], "offset-%d", &offset)
+		}
+
+		rows := make([]any, 0)
+		for i := offset; i < min(offset+query.Limit, total); i++ {
+			entity := map[string]any{"id": fmt.Sprintf("ns0:entity%d", i), "props": map[string]any{}, "refs": map[string]any{}}
+			rows = append(rows, []any{query.StartingEntities, query.Predicate, entity})
+		}
+		continuations := []any{}
+		if offset+query.Limit < total {
+			continuations = append(continuations, fmt.Sprintf("offset-%d", offset+query.Limit))
+		}
+		_ = json.NewEncoder(w).Encode([]any{nsContext, rows, continuations})
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	qb := NewQueryBuilder()
+	qb.WithStartingEntities([]string{"http://data.example.com/things/root"})
+	qb.WithPredicate("http://data.example.com/things/related")
+	qb.WithInverse(true)
+	qb.WithDatasets([]string{"people"})
+	qb.WithLimit(10)
+
+	stream, err := client.RunStreamingQuery(qb.Build())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entities, err := Collect(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != total {
+		t.Errorf("expected %d entities from the stream, got %d", total, len(entities))
+	}
+
+	// multi-hop queries page through each hop the same way
+	hops := NewQueryBuilder()
+	hops.WithStartingEntities([]string{"http://data.example.com/things/root"})
+	hops.WithDatasets([]string{"people"})
+	hops.WithLimit(10)
+	hops.AddHop("http://data.example.com/things/related", true)
+
+	stream, err = client.RunMultiHopQuery(hops.Build())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entities, err = Collect(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != total {
+		t.Errorf("expected %d entities from the multi-hop query, got %d", total, len(entities))
+	}
+}
+
+func TestRunQueryDecodesResponseOnce(t *testing.T) {
+	nsContext := map[string]any{"id": "@context", "namespaces": map[string]any{"ns0": "http://data.example.com/things/"}}
+	entity := map[string]any{"id": "ns0:entity1", "props": map[string]any{}, "refs": map[string]any{}}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		row := []any{"http://data.example.com/things/root", "http://data.example.com/things/related", entity}
+		_ = json.NewEncoder(w).Encode([]any{nsContext, []any{row}, []any{"c1"}})
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	qb := NewQueryBuilder()
+	qb.WithStartingEntities([]string{"http://data.example.com/things/root"})
+	qb.WithPredicate("http://data.example.com/things/related")
+
+	result, err := client.RunQueryTyped(qb.Build())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Entities) != 1 || result.Entities[0].ID != "http://data.example.com/things/entity1" {
+		t.Errorf("expected entity1 in the typed result, got %v", result.Entities)
+	}
+	if result.Continuation == nil || result.Continuation.Token != "c1" {
+		t.Errorf("expected continuation c1, got %v", result.Continuation)
+	}
+
+	raw, err := client.RunQuery(qb.Build())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 3 {
+		t.Fatalf("expected the raw response with 3 parts, got %v", raw)
+	}
+	rows, ok := raw[1].([]any)
+	if !ok || len(rows) != 1 {
+		t.Errorf("expected one raw row, got %v", raw[1])
+	}
+
+	if requests != 2 {
+		t.Errorf("expected one request per query, got %d", requests)
+	}
+
+	_, err = client.RunQuery(nil)
+	var paramErr *ParameterError
+	if !errors.As(err, &paramErr) {
+		t.Errorf("expected a ParameterError for a nil query, got %v", err)
+	}
+}
+
+func TestRunQueryReturnsUnparsedResponse(t *testing.T) {
+	nsContext := map[string]any{"id": "@context", "namespaces": map[string]any{"ns0": "http://data.example.com/things/"}}
+	responses := [][]any{
+		// a single entity lookup of an entity that does not exist
+		{nsContext, nil},
+		// a row without an entity object
+		{nsContext, []any{[]any{"http://data.example.com/things/root", "http://data.example.com/things/related", "ns0:entity1"}}, []any{}},
+	}
+	var response []any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	qb := NewQueryBuilder()
+	qb.WithEntityId("http://data.example.com/things/missing")
+
+	for _, response = range responses {
+		raw, err := client.RunQuery(qb.Build())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(raw) != len(response) {
+			t.Errorf("expected the decoded response %v, got %v", response, raw)
+		}
+
+		_, err = client.RunQueryTyped(qb.Build())
+		var processingErr *ClientProcessingError
+		if !errors.As(err, &processingErr) {
+			t.Errorf("expected a ClientProcessingError from RunQueryTyped for %v, got %v", response, err)
+		}
+	}
+}
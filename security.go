@@ -1,9 +1,17 @@
 package datahub
 
 import (
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/rsa"
 	"encoding/json"
+	"errors"
+	"net/http"
 	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
 )
 
 // AccessControl is a struct that represents a single access control rule for a single resource
@@ -16,6 +24,102 @@ type AccessControl struct {
 	Deny bool
 }
 
+// AccessControlBuilder is a builder for access control rules.
+// The dataset functions take a dataset name or a pattern ending in *, for example "people" or "people-*", and
+// create the rule for the dataset resources. Allow and Deny take a resource path for rules on other resources.
+// The first invalid rule is reported by Build.
+type AccessControlBuilder struct {
+	acls []AccessControl
+	err  error
+}
+
+// NewAccessControlBuilder creates a new AccessControlBuilder.
+// Use the Allow and Deny functions to add rules then call Build to get the rules for SetClientAcl
+func NewAccessControlBuilder() *AccessControlBuilder {
+	return &AccessControlBuilder{acls: make([]AccessControl, 0)}
+}
+
+// AllowRead adds a rule allowing to read the datasets matching datasetPattern
+func (ab *AccessControlBuilder) AllowRead(datasetPattern string) *AccessControlBuilder {
+	return ab.addDatasetRule("read", datasetPattern, false)
+}
+
+// AllowWrite adds a rule allowing to write to the datasets matching datasetPattern
+func (ab *AccessControlBuilder) AllowWrite(datasetPattern string) *AccessControlBuilder {
+	return ab.addDatasetRule("write", datasetPattern, false)
+}
+
+// DenyRead adds a rule denying to read the datasets matching datasetPattern
+func (ab *AccessControlBuilder) DenyRead(datasetPattern string) *AccessControlBuilder {
+	return ab.addDatasetRule("read", datasetPattern, true)
+}
+
+// DenyWrite adds a rule denying to write to the datasets matching datasetPattern
+func (ab *AccessControlBuilder) DenyWrite(datasetPattern string) *AccessControlBuilder {
+	return ab.addDatasetRule("write", datasetPattern, true)
+}
+
+// Allow adds a rule allowing the action, "read" or "write", on the resource.
+// resource is a path starting with / that may end in * to match all paths with the same prefix, e.g. "/jobs/*"
+func (ab *AccessControlBuilder) Allow(action string, resource string) *AccessControlBuilder {
+	return ab.addRule(action, resource, false)
+}
+
+// Deny adds a rule denying the action, "read" or "write", on the resource.
+// resource is a path starting with / that may end in * to match all paths with the same prefix, e.g. "/jobs/*"
+func (ab *AccessControlBuilder) Deny(action string, resource string) *AccessControlBuilder {
+	return ab.addRule(action, resource, true)
+}
+
+// Build returns the rules in the order they were added.
+// returns a ParameterError if an action, dataset pattern or resource is not valid.
+func (ab *AccessControlBuilder) Build() ([]AccessControl, error) {
+	if ab.err != nil {
+		return nil, ab.err
+	}
+	return ab.acls, nil
+}
+
+// addDatasetRule adds a rule for the resources of the datasets matching datasetPattern. A dataset name matches
+// the dataset and everything below it, a pattern ending in * matches all datasets with the prefix
+func (ab *AccessControlBuilder) addDatasetRule(action string, datasetPattern string, deny bool) *AccessControlBuilder {
+	if ab.err != nil {
+		return ab
+	}
+
+	if datasetPattern == "" || strings.Contains(datasetPattern, "/") {
+		ab.err = &ParameterError{Msg: "invalid dataset pattern '" + datasetPattern + "', expected a dataset name or a prefix ending in *"}
+		return ab
+	}
+
+	resource := "/datasets/" + datasetPattern
+	if !strings.HasSuffix(datasetPattern, "*") {
+		resource += "/*"
+	}
+	return ab.addRule(action, resource, deny)
+}
+
+// addRule validates and adds a rule
+func (ab *AccessControlBuilder) addRule(action string, resource string, deny bool) *AccessControlBuilder {
+	if ab.err != nil {
+		return ab
+	}
+
+	if action != "read" && action != "write" {
+		ab.err = &ParameterError{Msg: "invalid action '" + action + "', expected read or write"}
+		return ab
+	}
+
+	if !strings.HasPrefix(resource, "/") || strings.Contains(resource, "//") ||
+		strings.ContainsFunc(resource, unicode.IsSpace) || strings.Contains(strings.TrimSuffix(resource, "*"), "*") {
+		ab.err = &ParameterError{Msg: "invalid resource '" + resource + "', expected a path starting with / with * only at the end"}
+		return ab
+	}
+
+	ab.acls = append(ab.acls, AccessControl{Resource: resource, Action: action, Deny: deny})
+	return ab
+}
+
 // ClientInfo is a struct that represents a single client, including the client ID and public key
 type ClientInfo struct {
 	// ClientId is the unique ID of the client on the server
@@ -51,6 +155,51 @@ func (c *Client) GetClients() (map[string]ClientInfo, error) {
 	return clients, nil
 }
 
+// GetClient returns the details of a single client.
+// The server has no endpoint for a single client, so the clients are fetched with GetClients and filtered.
+// clientID is the unique id of the client.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the clientID is empty
+// returns a NotFoundError if there is no client with the id or it has been deleted.
+// returns a RequestError if the request fails.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) GetClient(clientID string) (*ClientInfo, error) {
+	if clientID == "" {
+		return nil, &ParameterError{Msg: "clientID cannot be empty"}
+	}
+
+	clients, err := c.GetClients()
+	if err != nil {
+		return nil, err
+	}
+
+	clientInfo, ok := clients[clientID]
+	if !ok || clientInfo.Deleted {
+		return nil, &NotFoundError{Msg: "client " + clientID + " not found"}
+	}
+
+	return &clientInfo, nil
+}
+
+// ClientExists checks if a client is registered in the data hub
+// clientID is the unique id of the client to check
+// returns true if the client exists and false if it does not or has been deleted.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the clientID is empty
+// returns a RequestError if the request fails.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) ClientExists(clientID string) (bool, error) {
+	_, err := c.GetClient(clientID)
+	if err != nil {
+		var notFoundErr *NotFoundError
+		if errors.As(err, &notFoundErr) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 // AddClient stores the client ID and optional public key of a client.
 // clientID is the unique id of the client to be added.
 // publicKey is the client public key (optional).
@@ -59,6 +208,28 @@ func (c *Client) GetClients() (map[string]ClientInfo, error) {
 // returns a RequestError if the request fails.
 // returns a ClientProcessingError if the response cannot be processed.
 func (c *Client) AddClient(clientID string, publicKey *rsa.PublicKey) error {
+	if publicKey == nil {
+		return c.addClient(clientID, nil)
+	}
+	return c.addClient(clientID, publicKey)
+}
+
+// AddECClient stores the client ID and optional ECDSA public key of a client.
+// clientID is the unique id of the client to be added.
+// publicKey is the client public key (optional).
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the clientID is empty
+// returns a RequestError if the request fails.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) AddECClient(clientID string, publicKey *ecdsa.PublicKey) error {
+	if publicKey == nil {
+		return c.addClient(clientID, nil)
+	}
+	return c.addClient(clientID, publicKey)
+}
+
+// addClient stores the client with a public key of any supported type, publicKey is nil if there is no key
+func (c *Client) addClient(clientID string, publicKey crypto.PublicKey) error {
 	if clientID == "" {
 		return &ParameterError{Msg: "clientID cannot be empty"}
 	}
@@ -71,7 +242,7 @@ func (c *Client) AddClient(clientID string, publicKey *rsa.PublicKey) error {
 	clientInfo := &ClientInfo{}
 	clientInfo.ClientId = clientID
 	if publicKey != nil {
-		publicKeyBytes, err := exportRsaPublicKeyAsPem(publicKey)
+		publicKeyBytes, err := exportPublicKeyAsPem(publicKey)
 		if err != nil {
 			return &ParameterError{Msg: "unable to export public key", Err: err}
 		}
@@ -181,6 +352,63 @@ func (c *Client) GetClientAcl(clientID string) ([]AccessControl, error) {
 	return acls, nil
 }
 
+// AppendClientAcl adds access control rules to the rules of the specified client.
+// The current rules are read and written back with the new rules appended, rules that are already present are
+// not added again. The read and write are separate requests, so concurrent changes to the same client can be lost.
+// clientID is the unique id of the client.
+// acls is a slice of AccessControl structs that represent the access control rules to be added.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the clientID is empty
+// returns a RequestError if the request fails.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) AppendClientAcl(clientID string, acls []AccessControl) error {
+	current, err := c.GetClientAcl(clientID)
+	if err != nil {
+		return err
+	}
+
+	updated := current
+	for _, acl := range acls {
+		if !slices.Contains(updated, acl) {
+			updated = append(updated, acl)
+		}
+	}
+	if len(updated) == len(current) {
+		return nil
+	}
+
+	return c.SetClientAcl(clientID, updated)
+}
+
+// RemoveClientAcl removes access control rules from the rules of the specified client.
+// The current rules are read and written back without the rules that are identical to one of acls, rules that
+// are not present are ignored. The read and write are separate requests, so concurrent changes to the same
+// client can be lost.
+// clientID is the unique id of the client.
+// acls is a slice of AccessControl structs that represent the access control rules to be removed.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the clientID is empty
+// returns a RequestError if the request fails.
+// returns a ClientProcessingError if the response cannot be processed.
+func (c *Client) RemoveClientAcl(clientID string, acls []AccessControl) error {
+	current, err := c.GetClientAcl(clientID)
+	if err != nil {
+		return err
+	}
+
+	updated := make([]AccessControl, 0, len(current))
+	for _, acl := range current {
+		if !slices.Contains(acls, acl) {
+			updated = append(updated, acl)
+		}
+	}
+	if len(updated) == len(current) {
+		return nil
+	}
+
+	return c.SetClientAcl(clientID, updated)
+}
+
 type ProviderConfig struct {
 	Name         string       `json:"name"`
 	Type         string       `json:"type"`
@@ -192,11 +420,112 @@ type ProviderConfig struct {
 	Endpoint     *ValueReader `json:"endpoint,omitempty"`
 }
 
+// ValueReader tells the server where to read a provider value from, so that secrets do not have to be stored in
+// the provider configuration. Type is one of the ValueType constants and Value is the value itself, or the name
+// of the variable, parameter or secret to read it from. Use TextValue, EnvValue, SsmValue or SecretValue to
+// create one.
 type ValueReader struct {
 	Type  string `json:"type"`
 	Value string `json:"value"`
 }
 
+const (
+	// ValueTypeText is a value stored as is in the provider configuration
+	ValueTypeText = "text"
+	// ValueTypeEnv is a value read from an environment variable of the server
+	ValueTypeEnv = "env"
+	// ValueTypeSsm is a value read from a parameter in the AWS SSM parameter store of the server
+	ValueTypeSsm = "ssm"
+	// ValueTypeSecret is a value read from the secret store configured on the server
+	ValueTypeSecret = "secret"
+)
+
+// TextValue returns a ValueReader for a value that is stored as is in the provider configuration
+func TextValue(value string) *ValueReader {
+	return &ValueReader{Type: ValueTypeText, Value: value}
+}
+
+// EnvValue returns a ValueReader for a value the server reads from the environment variable envVarName
+func EnvValue(envVarName string) *ValueReader {
+	return &ValueReader{Type: ValueTypeEnv, Value: envVarName}
+}
+
+// SsmValue returns a ValueReader for a value the server reads from the AWS SSM parameter at path
+func SsmValue(path string) *ValueReader {
+	return &ValueReader{Type: ValueTypeSsm, Value: path}
+}
+
+// SecretValue returns a ValueReader for a value the server reads from its secret store with key
+func SecretValue(key string) *ValueReader {
+	return &ValueReader{Type: ValueTypeSecret, Value: key}
+}
+
+const (
+	// ProviderTypeBasic is a provider that authenticates with a user and password
+	ProviderTypeBasic = "basic"
+	// ProviderTypeToken is a provider that gets a token from an OAuth endpoint with a client id and secret
+	ProviderTypeToken = "token"
+	// ProviderTypeNodeBearer is a provider that gets a token from another data hub, authenticating with the
+	// key pair of this data hub
+	ProviderTypeNodeBearer = "nodebearer"
+)
+
+// NewBasicProvider creates the configuration of a provider that authenticates with a user and password.
+// returns a ParameterError if the name is empty or the user or password is not set.
+func NewBasicProvider(name string, user *ValueReader, password *ValueReader) (*ProviderConfig, error) {
+	err := requireProviderValues(name, map[string]*ValueReader{"user": user, "password": password})
+	if err != nil {
+		return nil, err
+	}
+	return &ProviderConfig{Name: name, Type: ProviderTypeBasic, User: user, Password: password}, nil
+}
+
+// NewTokenProvider creates the configuration of a provider that gets a token from an OAuth endpoint using the
+// client credentials flow.
+// audience is optional and only sent if it is not nil.
+// returns a ParameterError if the name is empty or the client id, client secret or endpoint is not set.
+func NewTokenProvider(name string, clientId *ValueReader, clientSecret *ValueReader, audience *ValueReader, endpoint *ValueReader) (*ProviderConfig, error) {
+	err := requireProviderValues(name, map[string]*ValueReader{"client id": clientId, "client secret": clientSecret, "endpoint": endpoint})
+	if err != nil {
+		return nil, err
+	}
+	return &ProviderConfig{Name: name, Type: ProviderTypeToken, ClientId: clientId, ClientSecret: clientSecret,
+		Audience: audience, Endpoint: endpoint}, nil
+}
+
+// NewNodeBearerProvider creates the configuration of a provider that gets a token from the endpoint of another
+// data hub, signing the request with the key pair of this data hub. clientId is the id this data hub is
+// registered with on the other data hub.
+// audience is optional and only sent if it is not nil.
+// returns a ParameterError if the name is empty or the client id or endpoint is not set.
+func NewNodeBearerProvider(name string, clientId *ValueReader, audience *ValueReader, endpoint *ValueReader) (*ProviderConfig, error) {
+	err := requireProviderValues(name, map[string]*ValueReader{"client id": clientId, "endpoint": endpoint})
+	if err != nil {
+		return nil, err
+	}
+	return &ProviderConfig{Name: name, Type: ProviderTypeNodeBearer, ClientId: clientId, Audience: audience,
+		Endpoint: endpoint}, nil
+}
+
+// requireProviderValues checks the provider name and that each of the required values is set
+func requireProviderValues(name string, values map[string]*ValueReader) error {
+	if name == "" {
+		return &ParameterError{Msg: "provider name cannot be empty"}
+	}
+
+	missing := make([]string, 0)
+	for field, value := range values {
+		if value == nil || value.Value == "" {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return &ParameterError{Msg: "provider " + name + " is missing " + strings.Join(missing, ", ")}
+	}
+	return nil
+}
+
 // AddTokenProvider returns the access control rules for the specified client.
 // tokenProviderConfig is a single token provider configuration to be added.
 // returns an AuthenticationError if the client is unable to authenticate.
@@ -279,6 +608,43 @@ func (c *Client) GetTokenProvider(name string) (*ProviderConfig, error) {
 	return provider, nil
 }
 
+// TestTokenProvider asks the server to obtain a token with the specified token provider, to check its credentials
+// and endpoint when it is configured instead of when a job using it fails.
+// name is the name of the token provider to test.
+// returns an AuthenticationError if the client is unable to authenticate.
+// returns a ParameterError if the name is empty
+// returns a NotFoundError if there is no token provider with the name.
+// returns a RequestError if the provider fails to obtain a token, with the reason given by the server, or if the
+// request fails.
+func (c *Client) TestTokenProvider(name string) error {
+	if name == "" {
+		return &ParameterError{Msg: "token provider name cannot be empty"}
+	}
+
+	err := c.checkToken()
+	if err != nil {
+		return &AuthenticationError{Err: err, Msg: "unable to authenticate"}
+	}
+
+	client := c.makeHttpClient()
+	escapedName := url.QueryEscape(name)
+	_, err = client.makeRequest(httpPost, "/provider/login/"+escapedName+"/test", nil, nil, nil)
+	if err != nil {
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) {
+			if statusErr.statusCode == http.StatusNotFound {
+				return &NotFoundError{Msg: "token provider " + name + " not found", Err: err}
+			}
+			if statusErr.serverErr != nil && statusErr.serverErr.Message != "" {
+				return &RequestError{Msg: "token provider " + name + " failed to obtain a token: " + statusErr.serverErr.Message, Err: err}
+			}
+		}
+		return &RequestError{Msg: "token provider " + name + " failed to obtain a token", Err: err}
+	}
+
+	return nil
+}
+
 // SetTokenProvider sets the specified token provider.
 // name is the name of the token provider to be set.
 // tokenProviderConfig is the token provider configuration to be set.
@@ -1,7 +1,13 @@
 package datahub
 
 import (
+	"encoding/json"
+	"errors"
 	"github.com/google/uuid"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -45,6 +51,76 @@ func TestAddClient(t *testing.T) {
 	}
 }
 
+func TestGetClient(t *testing.T) {
+	client := NewAdminUserConfiguredClient()
+	_, publicKey, err := client.GenerateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientID := "client-" + uuid.New().String()
+	err = client.AddClient(clientID, publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientInfo, err := client.GetClient(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientInfo.ClientId != clientID {
+		t.Errorf("expected client '%s', got '%s'", clientID, clientInfo.ClientId)
+	}
+
+	_, err = client.GetClient("client-" + uuid.New().String())
+	var notFoundErr *NotFoundError
+	if !errors.As(err, &notFoundErr) {
+		t.Errorf("expected a NotFoundError, got %v", err)
+	}
+
+	_, err = client.GetClient("")
+	var paramErr *ParameterError
+	if !errors.As(err, &paramErr) {
+		t.Errorf("expected a ParameterError, got %v", err)
+	}
+}
+
+func TestClientExists(t *testing.T) {
+	client := NewAdminUserConfiguredClient()
+	clientID := "client-" + uuid.New().String()
+
+	exists, err := client.ClientExists(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Errorf("expected client '%s' not to exist", clientID)
+	}
+
+	err = client.AddClient(clientID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err = client.ClientExists(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Errorf("expected client '%s' to exist", clientID)
+	}
+
+	err = client.DeleteClient(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err = client.ClientExists(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Errorf("expected deleted client '%s' not to exist", clientID)
+	}
+}
+
 func TestDeleteClient(t *testing.T) {
 	client := NewAdminUserConfiguredClient()
 	_, publicKey, err := client.GenerateKeypair()
@@ -138,6 +214,97 @@ func TestSetClientAcl(t *testing.T) {
 	}
 }
 
+func TestAppendAndRemoveClientAcl(t *testing.T) {
+	client := NewAdminUserConfiguredClient()
+	clientID := "client-" + uuid.New().String()
+	err := client.AddClient(clientID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readPeople := AccessControl{Action: "read", Resource: "/datasets/people/*"}
+	writePeople := AccessControl{Action: "write", Resource: "/datasets/people/*"}
+	readPlaces := AccessControl{Action: "read", Resource: "/datasets/places/*"}
+	err = client.SetClientAcl(clientID, []AccessControl{readPeople})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.AppendClientAcl(clientID, []AccessControl{readPeople, writePeople, readPlaces})
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessOnServer, err := client.GetClientAcl(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []AccessControl{readPeople, writePeople, readPlaces}
+	if !reflect.DeepEqual(accessOnServer, expected) {
+		t.Errorf("expected %v, got %v", expected, accessOnServer)
+	}
+
+	err = client.RemoveClientAcl(clientID, []AccessControl{writePeople, {Action: "write", Resource: "/datasets/other/*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessOnServer, err = client.GetClientAcl(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []AccessControl{readPeople, readPlaces}
+	if !reflect.DeepEqual(accessOnServer, expected) {
+		t.Errorf("expected %v, got %v", expected, accessOnServer)
+	}
+
+	err = client.AppendClientAcl("", []AccessControl{readPeople})
+	var paramErr *ParameterError
+	if !errors.As(err, &paramErr) {
+		t.Errorf("expected a ParameterError, got %v", err)
+	}
+}
+
+func TestAccessControlBuilder(t *testing.T) {
+	acls, err := NewAccessControlBuilder().
+		AllowRead("people").
+		AllowWrite("people-*").
+		DenyRead("*").
+		DenyWrite("secrets").
+		Allow("read", "/jobs/*").
+		Deny("write", "/namespaces").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []AccessControl{
+		{Resource: "/datasets/people/*", Action: "read"},
+		{Resource: "/datasets/people-*", Action: "write"},
+		{Resource: "/datasets/*", Action: "read", Deny: true},
+		{Resource: "/datasets/secrets/*", Action: "write", Deny: true},
+		{Resource: "/jobs/*", Action: "read"},
+		{Resource: "/namespaces", Action: "write", Deny: true},
+	}
+	if !reflect.DeepEqual(acls, expected) {
+		t.Errorf("expected %v, got %v", expected, acls)
+	}
+
+	invalid := map[string]*AccessControlBuilder{
+		"empty dataset":       NewAccessControlBuilder().AllowRead(""),
+		"dataset path":        NewAccessControlBuilder().AllowRead("people/entities"),
+		"glob in the middle":  NewAccessControlBuilder().AllowWrite("peo*ple"),
+		"relative resource":   NewAccessControlBuilder().Allow("read", "datasets/people/*"),
+		"resource with space": NewAccessControlBuilder().Allow("read", "/datasets/peo ple/*"),
+		"unknown action":      NewAccessControlBuilder().AllowRead("people").Deny("delete", "/jobs/*"),
+	}
+	for name, builder := range invalid {
+		_, err := builder.Build()
+		var paramErr *ParameterError
+		if !errors.As(err, &paramErr) {
+			t.Errorf("%s: expected a ParameterError, got %v", name, err)
+		}
+	}
+}
+
 func TestAddTokenProvider(t *testing.T) {
 	client := NewAdminUserConfiguredClient()
 
@@ -228,6 +395,74 @@ func TestAddTokenProvider(t *testing.T) {
 	}
 }
 
+func TestProviderBuilders(t *testing.T) {
+	value := func(v string) *ValueReader {
+		return &ValueReader{Type: "string", Value: v}
+	}
+
+	provider, err := NewBasicProvider("basic-provider", value("user"), value("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &ProviderConfig{Name: "basic-provider", Type: "basic", User: value("user"), Password: value("password")}
+	if !reflect.DeepEqual(provider, expected) {
+		t.Errorf("expected %+v, got %+v", expected, provider)
+	}
+
+	provider, err = NewTokenProvider("token-provider", value("key"), value("secret"), nil, value("https://auth.example.com/token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = &ProviderConfig{Name: "token-provider", Type: "token", ClientId: value("key"), ClientSecret: value("secret"),
+		Endpoint: value("https://auth.example.com/token")}
+	if !reflect.DeepEqual(provider, expected) {
+		t.Errorf("expected %+v, got %+v", expected, provider)
+	}
+
+	provider, err = NewNodeBearerProvider("node-provider", value("node1"), value("node2"), value("https://node2.example.com/security/token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = &ProviderConfig{Name: "node-provider", Type: "nodebearer", ClientId: value("node1"), Audience: value("node2"),
+		Endpoint: value("https://node2.example.com/security/token")}
+	if !reflect.DeepEqual(provider, expected) {
+		t.Errorf("expected %+v, got %+v", expected, provider)
+	}
+
+	var paramErr *ParameterError
+	_, err = NewBasicProvider("", value("user"), value("password"))
+	if !errors.As(err, &paramErr) {
+		t.Errorf("expected a ParameterError for an empty name, got %v", err)
+	}
+	_, err = NewTokenProvider("token-provider", value("key"), nil, nil, value(""))
+	if !errors.As(err, &paramErr) || !strings.Contains(err.Error(), "missing client secret, endpoint") {
+		t.Errorf("expected a ParameterError naming the missing values, got %v", err)
+	}
+	_, err = NewNodeBearerProvider("node-provider", nil, nil, value("https://node2.example.com/security/token"))
+	if !errors.As(err, &paramErr) {
+		t.Errorf("expected a ParameterError for a missing client id, got %v", err)
+	}
+}
+
+func TestValueReaders(t *testing.T) {
+	provider, err := NewTokenProvider("token-provider", TextValue("key"), SecretValue("auth/client-secret"),
+		EnvValue("AUTH_AUDIENCE"), SsmValue("/auth/endpoint"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"name":"token-provider","type":"token","key":{"type":"text","value":"key"},` +
+		`"secret":{"type":"secret","value":"auth/client-secret"},"audience":{"type":"env","value":"AUTH_AUDIENCE"},` +
+		`"endpoint":{"type":"ssm","value":"/auth/endpoint"}}`
+	if string(data) != expected {
+		t.Errorf("expected %s, got %s", expected, data)
+	}
+}
+
 func TestGetTokenProvider(t *testing.T) {
 	client := NewAdminUserConfiguredClient()
 
@@ -352,3 +587,48 @@ func TestDeleteTokenProvider(t *testing.T) {
 	}
 
 }
+
+func TestTestTokenProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method != http.MethodPost:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case r.URL.Path == "/provider/login/working/test":
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/provider/login/bad-secret/test":
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write([]byte(`{"message":"token endpoint returned 401 invalid_client"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.TestTokenProvider("working")
+	if err != nil {
+		t.Errorf("expected the provider to work, got %v", err)
+	}
+
+	err = client.TestTokenProvider("bad-secret")
+	var requestErr *RequestError
+	if !errors.As(err, &requestErr) || !strings.Contains(err.Error(), "invalid_client") {
+		t.Errorf("expected a RequestError with the reason, got %v", err)
+	}
+
+	err = client.TestTokenProvider("missing")
+	var notFoundErr *NotFoundError
+	if !errors.As(err, &notFoundErr) {
+		t.Errorf("expected a NotFoundError, got %v", err)
+	}
+
+	err = client.TestTokenProvider("")
+	var paramErr *ParameterError
+	if !errors.As(err, &paramErr) {
+		t.Errorf("expected a ParameterError, got %v", err)
+	}
+}
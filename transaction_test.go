@@ -1,8 +1,12 @@
 package datahub
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/google/uuid"
 	egdm "github.com/mimiro-io/entity-graph-data-model"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -63,3 +67,247 @@ func TestProcessTransaction(t *testing.T) {
 	}
 
 }
+
+func TestProcessTransactionWithResult(t *testing.T) {
+	client := NewAdminUserConfiguredClient()
+
+	datasetId := "dataset-" + uuid.New().String()
+	err := client.AddDataset(datasetId, nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// create a transaction with a single entity
+	txn := NewTransaction()
+	entityId, err := txn.NamespaceManager.AssertPrefixedIdentifierFromURI("http://data.example.io/entity1")
+	entity := egdm.NewEntity().SetID(entityId)
+	txn.DatasetEntities[datasetId] = append(txn.DatasetEntities[datasetId], entity)
+
+	result, err := client.ProcessTransactionWithResult(txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.TransactionID == "" {
+		t.Error("expected transaction id to be populated")
+	}
+
+	if result.DatasetCounts[datasetId] != 1 {
+		t.Errorf("expected 1 entity written to dataset, got %d", result.DatasetCounts[datasetId])
+	}
+}
+
+func TestProcessLargeTransactionInChunks(t *testing.T) {
+	requestSizes := make([]int, 0)
+	received := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := make(map[string]json.RawMessage)
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		size := 0
+		for key, value := range body {
+			if key == "@context" {
+				continue
+			}
+			var entities []map[string]any
+			_ = json.Unmarshal(value, &entities)
+			size += len(entities)
+			received[key] += len(entities)
+		}
+		requestSizes = append(requestSizes, size)
+		_, _ = fmt.Fprintf(w, `{"transactionId":"txn-%d"}`, len(requestSizes))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.WithMaxTransactionEntities(100)
+
+	// create a transaction spanning three datasets
+	txn := NewTransaction()
+	datasets := map[string]int{"people": 120, "places": 75, "things": 55}
+	for dataset, count := range datasets {
+		for i := 0; i < count; i++ {
+			entityId, err := txn.NamespaceManager.AssertPrefixedIdentifierFromURI(fmt.Sprintf("http://data.example.io/%s/%d", dataset, i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			txn.DatasetEntities[dataset] = append(txn.DatasetEntities[dataset], egdm.NewEntity().SetID(entityId))
+		}
+	}
+
+	result, err := client.ProcessTransactionWithResult(txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(requestSizes) != 3 {
+		t.Errorf("expected 3 requests, got %d", len(requestSizes))
+	}
+
+	for _, size := range requestSizes {
+		if size > 100 {
+			t.Errorf("expected at most 100 entities per request, got %d", size)
+		}
+	}
+
+	for dataset, count := range datasets {
+		if received[dataset] != count {
+			t.Errorf("expected server to receive %d entities for %s, got %d", count, dataset, received[dataset])
+		}
+		if result.DatasetCounts[dataset] != count {
+			t.Errorf("expected result count %d for %s, got %d", count, dataset, result.DatasetCounts[dataset])
+		}
+	}
+
+	if len(result.TransactionIDs) != 3 || result.TransactionID != "txn-1" {
+		t.Errorf("expected 3 transaction ids starting with txn-1, got %v", result.TransactionIDs)
+	}
+}
+
+func TestTransactionBuilder(t *testing.T) {
+	tb := NewTransactionBuilder()
+
+	person, err := tb.AddEntityFromURI("people", "http://data.example.io/people/bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	person.SetProperty("http://data.example.io/schema/name", "bob")
+	person.SetReference("http://data.example.io/schema/livesIn", "http://data.example.io/places/oslo")
+
+	place := egdm.NewEntity().SetID("http://data.example.io/places/oslo")
+	place.SetReference("http://data.example.io/schema/near", []string{"http://data.example.io/places/bergen"})
+	tb.AddEntity("places", place)
+	tb.AddEntity("places", egdm.NewEntity().SetID("http://data.example.io/places/bergen"))
+
+	txn, err := tb.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(txn.DatasetEntities["people"]) != 1 {
+		t.Errorf("expected 1 entity in people, got %d", len(txn.DatasetEntities["people"]))
+	}
+
+	if len(txn.DatasetEntities["places"]) != 2 {
+		t.Errorf("expected 2 entities in places, got %d", len(txn.DatasetEntities["places"]))
+	}
+
+	// all identifiers should be prefixed and resolve back to the original URIs
+	nsManager := txn.NamespaceManager
+	for _, entities := range txn.DatasetEntities {
+		for _, entity := range entities {
+			if nsManager.IsFullUri(entity.ID) {
+				t.Errorf("expected entity id to be prefixed, got '%s'", entity.ID)
+			}
+		}
+	}
+
+	fullId, err := nsManager.GetFullURI(txn.DatasetEntities["places"][0].ID)
+	if err != nil {
+		t.Error(err)
+	}
+	if fullId != "http://data.example.io/places/oslo" {
+		t.Errorf("expected id to expand to 'http://data.example.io/places/oslo', got '%s'", fullId)
+	}
+
+	for key := range person.Properties {
+		if nsManager.IsFullUri(key) {
+			t.Errorf("expected property name to be prefixed, got '%s'", key)
+		}
+	}
+
+	for key, value := range person.References {
+		if nsManager.IsFullUri(key) || nsManager.IsFullUri(value.(string)) {
+			t.Errorf("expected reference to be prefixed, got '%s': '%s'", key, value)
+		}
+	}
+
+	near := place.References
+	for _, value := range near {
+		if nsManager.IsFullUri(value.([]string)[0]) {
+			t.Errorf("expected reference values to be prefixed, got '%s'", value.([]string)[0])
+		}
+	}
+
+	// invalid input is reported by Build
+	_, err = NewTransactionBuilder().AddEntity("", egdm.NewEntity()).Build()
+	if err == nil {
+		t.Error("expected error for empty dataset name")
+	}
+}
+
+func TestProcessTransactionStream(t *testing.T) {
+	received := make(map[string]int)
+	firstKey := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decoder := json.NewDecoder(r.Body)
+		if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if firstKey == "" {
+				firstKey = key.(string)
+			}
+
+			if key == "@context" {
+				var ctx map[string]any
+				err = decoder.Decode(&ctx)
+			} else {
+				var entities []*egdm.Entity
+				err = decoder.Decode(&entities)
+				received[key.(string)] += len(entities)
+			}
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txn := NewTransaction()
+	datasets := map[string]int{"people": 2500, "places": 1500}
+	for dataset, count := range datasets {
+		for i := 0; i < count; i++ {
+			entityId, err := txn.NamespaceManager.AssertPrefixedIdentifierFromURI(fmt.Sprintf("http://data.example.io/%s/%d", dataset, i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			txn.DatasetEntities[dataset] = append(txn.DatasetEntities[dataset], egdm.NewEntity().SetID(entityId))
+		}
+	}
+
+	err = client.ProcessTransactionStream(txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if firstKey != "@context" {
+		t.Errorf("expected context to be written first, got '%s'", firstKey)
+	}
+
+	for dataset, count := range datasets {
+		if received[dataset] != count {
+			t.Errorf("expected server to receive %d entities for %s, got %d", count, dataset, received[dataset])
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package datahub
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	egdm "github.com/mimiro-io/entity-graph-data-model"
+	"io"
+	"sort"
 )
 
 type Transaction struct {
@@ -30,6 +34,158 @@ func NewTransaction() *Transaction {
 	}
 }
 
+// TransactionBuilder is a builder for Transaction.
+// Entities can be added using full URIs, the builder manages the namespace prefixes.
+type TransactionBuilder struct {
+	transaction *Transaction
+	err         error
+}
+
+// NewTransactionBuilder creates a new TransactionBuilder.
+// Use the Add functions to add entities to datasets then call Build to get the Transaction
+func NewTransactionBuilder() *TransactionBuilder {
+	return &TransactionBuilder{transaction: NewTransaction()}
+}
+
+// AddEntity adds an entity to the named dataset.
+// The entity id, property and reference names and reference values can be full URIs or prefixed identifiers.
+// Full URIs are replaced in place with prefixed identifiers when Build is called
+func (tb *TransactionBuilder) AddEntity(dataset string, entity *egdm.Entity) *TransactionBuilder {
+	if tb.err != nil {
+		return tb
+	}
+
+	if dataset == "" {
+		tb.err = &ParameterError{Msg: "dataset name is required"}
+		return tb
+	}
+
+	if entity == nil {
+		tb.err = &ParameterError{Msg: "entity cannot be nil"}
+		return tb
+	}
+
+	tb.transaction.DatasetEntities[dataset] = append(tb.transaction.DatasetEntities[dataset], entity)
+	return tb
+}
+
+// AddEntityFromURI creates a new entity with the given URI as id and adds it to the named dataset.
+// returns the entity so that properties and references can be set on it.
+// returns a ParameterError if the dataset name is empty or a prefix cannot be created for the URI.
+func (tb *TransactionBuilder) AddEntityFromURI(dataset string, uri string) (*egdm.Entity, error) {
+	if dataset == "" {
+		return nil, &ParameterError{Msg: "dataset name is required"}
+	}
+
+	entityId, err := tb.transaction.NamespaceManager.AssertPrefixedIdentifierFromURI(uri)
+	if err != nil {
+		return nil, &ParameterError{Msg: "unable to create prefixed identifier for " + uri, Err: err}
+	}
+
+	entity := egdm.NewEntity().SetID(entityId)
+	tb.transaction.DatasetEntities[dataset] = append(tb.transaction.DatasetEntities[dataset], entity)
+	return entity, nil
+}
+
+// Build builds the Transaction.
+// returns a ParameterError if an invalid entity was added or a full URI cannot be converted to a prefixed identifier.
+func (tb *TransactionBuilder) Build() (*Transaction, error) {
+	if tb.err != nil {
+		return nil, tb.err
+	}
+
+	for _, entities := range tb.transaction.DatasetEntities {
+		for _, entity := range entities {
+			err := tb.compressEntity(entity)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return tb.transaction, nil
+}
+
+// compressEntity replaces full URIs in the entity id, property and reference names and reference values
+// with prefixed identifiers
+func (tb *TransactionBuilder) compressEntity(entity *egdm.Entity) error {
+	return compressEntity(tb.transaction.NamespaceManager, entity)
+}
+
+// compressEntity replaces full URIs in the entity id, property and reference names and reference values
+// with prefixed identifiers from the namespace manager, creating prefixes as needed
+func compressEntity(nsManager egdm.NamespaceManager, entity *egdm.Entity) error {
+	var err error
+	entity.ID, err = prefixedIdentifier(nsManager, entity.ID)
+	if err != nil {
+		return err
+	}
+
+	properties := make(map[string]any, len(entity.Properties))
+	for key, value := range entity.Properties {
+		prefixedKey, err := prefixedIdentifier(nsManager, key)
+		if err != nil {
+			return err
+		}
+		properties[prefixedKey] = value
+	}
+	entity.Properties = properties
+
+	references := make(map[string]any, len(entity.References))
+	for key, value := range entity.References {
+		prefixedKey, err := prefixedIdentifier(nsManager, key)
+		if err != nil {
+			return err
+		}
+
+		switch v := value.(type) {
+		case string:
+			value, err = prefixedIdentifier(nsManager, v)
+		case []string:
+			values := make([]string, len(v))
+			for i, ref := range v {
+				values[i], err = prefixedIdentifier(nsManager, ref)
+				if err != nil {
+					break
+				}
+			}
+			value = values
+		case []any:
+			values := make([]any, len(v))
+			for i, ref := range v {
+				values[i] = ref
+				if refString, ok := ref.(string); ok {
+					values[i], err = prefixedIdentifier(nsManager, refString)
+					if err != nil {
+						break
+					}
+				}
+			}
+			value = values
+		}
+		if err != nil {
+			return err
+		}
+		references[prefixedKey] = value
+	}
+	entity.References = references
+
+	return nil
+}
+
+// prefixedIdentifier returns the prefixed identifier for a full URI, or the value unchanged if it is not a full URI
+func prefixedIdentifier(nsManager egdm.NamespaceManager, value string) (string, error) {
+	if !nsManager.IsFullUri(value) {
+		return value, nil
+	}
+
+	prefixedId, err := nsManager.AssertPrefixedIdentifierFromURI(value)
+	if err != nil {
+		return "", &ParameterError{Msg: "unable to create prefixed identifier for " + value, Err: err}
+	}
+	return prefixedId, nil
+}
+
 // ProcessTransaction sends a transaction to the datahub
 // returns a ParameterError if the transaction is nil or cannot be serialiased
 // returns an AuthenticationError if the client is not authenticated
@@ -37,16 +193,77 @@ func NewTransaction() *Transaction {
 // Example usage: (error handling omitted for brevity)
 //
 //		txn := NewTransaction()
-//		entityId, err := txn.NamespaceManager.AssertPrefixFromURI("http://data.example.io/entity1")
+//		entityId, err := txn.NamespaceManager.AssertPrefixedIdentifierFromURI("http://data.example.io/entity1")
 //		entity := egdm.NewEntity().SetID(entityId)
 //		txn.DatasetEntities[datasetId1] = append(txn.DatasetEntities[datasetId1], entity)
 //		err = client.ProcessTransaction(txn)
 //	 	create another entity
-//	 	entityId2, err := txn.NamespaceManager.AssertPrefixFromURI("http://data.example.io/entity2")
+//	 	entityId2, err := txn.NamespaceManager.AssertPrefixedIdentifierFromURI("http://data.example.io/entity2")
 //	 	entity2 := egdm.NewEntity().SetID(entityId2)
 //	 	txn.DatasetEntities[datasetId2] = append(txn.DatasetEntities[datasetId2], entity2)
 //	 	err = client.ProcessTransaction(txn)
 func (c *Client) ProcessTransaction(transaction *Transaction) error {
+	_, err := c.ProcessTransactionWithResult(transaction)
+	return err
+}
+
+// TransactionResult is the response from the datahub after processing a transaction
+// TransactionID identifies the transaction on the server
+// DatasetCounts is the number of entities written to each dataset
+// TransactionIDs lists the id of every server transaction when the transaction was split into chunks
+type TransactionResult struct {
+	TransactionID  string         `json:"transactionId"`
+	DatasetCounts  map[string]int `json:"datasetCounts"`
+	TransactionIDs []string       `json:"-"`
+}
+
+// ProcessTransactionWithResult sends a transaction to the datahub and returns the parsed response
+// If the server does not report per dataset counts they are taken from the transaction that was sent
+// If the client is configured WithMaxTransactionEntities and the transaction is larger than the limit it is split
+// into several server transactions. Atomicity is only guaranteed within each chunk, if a chunk fails the chunks
+// already processed are not rolled back.
+// returns a ParameterError if the transaction is nil or cannot be serialiased
+// returns an AuthenticationError if the client is not authenticated
+// returns a RequestError if the transaction could not be processed
+// returns a ClientProcessingError if the response cannot be processed
+func (c *Client) ProcessTransactionWithResult(transaction *Transaction) (*TransactionResult, error) {
+	if transaction == nil {
+		return nil, &ParameterError{Msg: "transaction cannot be nil"}
+	}
+
+	if len(transaction.DatasetEntities) == 0 {
+		return nil, &ParameterError{Msg: "transaction must contain at least one dataset"}
+	}
+
+	result := &TransactionResult{DatasetCounts: make(map[string]int), TransactionIDs: make([]string, 0)}
+	for _, chunk := range transaction.split(c.maxTransactionEntities) {
+		chunkResult, err := c.processTransaction(chunk)
+		if err != nil {
+			return nil, err
+		}
+
+		if result.TransactionID == "" {
+			result.TransactionID = chunkResult.TransactionID
+		}
+		if chunkResult.TransactionID != "" {
+			result.TransactionIDs = append(result.TransactionIDs, chunkResult.TransactionID)
+		}
+		for dataset, count := range chunkResult.DatasetCounts {
+			result.DatasetCounts[dataset] += count
+		}
+	}
+
+	return result, nil
+}
+
+// ProcessTransactionStream sends a transaction to the datahub, writing the request body incrementally.
+// The namespace context is written first, followed by the entities of each dataset, one entity at a time.
+// Use this for large transactions to avoid building the whole request in memory. The transaction is always
+// sent as a single server transaction, WithMaxTransactionEntities does not apply.
+// returns a ParameterError if the transaction is nil or contains no datasets
+// returns an AuthenticationError if the client is not authenticated
+// returns a RequestError if the transaction could not be processed
+func (c *Client) ProcessTransactionStream(transaction *Transaction) error {
 	if transaction == nil {
 		return &ParameterError{Msg: "transaction cannot be nil"}
 	}
@@ -55,21 +272,149 @@ func (c *Client) ProcessTransaction(transaction *Transaction) error {
 		return &ParameterError{Msg: "transaction must contain at least one dataset"}
 	}
 
+	err := c.checkToken()
+	if err != nil {
+		return &AuthenticationError{Msg: "unable to authenticate", Err: err}
+	}
+
+	client := c.makeHttpClient()
+	reader, err := client.makeStreamingWriterRequest(httpPost, "/transactions", transaction.writeJSON, nil, nil)
+	if err != nil {
+		return &RequestError{Msg: "unable to process transaction", Err: err}
+	}
+
+	return reader.Close()
+}
+
+// writeJSON writes the transaction as JSON, starting with the namespace context and marshalling one entity at a time
+func (t *Transaction) writeJSON(writer io.Writer) error {
+	contextJson, err := json.Marshal(map[string]any{"namespaces": t.NamespaceManager.AsContext().Namespaces})
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(writer, `{"@context":%s`, contextJson)
+	if err != nil {
+		return err
+	}
+
+	for dataset, entities := range t.DatasetEntities {
+		datasetJson, err := json.Marshal(dataset)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(writer, ",%s:[", datasetJson)
+		if err != nil {
+			return err
+		}
+
+		for i, entity := range entities {
+			if i > 0 {
+				_, err = writer.Write([]byte(","))
+				if err != nil {
+					return err
+				}
+			}
+
+			entityJson, err := json.Marshal(entity)
+			if err != nil {
+				return err
+			}
+
+			_, err = writer.Write(entityJson)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = writer.Write([]byte("]"))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = writer.Write([]byte("}"))
+	return err
+}
+
+// processTransaction sends a single transaction to the datahub
+func (c *Client) processTransaction(transaction *Transaction) (*TransactionResult, error) {
 	data, err := json.Marshal(transaction.toGenericStructure())
 	if err != nil {
-		return &ParameterError{Msg: "transaction could not be serialized"}
+		return nil, &ParameterError{Msg: "transaction could not be serialized"}
 	}
 
 	err = c.checkToken()
 	if err != nil {
-		return &AuthenticationError{Msg: "unable to authenticate", Err: err}
+		return nil, &AuthenticationError{Msg: "unable to authenticate", Err: err}
 	}
 
 	client := c.makeHttpClient()
-	_, err = client.makeRequest(httpPost, "/transactions", data, nil, nil)
+	response, err := client.makeRequest(httpPost, "/transactions", data, nil, nil)
 	if err != nil {
-		return &RequestError{Msg: "unable to process transaction", Err: err}
+		return nil, &RequestError{Msg: "unable to process transaction", Err: err}
 	}
 
-	return nil
+	result := &TransactionResult{}
+	if len(bytes.TrimSpace(response)) > 0 {
+		err = json.Unmarshal(response, result)
+		if err != nil {
+			return nil, &ClientProcessingError{Msg: "unable to unmarshal transaction result", Err: err}
+		}
+	}
+
+	if result.DatasetCounts == nil {
+		result.DatasetCounts = make(map[string]int)
+		for dataset, entities := range transaction.DatasetEntities {
+			result.DatasetCounts[dataset] = len(entities)
+		}
+	}
+
+	return result, nil
+}
+
+// split divides the transaction into transactions of at most maxEntities entities each.
+// The namespace manager is shared by all chunks. returns the transaction itself if maxEntities is not positive
+// or the transaction is within the limit.
+func (t *Transaction) split(maxEntities int) []*Transaction {
+	total := 0
+	for _, entities := range t.DatasetEntities {
+		total += len(entities)
+	}
+
+	if maxEntities <= 0 || total <= maxEntities {
+		return []*Transaction{t}
+	}
+
+	// sort the datasets so that chunks are deterministic
+	datasets := make([]string, 0, len(t.DatasetEntities))
+	for dataset := range t.DatasetEntities {
+		datasets = append(datasets, dataset)
+	}
+	sort.Strings(datasets)
+
+	chunks := make([]*Transaction, 0)
+	current := &Transaction{NamespaceManager: t.NamespaceManager, DatasetEntities: make(map[string][]*egdm.Entity)}
+	size := 0
+	for _, dataset := range datasets {
+		entities := t.DatasetEntities[dataset]
+		for len(entities) > 0 {
+			if size == maxEntities {
+				chunks = append(chunks, current)
+				current = &Transaction{NamespaceManager: t.NamespaceManager, DatasetEntities: make(map[string][]*egdm.Entity)}
+				size = 0
+			}
+
+			n := min(maxEntities-size, len(entities))
+			current.DatasetEntities[dataset] = append(current.DatasetEntities[dataset], entities[:n]...)
+			entities = entities[n:]
+			size += n
+		}
+	}
+	if size > 0 {
+		chunks = append(chunks, current)
+	}
+
+	return chunks
 }
@@ -0,0 +1,107 @@
+package datahub
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	egdm "github.com/mimiro-io/entity-graph-data-model"
+)
+
+// ValidateEntities checks an entity collection before it is stored: every entity must have an id, every prefix
+// used in ids, property types, reference types and reference values must be defined by the namespace context of
+// the collection, and ids and reference values must expand to absolute URIs.
+// returns nil if the collection is valid.
+// returns a ParameterError if the collection is nil or not valid, the inner error lists the invalid entities.
+func ValidateEntities(collection *egdm.EntityCollection) error {
+	if collection == nil {
+		return &ParameterError{Msg: "entity collection cannot be nil"}
+	}
+
+	nsManager := collection.NamespaceManager
+	if nsManager == nil {
+		nsManager = egdm.NewNamespaceContext()
+	}
+
+	errs := make([]error, 0)
+	for i, entity := range collection.Entities {
+		if err := validateEntity(nsManager, entity); err != nil {
+			errs = append(errs, fmt.Errorf("entity %d: %w", i, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ParameterError{Msg: fmt.Sprintf("%d invalid entities", len(errs)), Err: errors.Join(errs...)}
+	}
+	return nil
+}
+
+// validateEntity returns the first problem found with the entity
+func validateEntity(nsManager egdm.NamespaceManager, entity *egdm.Entity) error {
+	if entity == nil {
+		return errors.New("entity is nil")
+	}
+
+	if entity.ID == "" {
+		return errors.New("id is empty")
+	}
+
+	if err := validateURI(nsManager, entity.ID); err != nil {
+		return fmt.Errorf("id %s: %w", entity.ID, err)
+	}
+
+	for key := range entity.Properties {
+		if _, err := nsManager.GetFullURI(key); err != nil {
+			return fmt.Errorf("%s property %s: %w", entity.ID, key, err)
+		}
+	}
+
+	for key, value := range entity.References {
+		if _, err := nsManager.GetFullURI(key); err != nil {
+			return fmt.Errorf("%s reference %s: %w", entity.ID, key, err)
+		}
+
+		var refs []string
+		switch ref := value.(type) {
+		case string:
+			refs = []string{ref}
+		case []string:
+			refs = ref
+		case []any:
+			for _, v := range ref {
+				s, ok := v.(string)
+				if !ok {
+					return fmt.Errorf("%s reference %s: value %v is not a string", entity.ID, key, v)
+				}
+				refs = append(refs, s)
+			}
+		default:
+			return fmt.Errorf("%s reference %s: unexpected value %v", entity.ID, key, value)
+		}
+
+		for _, ref := range refs {
+			if err := validateURI(nsManager, ref); err != nil {
+				return fmt.Errorf("%s reference %s value %s: %w", entity.ID, key, ref, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateURI checks that a prefixed or full identifier expands to an absolute URI
+func validateURI(nsManager egdm.NamespaceManager, value string) error {
+	fullURI, err := nsManager.GetFullURI(value)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := url.Parse(fullURI)
+	if err != nil {
+		return err
+	}
+	if !parsed.IsAbs() {
+		return fmt.Errorf("%s is not an absolute URI", fullURI)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package datahub
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	egdm "github.com/mimiro-io/entity-graph-data-model"
+)
+
+func TestValidateEntities(t *testing.T) {
+	newCollection := func(entities ...*egdm.Entity) *egdm.EntityCollection {
+		nsManager := egdm.NewNamespaceContext()
+		nsManager.StorePrefixExpansionMapping("ns0", "http://data.example.com/things/")
+		ec := egdm.NewEntityCollection(nsManager)
+		ec.Entities = entities
+		return ec
+	}
+
+	testCases := []struct {
+		name   string
+		entity *egdm.Entity
+		errMsg string
+	}{
+		{name: "valid", entity: egdm.NewEntity().SetID("ns0:e1").SetProperty("ns0:name", "e1").
+			SetReference("ns0:friends", []string{"ns0:e2", "http://data.example.com/other/e3"})},
+		{name: "missing id", entity: egdm.NewEntity(), errMsg: "id is empty"},
+		{name: "unknown id prefix", entity: egdm.NewEntity().SetID("ns9:e1"), errMsg: "id ns9:e1"},
+		{name: "unknown property prefix", entity: egdm.NewEntity().SetID("ns0:e1").SetProperty("ns9:name", "e1"), errMsg: "property ns9:name"},
+		{name: "unknown reference value prefix", entity: egdm.NewEntity().SetID("ns0:e1").SetReference("ns0:friend", "ns9:e2"), errMsg: "value ns9:e2"},
+		{name: "reference not a string", entity: egdm.NewEntity().SetID("ns0:e1").SetReference("ns0:friend", 42), errMsg: "reference ns0:friend"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateEntities(newCollection(tc.entity))
+			if tc.errMsg == "" {
+				if err != nil {
+					t.Errorf("expected the entity to be valid, got %v", err)
+				}
+				return
+			}
+			var paramErr *ParameterError
+			if !errors.As(err, &paramErr) || !strings.Contains(err.Error(), tc.errMsg) {
+				t.Errorf("expected a ParameterError containing '%s', got %v", tc.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestStoreEntitiesWithValidation(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.WithValidation()
+
+	ec := egdm.NewEntityCollection(egdm.NewNamespaceContext())
+	ec.Entities = []*egdm.Entity{egdm.NewEntity()}
+	err = client.StoreEntities("people", ec)
+	var paramErr *ParameterError
+	if !errors.As(err, &paramErr) {
+		t.Errorf("expected a ParameterError for an entity without an id, got %v", err)
+	}
+	if requests != 0 {
+		t.Errorf("expected an invalid collection not to be sent, got %d requests", requests)
+	}
+}